@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooksend"
+)
+
+// conformanceCheck is one request in the battery. Optional checks cover
+// features a receiver may legitimately leave out (key rotation, batches);
+// they are reported but do not fail the run.
+type conformanceCheck struct {
+	Name     string
+	Optional bool
+	Run      func(c *conformanceClient) error
+}
+
+type conformanceResult struct {
+	Check    string `json:"check"`
+	Optional bool   `json:"optional"`
+	Outcome  string `json:"outcome"` // PASS, FAIL or SKIP
+	Detail   string `json:"detail,omitempty"`
+}
+
+type conformanceClient struct {
+	url     string
+	sender  *webhooksend.Sender
+	rotated *webhooksend.Sender // nil without -rotated-secret
+}
+
+// errSkip marks a check that could not run with the given flags.
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+// testEvent returns an event of eventType and its ID.
+func testEvent(eventType string) ([]byte, string) {
+	id := webhooksend.NewID("evt_")
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":      id,
+		"type":    eventType,
+		"data":    map[string]interface{}{"conformance": true},
+		"created": time.Now().Unix(),
+	})
+	return body, id
+}
+
+// signedEvent returns a test event of eventType signed by s at the given
+// time.
+func signedEvent(s *webhooksend.Sender, eventType string, at time.Time) *webhooksend.Request {
+	body, id := testEvent(eventType)
+	return s.NewRequest(body, id, at)
+}
+
+func (c *conformanceClient) expectStatus(d *webhooksend.Request, ok func(int) bool, want string) error {
+	res, err := c.sender.Post(context.Background(), c.url, d)
+	if err != nil {
+		return err
+	}
+	if !ok(res.Status) {
+		return fmt.Errorf("got HTTP %d, want %s", res.Status, want)
+	}
+	return nil
+}
+
+func is2xx(status int) bool { return status >= 200 && status < 300 }
+func is401(status int) bool { return status == http.StatusUnauthorized }
+
+var conformanceChecks = []conformanceCheck{
+	{Name: "valid signature", Run: func(c *conformanceClient) error {
+		return c.expectStatus(signedEvent(c.sender, "conformance.valid", time.Now()), is2xx, "2xx")
+	}},
+	{Name: "tampered payload", Run: func(c *conformanceClient) error {
+		d := signedEvent(c.sender, "conformance.tampered", time.Now())
+		d.Body, _ = testEvent("conformance.tampered")
+		return c.expectStatus(d, is401, "401")
+	}},
+	{Name: "wrong secret", Run: func(c *conformanceClient) error {
+		d := signedEvent(webhooksend.New(webhooksend.NewID("")), "conformance.wrong_secret", time.Now())
+		return c.expectStatus(d, is401, "401")
+	}},
+	{Name: "missing signature headers", Run: func(c *conformanceClient) error {
+		d := signedEvent(c.sender, "conformance.unsigned", time.Now())
+		d.Signature, d.Timestamp = "", ""
+		return c.expectStatus(d, is401, "401")
+	}},
+	{Name: "malformed signature", Run: func(c *conformanceClient) error {
+		d := signedEvent(c.sender, "conformance.malformed", time.Now())
+		d.Signature = d.Signature[len("v1="):]
+		return c.expectStatus(d, is401, "401")
+	}},
+	{Name: "stale timestamp", Run: func(c *conformanceClient) error {
+		d := signedEvent(c.sender, "conformance.stale", time.Now().Add(-10*time.Minute))
+		return c.expectStatus(d, is401, "401")
+	}},
+	{Name: "future timestamp", Run: func(c *conformanceClient) error {
+		d := signedEvent(c.sender, "conformance.future", time.Now().Add(10*time.Minute))
+		return c.expectStatus(d, is401, "401")
+	}},
+	{Name: "rotated key", Optional: true, Run: func(c *conformanceClient) error {
+		if c.rotated == nil {
+			return errSkip("no -rotated-secret given")
+		}
+		d := signedEvent(c.rotated, "conformance.rotated", time.Now())
+		return c.expectStatus(d, is2xx, "2xx")
+	}},
+	{Name: "stripe-style verification", Run: func(c *conformanceClient) error {
+		body, _ := json.Marshal(map[string]interface{}{
+			"type":               "webhook.verification",
+			"verification_token": webhooksend.NewID("tok_"),
+			"created":            time.Now().Unix(),
+		})
+		return c.expectStatus(c.sender.NewRequest(body, "", time.Now()), is2xx, "2xx")
+	}},
+	{Name: "slack-style challenge", Run: func(c *conformanceClient) error {
+		challenge := webhooksend.NewID("")
+		body, _ := json.Marshal(map[string]interface{}{
+			"type":      "url_verification",
+			"challenge": challenge,
+			"token":     webhooksend.NewID("tok_"),
+		})
+		res, err := c.sender.Post(context.Background(), c.url, c.sender.NewRequest(body, "", time.Now()))
+		if err != nil {
+			return err
+		}
+		if !is2xx(res.Status) {
+			return fmt.Errorf("got HTTP %d, want 2xx", res.Status)
+		}
+		var echo struct {
+			Challenge string `json:"challenge"`
+		}
+		if err := json.Unmarshal(res.Body, &echo); err != nil {
+			return fmt.Errorf("response is not JSON: %v", err)
+		}
+		if echo.Challenge != challenge {
+			return fmt.Errorf("challenge not echoed: got %q", echo.Challenge)
+		}
+		return nil
+	}},
+	{Name: "batch payload", Optional: true, Run: func(c *conformanceClient) error {
+		var events []json.RawMessage
+		for i := 0; i < 3; i++ {
+			event, _ := testEvent("conformance.batch")
+			events = append(events, event)
+		}
+		body, _ := json.Marshal(map[string]interface{}{"events": events})
+		return c.expectStatus(c.sender.NewRequest(body, "", time.Now()), is2xx, "2xx")
+	}},
+}
+
+func runConformance(args []string) int {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8080/webhook", "receiver webhook URL")
+	secret := fs.String("secret", os.Getenv("WEBHOOK_SECRET"), "webhook secret (default $WEBHOOK_SECRET)")
+	rotated := fs.String("rotated-secret", "", "second active secret the receiver should also accept")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-request timeout")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	fs.Parse(args)
+
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "❌ A secret is required: set WEBHOOK_SECRET or pass -secret")
+		return 2
+	}
+
+	httpClient := &http.Client{Timeout: *timeout}
+	client := &conformanceClient{url: *url, sender: webhooksend.New(*secret)}
+	client.sender.Client = httpClient
+	if *rotated != "" {
+		client.rotated = webhooksend.New(*rotated)
+		client.rotated.Client = httpClient
+	}
+
+	failed := 0
+	var results []conformanceResult
+	for _, check := range conformanceChecks {
+		r := conformanceResult{Check: check.Name, Optional: check.Optional, Outcome: "PASS"}
+		if err := check.Run(client); err != nil {
+			if skip, ok := err.(errSkip); ok {
+				r.Outcome, r.Detail = "SKIP", string(skip)
+			} else {
+				r.Outcome, r.Detail = "FAIL", err.Error()
+				if !check.Optional {
+					failed++
+				}
+			}
+		}
+		results = append(results, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{
+			"url":     *url,
+			"passed":  failed == 0,
+			"results": results,
+		})
+	} else {
+		printConformance(*url, results, failed)
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printConformance(url string, results []conformanceResult, failed int) {
+	fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("🧪 Receiver conformance:", url)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		mark := map[string]string{"PASS": "✅", "FAIL": "❌", "SKIP": "⏭️ "}[r.Outcome]
+		name := r.Check
+		if r.Optional {
+			name += " (optional)"
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\n", mark, name, r.Outcome, r.Detail)
+	}
+	tw.Flush()
+
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	if failed == 0 {
+		fmt.Println("✅ Receiver is compatible")
+	} else {
+		fmt.Printf("❌ %d required check(s) failed\n", failed)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooksend"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookverify"
+)
+
+const (
+	testSecret = "0123456789abcdef0123456789abcdef"
+	oldSecret  = "fedcba9876543210fedcba9876543210"
+)
+
+// testReceiver answers like a receiver: 401 for deliveries that do not
+// verify, unless verify is false, the challenge for url_verification and
+// status otherwise. It also accepts oldSecret, as while rotating.
+func testReceiver(t *testing.T, verify bool, status int) *httptest.Server {
+	t.Helper()
+	v := webhookverify.New(testSecret)
+	v.Secrets = []string{oldSecret}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if _, err := v.CheckRequest(r, body); verify && err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var event struct {
+			Type      string `json:"type"`
+			Challenge string `json:"challenge"`
+		}
+		json.Unmarshal(body, &event)
+		if event.Type == "url_verification" {
+			json.NewEncoder(w).Encode(map[string]string{"challenge": event.Challenge})
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConformanceChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		verify  bool
+		status  int
+		rotated bool
+		want    map[string]string // outcome by check, PASS when not listed
+	}{
+		{name: "conforming receiver", verify: true, status: http.StatusOK,
+			want: map[string]string{"rotated key": "SKIP"}},
+		{name: "conforming receiver with rotated secret", verify: true, status: http.StatusAccepted, rotated: true},
+		{name: "receiver that does not verify", verify: false, status: http.StatusOK, rotated: true,
+			want: map[string]string{"tampered payload": "FAIL", "wrong secret": "FAIL", "missing signature headers": "FAIL",
+				"malformed signature": "FAIL", "stale timestamp": "FAIL", "future timestamp": "FAIL"}},
+		{name: "failing receiver", verify: true, status: http.StatusInternalServerError,
+			want: map[string]string{"valid signature": "FAIL", "rotated key": "SKIP", "stripe-style verification": "FAIL",
+				"batch payload": "FAIL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testReceiver(t, tt.verify, tt.status)
+			c := &conformanceClient{url: srv.URL, sender: webhooksend.New(testSecret)}
+			if tt.rotated {
+				c.rotated = webhooksend.New(oldSecret)
+			}
+			for _, check := range conformanceChecks {
+				got := "PASS"
+				err := check.Run(c)
+				if _, skip := err.(errSkip); skip {
+					got = "SKIP"
+				} else if err != nil {
+					got = "FAIL"
+				}
+				want := tt.want[check.Name]
+				if want == "" {
+					want = "PASS"
+				}
+				if got != want {
+					t.Errorf("%s: %s (%v), want %s", check.Name, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunConformance(t *testing.T) {
+	tests := []struct {
+		name   string
+		verify bool
+		args   []string
+		want   int
+	}{
+		{"conforming", true, nil, 0},
+		{"does not verify", false, nil, 1},
+		{"no secret", true, []string{"-secret", ""}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testReceiver(t, tt.verify, http.StatusOK)
+			args := append([]string{"-url", srv.URL, "-secret", testSecret, "-json"}, tt.args...)
+			if got := runConformance(args); got != tt.want {
+				t.Errorf("runConformance(%s) = %d, want %d", strings.Join(args, " "), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookverify"
+)
+
+// capturedRequest mirrors an entry of the receiver's GET /debug/requests.
+type capturedRequest struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+	Status  int                 `json:"status"`
+}
+
+// fixture is a sanitized delivery, re-signed with a test secret. Body is
+// kept as a string because the signature covers its exact bytes.
+type fixture struct {
+	Name      string            `json:"name"`
+	EventType string            `json:"event_type"`
+	Secret    string            `json:"secret"`
+	Timestamp int64             `json:"timestamp"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+}
+
+// fixtureEpoch is the normalized time of the first fixture; later ones
+// are one second apart so ordering survives normalization.
+var fixtureEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// piiKeys are redacted wherever they appear in a payload, along with keys
+// that commonly hold credentials.
+var piiKeys = []string{
+	"email", "phone", "name", "first_name", "last_name", "firstname", "lastname",
+	"address", "street", "city", "zip", "postal_code", "ip", "ip_address",
+	"ssn", "card", "card_number", "iban", "dob", "birthdate",
+	"token", "secret", "password", "api_key",
+}
+
+var nonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+func normalizeKey(key string) string {
+	// Treat camelCase, snake_case and kebab-case alike.
+	var b strings.Builder
+	for i, r := range key {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return strings.Trim(nonAlnum.ReplaceAllString(b.String(), "_"), "_")
+}
+
+func isTimestampKey(key string) bool {
+	k := normalizeKey(key)
+	return k == "created" || k == "updated" || k == "timestamp" || k == "time" ||
+		strings.HasSuffix(k, "_at") || strings.HasSuffix(k, "_time")
+}
+
+type sanitizer struct {
+	redact map[string]bool
+	now    time.Time
+}
+
+// placeholder derives a stable replacement from the original value, so the
+// same customer redacts to the same placeholder across fixtures.
+func placeholder(key string, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	id := hex.EncodeToString(sum[:4])
+	if normalizeKey(key) == "email" || strings.Contains(value, "@") {
+		return "user-" + id + "@example.com"
+	}
+	return "redacted-" + id
+}
+
+func (s *sanitizer) clean(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = s.clean(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = s.clean(key, child)
+		}
+		return v
+	case string:
+		if s.redact[normalizeKey(key)] {
+			return placeholder(key, v)
+		}
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return s.now.Format(time.RFC3339)
+		}
+		return v
+	case float64:
+		// Unix seconds or milliseconds under a timestamp-looking key.
+		if isTimestampKey(key) && v > 1e9 {
+			if v > 1e12 {
+				return float64(s.now.UnixMilli())
+			}
+			return float64(s.now.Unix())
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func loadCaptures(in, from, token string) ([]capturedRequest, error) {
+	var r io.Reader
+	switch {
+	case in != "":
+		f, err := os.Open(in)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	case from != "":
+		req, err := http.NewRequest(http.MethodGet, from, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: HTTP %d", from, resp.StatusCode)
+		}
+		r = resp.Body
+	default:
+		return nil, fmt.Errorf("pass -in or -from")
+	}
+
+	var capture struct {
+		Requests []capturedRequest `json:"requests"`
+	}
+	if err := json.NewDecoder(r).Decode(&capture); err != nil {
+		return nil, fmt.Errorf("invalid capture: %v", err)
+	}
+	return capture.Requests, nil
+}
+
+func runFixtures(args []string) int {
+	fs := flag.NewFlagSet("fixtures", flag.ExitOnError)
+	in := fs.String("in", "", "capture file saved from GET /debug/requests")
+	from := fs.String("from", "", "fetch captures from this /debug/requests URL instead")
+	token := fs.String("token", os.Getenv("DEBUG_TOKEN"), "debug token for -from (default $DEBUG_TOKEN)")
+	out := fs.String("out", "testdata/webhooks", "directory to write fixtures to")
+	secret := fs.String("secret", "test-fixtures-secret", "test secret to re-sign fixtures with")
+	extra := fs.String("redact", "", "extra comma-separated payload keys to redact")
+	all := fs.Bool("all", false, "include rejected deliveries, not just 2xx ones")
+	fs.Parse(args)
+
+	captures, err := loadCaptures(*in, *from, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	redact := map[string]bool{}
+	for _, k := range piiKeys {
+		redact[k] = true
+	}
+	for _, k := range strings.Split(*extra, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			redact[normalizeKey(k)] = true
+		}
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	// The capture buffer lists newest first; fixtures are numbered in
+	// arrival order.
+	written := 0
+	for i := len(captures) - 1; i >= 0; i-- {
+		c := captures[i]
+		if c.Method != http.MethodPost || c.Body == "" {
+			continue
+		}
+		if !*all && (c.Status < 200 || c.Status >= 300) {
+			continue
+		}
+
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(c.Body), &payload); err != nil {
+			fmt.Printf("⏭️  Skipping non-JSON body from %s\n", c.Path)
+			continue
+		}
+
+		n := written + 1
+		s := &sanitizer{redact: redact, now: fixtureEpoch.Add(time.Duration(written) * time.Second)}
+		s.clean("", payload)
+		eventType, _ := payload["type"].(string)
+		if _, ok := payload["id"]; ok {
+			payload["id"] = fmt.Sprintf("evt_fixture_%03d", n)
+		}
+		if _, ok := payload["verification_token"]; ok {
+			payload["verification_token"] = fmt.Sprintf("tok_fixture_%03d", n)
+		}
+
+		body, _ := json.Marshal(payload)
+		ts := s.now.Unix()
+		f := fixture{
+			Name:      fmt.Sprintf("%03d-%s", n, strings.Trim(nonAlnum.ReplaceAllString(strings.ToLower(eventType), "-"), "-")),
+			EventType: eventType,
+			Secret:    *secret,
+			Timestamp: ts,
+			Headers: map[string]string{
+				"Content-Type":        "application/json",
+				"X-Webhook-Id":        fmt.Sprintf("wh_fixture_%03d", n),
+				"X-Webhook-Timestamp": strconv.FormatInt(ts, 10),
+				"X-Webhook-Signature": webhookverify.Sign(*secret, ts, body),
+			},
+			Body: string(body),
+		}
+		if eventType == "" {
+			f.Name = fmt.Sprintf("%03d-event", n)
+		}
+
+		data, _ := json.MarshalIndent(f, "", "  ")
+		path := filepath.Join(*out, f.Name+".json")
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		fmt.Printf("📝 %s\n", path)
+		written++
+	}
+
+	fmt.Printf("✅ Wrote %d fixture(s), signed with %q\n", written, *secret)
+	fmt.Println("   Timestamps are normalized to 2024-01-01, so verify them with a fixed clock")
+	fmt.Println("   (FIXED_CLOCK=2024-01-01T00:00:00Z for the receiver) or re-sign at test time.")
+	return 0
+}
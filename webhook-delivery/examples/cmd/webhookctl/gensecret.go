@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runGenSecret prints webhook secrets in the format Codehooks issues:
+// hex-encoded random bytes, without a prefix. -prefix adds one for
+// senders whose secrets have one, such as "whsec_"; receivers then use
+// the whole string, prefix included, as the key.
+func runGenSecret(args []string) int {
+	fs := flag.NewFlagSet("gen-secret", flag.ExitOnError)
+	size := fs.Int("bytes", 32, "random bytes per secret (at least 16)")
+	count := fs.Int("n", 1, "number of secrets to generate")
+	prefix := fs.String("prefix", "", "prefix for each secret; Codehooks secrets have none")
+	fs.Parse(args)
+
+	if *size < 16 {
+		fmt.Fprintln(os.Stderr, "❌ -bytes must be at least 16")
+		return 2
+	}
+
+	for i := 0; i < *count; i++ {
+		b := make([]byte, *size)
+		if _, err := rand.Read(b); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		fmt.Println(*prefix + hex.EncodeToString(b))
+	}
+	return 0
+}
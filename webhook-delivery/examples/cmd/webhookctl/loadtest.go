@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooksend"
+)
+
+type latencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type loadtestReport struct {
+	URL         string         `json:"url"`
+	TargetRPS   int            `json:"target_rps"`
+	Duration    string         `json:"duration"`
+	Sent        int            `json:"sent"`
+	Dropped     int            `json:"dropped"` // ticks skipped because -concurrency was exhausted
+	Succeeded   int            `json:"succeeded"`
+	Failed      int            `json:"failed"`
+	Errors      int            `json:"errors"` // transport errors and timeouts
+	AchievedRPS float64        `json:"achieved_rps"`
+	ErrorRate   float64        `json:"error_rate"`
+	StatusCodes map[string]int `json:"status_codes"`
+	LatencyMs   latencyReport  `json:"latency_ms"`
+}
+
+type loadtestCollector struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+func (c *loadtestCollector) record(res *webhooksend.Response, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.errors++
+		return
+	}
+	c.statuses[res.Status]++
+	c.latencies = append(c.latencies, res.Latency)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())) / 1000
+}
+
+func runLoadtest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8080/webhook", "receiver webhook URL")
+	secret := fs.String("secret", os.Getenv("WEBHOOK_SECRET"), "webhook secret (default $WEBHOOK_SECRET)")
+	rps := fs.Int("rps", 50, "requests per second to sustain")
+	duration := fs.Duration("duration", 30*time.Second, "how long to run")
+	concurrency := fs.Int("concurrency", 200, "maximum requests in flight")
+	eventType := fs.String("event-type", "loadtest.event", "event type to send")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-request timeout")
+	reportPath := fs.String("report", "", "write the JSON report to this file (\"-\" for stdout)")
+	maxP99 := fs.Duration("max-p99", 0, "fail if p99 latency exceeds this (0 disables)")
+	maxErrorRate := fs.Float64("max-error-rate", -1, "fail if the error rate (0-1) exceeds this (negative disables)")
+	fs.Parse(args)
+
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "❌ A secret is required: set WEBHOOK_SECRET or pass -secret")
+		return 2
+	}
+	if *rps <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "❌ -rps and -concurrency must be positive")
+		return 2
+	}
+
+	sender := webhooksend.New(*secret)
+	sender.Client = &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: *concurrency},
+	}
+	collector := &loadtestCollector{statuses: map[int]int{}}
+	slots := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+
+	// With -report -, stdout carries the JSON report alone, so it can be
+	// piped; the rest goes to stderr.
+	var out io.Writer = os.Stdout
+	if *reportPath == "-" {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "🚀 Sending %d req/s to %s for %s\n", *rps, *url, *duration)
+
+	// Open-loop pacing: a slow receiver must not lower the offered load,
+	// so ticks that find every slot busy are counted as dropped instead of
+	// waiting.
+	sent, dropped := 0, 0
+	ticker := time.NewTicker(time.Second / time.Duration(*rps))
+	deadline := time.After(*duration)
+	start := time.Now()
+loop:
+	for {
+		select {
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+			select {
+			case slots <- struct{}{}:
+			default:
+				dropped++
+				continue
+			}
+			sent++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				body, id := testEvent(*eventType)
+				collector.record(sender.Post(context.Background(), *url, sender.NewRequest(body, id, time.Now())))
+			}()
+		}
+	}
+	ticker.Stop()
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(collector.latencies, func(i, j int) bool { return collector.latencies[i] < collector.latencies[j] })
+	lat := collector.latencies
+
+	report := loadtestReport{
+		URL:         *url,
+		TargetRPS:   *rps,
+		Duration:    duration.String(),
+		Sent:        sent,
+		Dropped:     dropped,
+		Errors:      collector.errors,
+		AchievedRPS: math.Round(float64(sent)/elapsed.Seconds()*100) / 100,
+		StatusCodes: map[string]int{},
+	}
+	for status, n := range collector.statuses {
+		report.StatusCodes[strconv.Itoa(status)] = n
+		if status >= 200 && status < 300 {
+			report.Succeeded += n
+		} else {
+			report.Failed += n
+		}
+	}
+	if sent > 0 {
+		report.ErrorRate = math.Round(float64(report.Failed+report.Errors)/float64(sent)*10000) / 10000
+	}
+	if len(lat) > 0 {
+		var total time.Duration
+		for _, d := range lat {
+			total += d
+		}
+		report.LatencyMs = latencyReport{
+			Min:  ms(lat[0]),
+			Mean: ms(total / time.Duration(len(lat))),
+			P50:  ms(percentile(lat, 50)),
+			P90:  ms(percentile(lat, 90)),
+			P95:  ms(percentile(lat, 95)),
+			P99:  ms(percentile(lat, 99)),
+			Max:  ms(lat[len(lat)-1]),
+		}
+	}
+
+	printLoadtest(out, report)
+
+	if *reportPath != "" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		data = append(data, '\n')
+		if *reportPath == "-" {
+			os.Stdout.Write(data)
+		} else if err := os.WriteFile(*reportPath, data, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to write report: %v\n", err)
+			return 1
+		} else {
+			fmt.Fprintf(out, "📝 Report written to %s\n", *reportPath)
+		}
+	}
+
+	exit := 0
+	if *maxP99 > 0 && percentile(lat, 99) > *maxP99 {
+		fmt.Fprintf(out, "❌ p99 latency %.1fms exceeds %s\n", report.LatencyMs.P99, *maxP99)
+		exit = 1
+	}
+	if *maxErrorRate >= 0 && report.ErrorRate > *maxErrorRate {
+		fmt.Fprintf(out, "❌ Error rate %.2f%% exceeds %.2f%%\n", report.ErrorRate*100, *maxErrorRate*100)
+		exit = 1
+	}
+	return exit
+}
+
+func printLoadtest(out io.Writer, r loadtestReport) {
+	fmt.Fprintln(out, "\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintln(out, "📊 Load test report")
+	fmt.Fprintln(out, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintf(out, "   Target:      %s\n", r.URL)
+	fmt.Fprintf(out, "   Offered:     %d req/s for %s\n", r.TargetRPS, r.Duration)
+	fmt.Fprintf(out, "   Sent:        %d (%.2f req/s, %d dropped)\n", r.Sent, r.AchievedRPS, r.Dropped)
+	fmt.Fprintf(out, "   Succeeded:   %d\n", r.Succeeded)
+	fmt.Fprintf(out, "   Failed:      %d non-2xx, %d errors (%.2f%%)\n", r.Failed, r.Errors, r.ErrorRate*100)
+	fmt.Fprintf(out, "   Latency ms:  min %.1f  mean %.1f  p50 %.1f  p90 %.1f  p95 %.1f  p99 %.1f  max %.1f\n",
+		r.LatencyMs.Min, r.LatencyMs.Mean, r.LatencyMs.P50, r.LatencyMs.P90, r.LatencyMs.P95, r.LatencyMs.P99, r.LatencyMs.Max)
+	fmt.Fprintln(out, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{sorted, 50, 50 * time.Millisecond},
+		{sorted, 99, 99 * time.Millisecond},
+		{sorted, 100, 100 * time.Millisecond},
+		{sorted, 0, time.Millisecond},
+		{sorted[:1], 99, time.Millisecond},
+		{sorted[:3], 50, 2 * time.Millisecond},
+		{nil, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%d values, %v) = %v, want %v", len(tt.sorted), tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestRunLoadtest(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		args        []string
+		want        int
+		wantFailed  bool
+		wantCodeKey string
+	}{
+		{"accepted", http.StatusOK, []string{"-max-error-rate", "0"}, 0, false, "200"},
+		{"errors over the limit", http.StatusServiceUnavailable, []string{"-max-error-rate", "0.5"}, 1, true, "503"},
+		{"errors without a limit", http.StatusServiceUnavailable, nil, 0, true, "503"},
+		{"p99 over the limit", http.StatusOK, []string{"-max-p99", "1ns"}, 1, false, "200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testReceiver(t, true, tt.status)
+			report := filepath.Join(t.TempDir(), "report.json")
+			args := append([]string{"-url", srv.URL, "-secret", testSecret, "-rps", "100", "-duration", "200ms",
+				"-report", report}, tt.args...)
+			if got := runLoadtest(args); got != tt.want {
+				t.Errorf("runLoadtest() = %d, want %d", got, tt.want)
+			}
+
+			data, err := os.ReadFile(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var r loadtestReport
+			if err := json.Unmarshal(data, &r); err != nil {
+				t.Fatalf("report is not JSON: %v", err)
+			}
+			if r.Sent == 0 || r.Errors != 0 || r.StatusCodes[tt.wantCodeKey] != r.Sent {
+				t.Errorf("report sent %d, errors %d, status codes %v, want every request answered %s",
+					r.Sent, r.Errors, r.StatusCodes, tt.wantCodeKey)
+			}
+			if failed := r.Failed == r.Sent && r.ErrorRate == 1; failed != tt.wantFailed {
+				t.Errorf("report failed %d of %d, error rate %v", r.Failed, r.Sent, r.ErrorRate)
+			}
+			if r.LatencyMs.Min > r.LatencyMs.P50 || r.LatencyMs.P50 > r.LatencyMs.P99 || r.LatencyMs.P99 > r.LatencyMs.Max {
+				t.Errorf("latencies %+v are not in order", r.LatencyMs)
+			}
+		})
+	}
+}
+
+func TestRunLoadtestFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-secret", ""},
+		{"-secret", testSecret, "-rps", "0"},
+		{"-secret", testSecret, "-concurrency", "-1"},
+	} {
+		if got := runLoadtest(args); got != 2 {
+			t.Errorf("runLoadtest(%q) = %d, want 2", args, got)
+		}
+	}
+}
+
+func TestPrintLoadtest(t *testing.T) {
+	var out bytes.Buffer
+	printLoadtest(&out, loadtestReport{URL: "http://localhost:8080/webhook", TargetRPS: 50, Duration: "30s", Sent: 1500,
+		AchievedRPS: 50, Succeeded: 1497, Failed: 3, ErrorRate: 0.002,
+		LatencyMs: latencyReport{Min: 1, Mean: 2.5, P50: 2, P90: 4, P95: 5, P99: 9.5, Max: 20}})
+	for _, want := range []string{"http://localhost:8080/webhook", "50 req/s for 30s", "Succeeded:   1497",
+		"3 non-2xx, 0 errors (0.20%)", "p99 9.5"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+webhookctl - command line tools for Codehooks webhook receivers
+
+Usage:
+	export WEBHOOK_SECRET="your_secret_here"
+	go run ./cmd/webhookctl conformance -url http://localhost:8080/webhook
+
+Commands:
+	conformance   Run signed requests against a receiver and report a pass/fail matrix
+	loadtest      Sustain a fixed rate of signed events and report latency percentiles
+	relay         Accept public deliveries and pass them to receivers that dial out
+	fixtures      Turn captured deliveries into sanitized, re-signed test fixtures
+	ping          Send one signed test event and explain the endpoint's answer
+	send          Send a signed event of any type, or a wrongly signed one
+	gen-secret    Generate random webhook secrets
+	scaffold      Generate a Go module with a receiver built on the library packages
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"conformance", "Run signed requests against a receiver and report a pass/fail matrix", runConformance},
+	{"loadtest", "Sustain a fixed rate of signed events and report latency percentiles", runLoadtest},
+	{"relay", "Accept public deliveries and pass them to receivers that dial out", runRelay},
+	{"fixtures", "Turn captured deliveries into sanitized, re-signed test fixtures", runFixtures},
+	{"ping", "Send one signed test event and explain the endpoint's answer", runPing},
+	{"send", "Send a signed event of any type, or a wrongly signed one", runSend},
+	{"gen-secret", "Generate random webhook secrets", runGenSecret},
+	{"scaffold", "Generate a Go module with a receiver built on the library packages", runScaffold},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: webhookctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'webhookctl <command> -h' for command flags.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+
+	if name != "-h" && name != "--help" && name != "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooksend"
+)
+
+// runPing sends one signed test event and explains the endpoint's answer.
+func runPing(args []string) int {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8080/webhook", "receiver webhook URL")
+	secret := fs.String("secret", os.Getenv("WEBHOOK_SECRET"), "webhook secret (default $WEBHOOK_SECRET)")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Parse(args)
+
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "❌ A secret is required: set WEBHOOK_SECRET or pass -secret")
+		return 2
+	}
+
+	sender := webhooksend.New(*secret)
+	sender.Client = &http.Client{Timeout: *timeout}
+	res, err := sender.SendTestEvent(context.Background(), *url)
+
+	verdict := pingVerdict(res, err)
+	if *asJSON {
+		out := map[string]interface{}{"url": *url, "ok": err == nil && res.OK(), "verdict": verdict}
+		if err != nil {
+			out["error"] = err.Error()
+		} else {
+			out["status"] = res.Status
+			out["latency_ms"] = res.Latency.Milliseconds()
+			out["webhook_id"] = res.WebhookID
+			out["event_id"] = res.EventID
+			out["body"] = string(res.Body)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	} else {
+		fmt.Println("🧪 Test delivery to", *url)
+		if err != nil {
+			fmt.Printf("❌ %s\n   %v\n", verdict, err)
+		} else {
+			fmt.Printf("   HTTP %d in %s (webhook %s, event %s)\n", res.Status, res.Latency.Round(time.Millisecond), res.WebhookID, res.EventID)
+			if body := strings.TrimSpace(string(res.Body)); body != "" {
+				fmt.Printf("   Response: %s\n", body)
+			}
+			mark := "❌"
+			if res.OK() {
+				mark = "✅"
+			}
+			fmt.Println(mark, verdict)
+		}
+	}
+
+	if err != nil || !res.OK() {
+		return 1
+	}
+	return 0
+}
+
+// pingVerdict says what an answer to a test delivery most likely means.
+func pingVerdict(res *webhooksend.Response, err error) string {
+	switch {
+	case err != nil:
+		return "Could not reach the endpoint"
+	case res.OK():
+		return "Endpoint verified and accepted the test event"
+	case res.Status == http.StatusUnauthorized || res.Status == http.StatusForbidden:
+		return "Signature rejected: the endpoint has a different secret, or its clock is off by more than 5 minutes"
+	case res.Status == http.StatusNotFound || res.Status == http.StatusMethodNotAllowed:
+		return "Wrong URL: the endpoint does not accept POST requests at this path"
+	case res.Status >= 500:
+		return "The endpoint failed while handling the event; check its logs"
+	default:
+		return fmt.Sprintf("Endpoint refused the test event with HTTP %d", res.Status)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Relay tunnel protocol, shared with the receiver's RELAY_ADDR listener:
+//
+//  1. The receiver dials the tunnel port and sends "<token>\n".
+//  2. While the connection is idle the relay sends relayHeartbeat every
+//     relayHeartbeatInterval so both sides notice dead connections.
+//  3. When a public client connects, the relay sends relayAcceptFrom and
+//     the client's address as "<ip>:<port>\n", and from then on copies
+//     bytes between the client and the tunnel connection. The receiver
+//     reports that address as the connection's remote address, so per-IP
+//     rate limits see the sender and not the relay. Relays before the
+//     address was added send relayAccept alone.
+const (
+	relayHeartbeat         = 0
+	relayAccept            = 1
+	relayAcceptFrom        = 2
+	relayHeartbeatInterval = 30 * time.Second
+)
+
+type relayServer struct {
+	token   string
+	clients chan net.Conn
+	wait    time.Duration
+}
+
+func runRelay(args []string) int {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	listen := fs.String("listen", ":8443", "public address that webhook senders connect to")
+	tunnel := fs.String("tunnel", ":9000", "address receivers dial out to")
+	token := fs.String("token", os.Getenv("RELAY_TOKEN"), "shared token receivers must present (default $RELAY_TOKEN)")
+	certFile := fs.String("tls-cert", "", "certificate for the tunnel port")
+	keyFile := fs.String("tls-key", "", "private key for the tunnel port")
+	wait := fs.Duration("wait", 10*time.Second, "how long a public connection waits for an idle tunnel")
+	fs.Parse(args)
+
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "❌ A token is required: set RELAY_TOKEN or pass -token")
+		return 2
+	}
+
+	tunnelLn, err := net.Listen("tcp", *tunnel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		tunnelLn = tls.NewListener(tunnelLn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+	publicLn, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	s := &relayServer{token: *token, clients: make(chan net.Conn), wait: *wait}
+	fmt.Printf("🔀 Relaying public %s to receivers connected on %s\n", *listen, *tunnel)
+
+	go func() {
+		for {
+			conn, err := tunnelLn.Accept()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ Tunnel listener: %v\n", err)
+				os.Exit(1)
+			}
+			go s.serveTunnel(conn)
+		}
+	}()
+
+	for {
+		client, err := publicLn.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Public listener: %v\n", err)
+			return 1
+		}
+		go s.handoff(client)
+	}
+}
+
+// handoff gives a public connection to the next idle tunnel, or closes it
+// if no receiver picks it up in time.
+func (s *relayServer) handoff(client net.Conn) {
+	select {
+	case s.clients <- client:
+	case <-time.After(s.wait):
+		fmt.Printf("⚠️  No receiver tunnel available for %s\n", client.RemoteAddr())
+		client.Close()
+	}
+}
+
+func (s *relayServer) serveTunnel(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(io.LimitReader(conn, 1024)).ReadString('\n')
+	if err != nil || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(line)), []byte(s.token)) != 1 {
+		fmt.Printf("🚫 Rejected tunnel from %s\n", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	heartbeat := time.NewTicker(relayHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-heartbeat.C:
+			if _, err := conn.Write([]byte{relayHeartbeat}); err != nil {
+				conn.Close()
+				return
+			}
+		case client := <-s.clients:
+			accept := append([]byte{relayAcceptFrom}, client.RemoteAddr().String()+"\n"...)
+			if _, err := conn.Write(accept); err != nil {
+				conn.Close()
+				go s.handoff(client)
+				return
+			}
+			splice(client, conn)
+			return
+		}
+	}
+}
+
+// splice copies in both directions until either side is done, then
+// closes both connections.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+//go:embed scaffold/*.tmpl
+var scaffoldFiles embed.FS
+
+// scaffoldEvent is one event type that gets a handler stub.
+type scaffoldEvent struct {
+	Type string // such as "order.created"
+	Func string // such as "handleOrderCreated"
+}
+
+// runScaffold writes a new Go module with a receiver built on the
+// webhookverify and webhookdedup packages, for users moving off the
+// single-file example.
+func runScaffold(args []string) int {
+	fs := flag.NewFlagSet("scaffold", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory to create (required)")
+	module := fs.String("module", "", "module path (default example.com/<dir name>)")
+	events := fs.String("events", "user.created,order.created", "comma-separated event types to stub handlers for")
+	fs.Parse(args)
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "❌ -dir is required")
+		return 2
+	}
+	if entries, err := os.ReadDir(*dir); err == nil && len(entries) > 0 {
+		fmt.Fprintf(os.Stderr, "❌ %s exists and is not empty\n", *dir)
+		return 2
+	}
+	name := filepath.Base(filepath.Clean(*dir))
+	if *module == "" {
+		*module = "example.com/" + name
+	}
+
+	data := struct {
+		Name   string
+		Module string
+		Events []scaffoldEvent
+	}{Name: name, Module: *module}
+	seen := map[string]bool{}
+	funcs := map[string]bool{"handleOther": true} // the fallback in handlers.go
+	for _, t := range strings.Split(*events, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		// Types that differ only in punctuation, such as order.created and
+		// order_created, would share a name; later ones get a number.
+		base := handlerFuncName(t)
+		name := base
+		for n := 2; funcs[name]; n++ {
+			name = fmt.Sprintf("%s%d", base, n)
+		}
+		funcs[name] = true
+		data.Events = append(data.Events, scaffoldEvent{Type: t, Func: name})
+	}
+
+	files := map[string]string{
+		"main.go":     "scaffold/main.go.tmpl",
+		"handlers.go": "scaffold/handlers.go.tmpl",
+		"config.json": "scaffold/config.json.tmpl",
+		"README.md":   "scaffold/README.md.tmpl",
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	for out, src := range files {
+		tmpl, err := template.ParseFS(scaffoldFiles, src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", out, err)
+			return 1
+		}
+		content := b.Bytes()
+		if strings.HasSuffix(out, ".go") {
+			if content, err = format.Source(content); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %s: %v\n", out, err)
+				return 1
+			}
+		}
+		if err := os.WriteFile(filepath.Join(*dir, out), content, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+	}
+	goMod := fmt.Sprintf("module %s\n\ngo 1.22\n", *module)
+	if err := os.WriteFile(filepath.Join(*dir, "go.mod"), []byte(goMod), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("✅ Created %s (module %s) with handlers for %d event type(s)\n", *dir, *module, len(data.Events))
+	fmt.Printf("\nNext:\n  cd %s\n  go mod tidy\n  WEBHOOK_SECRET=<secret Codehooks returned> go run .\n", *dir)
+	return 0
+}
+
+// handlerFuncName turns an event type into a Go function name:
+// "order.created" becomes handleOrderCreated.
+func handlerFuncName(eventType string) string {
+	var b strings.Builder
+	b.WriteString("handle")
+	upper := true
+	for _, r := range eventType {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
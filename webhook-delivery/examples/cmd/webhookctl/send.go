@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooksend"
+)
+
+// staleAge is how old -stale timestamps are: twice the default tolerance,
+// so a receiver with the default settings must reject them.
+const staleAge = 10 * time.Minute
+
+// runSend delivers one signed event to a receiver, optionally signed
+// wrongly to exercise its failure paths.
+func runSend(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8080/webhook", "receiver webhook URL")
+	secret := fs.String("secret", os.Getenv("WEBHOOK_SECRET"), "webhook secret (default $WEBHOOK_SECRET)")
+	eventType := fs.String("type", "webhook.test", "event type")
+	payload := fs.String("payload", "", "JSON file with the event's data, - for stdin (default {})")
+	raw := fs.String("raw", "", "file sent as the whole body, signed but otherwise unchanged")
+	webhookID := fs.String("id", "", "X-Webhook-Id, the subscription the delivery belongs to (default random)")
+	eventID := fs.String("event-id", "", "the event's id and X-Event-Id; send twice with the same -id to resend a delivery (default random)")
+	wrongSecret := fs.Bool("wrong-secret", false, "sign with a random secret; the receiver should answer 401")
+	stale := fs.Bool("stale", false, "sign with a timestamp "+staleAge.String()+" old; the receiver should answer 401")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	fs.Parse(args)
+
+	if *secret == "" && !*wrongSecret {
+		fmt.Fprintln(os.Stderr, "❌ A secret is required: set WEBHOOK_SECRET or pass -secret")
+		return 2
+	}
+	if *payload != "" && *raw != "" {
+		fmt.Fprintln(os.Stderr, "❌ -payload and -raw cannot be combined")
+		return 2
+	}
+
+	at := time.Now()
+	if *stale {
+		at = at.Add(-staleAge)
+	}
+	body, id, err := sendBody(*eventType, *payload, *raw, *eventID, at)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		return 2
+	}
+	sender := webhooksend.New(*secret)
+	if *wrongSecret {
+		sender.Secret = webhooksend.NewID("")
+	}
+	if *webhookID != "" {
+		sender.WebhookID = *webhookID
+	}
+	sender.Client = &http.Client{Timeout: *timeout}
+	d := sender.NewRequest(body, id, at)
+
+	expectReject := *wrongSecret || *stale
+	mode := "signed"
+	switch {
+	case *wrongSecret && *stale:
+		mode = "wrong secret, stale timestamp"
+	case *wrongSecret:
+		mode = "wrong secret"
+	case *stale:
+		mode = "stale timestamp"
+	}
+	fmt.Printf("📤 %s to %s (%s, webhook %s, event %s)\n", *eventType, *url, mode, d.WebhookID, d.EventID)
+	res, err := sender.Post(context.Background(), *url, d)
+	if err != nil {
+		fmt.Printf("❌ Could not reach the endpoint\n   %v\n", err)
+		return 1
+	}
+	fmt.Printf("   HTTP %d in %s\n", res.Status, res.Latency.Round(time.Millisecond))
+	if b := strings.TrimSpace(string(res.Body)); b != "" {
+		fmt.Printf("   Response: %s\n", b)
+	}
+
+	ok := res.Status >= 200 && res.Status < 300
+	switch {
+	case expectReject && res.Status == http.StatusUnauthorized:
+		fmt.Println("✅ Rejected, as it should be")
+		return 0
+	case expectReject && ok:
+		fmt.Println("❌ Accepted a delivery that should have been rejected: the receiver is not verifying signatures or timestamps")
+		return 1
+	case expectReject:
+		fmt.Printf("❌ Expected 401, got %d\n", res.Status)
+		return 1
+	case ok:
+		fmt.Println("✅ Accepted")
+		return 0
+	}
+	fmt.Println("❌", pingVerdict(res, nil))
+	return 1
+}
+
+// sendBody returns the body to send and its event ID: the -raw file as is,
+// with its "id" unless eventID is given, or an event of eventType with the
+// -payload file as its data.
+func sendBody(eventType, payload, raw, eventID string, at time.Time) ([]byte, string, error) {
+	if raw != "" {
+		body, err := readInput(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		if eventID == "" {
+			var event struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(body, &event)
+			eventID = event.ID
+		}
+		return body, eventID, nil
+	}
+	data := json.RawMessage("{}")
+	if payload != "" {
+		b, err := readInput(payload)
+		if err != nil {
+			return nil, "", err
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return nil, "", fmt.Errorf("%s: want a JSON object: %v", payload, err)
+		}
+		data = b
+	}
+	if eventID == "" {
+		eventID = webhooksend.NewID("evt_")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"id":      eventID,
+		"type":    eventType,
+		"data":    data,
+		"created": at.Unix(),
+	})
+	return body, eventID, err
+}
+
+// readInput reads name, or stdin for "-".
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSendBody(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	raw := file("raw.json", `{"id":"evt_raw","type":"order.created"}`)
+	data := file("data.json", `{"amount":42}`)
+	notObject := file("list.json", `[1,2]`)
+	at := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		payload   string
+		raw       string
+		eventID   string
+		wantID    string
+		wantBody  string // the whole body, or "" to check the generated event
+		wantData  string
+		wantError bool
+	}{
+		{name: "raw with its id", raw: raw, wantID: "evt_raw", wantBody: `{"id":"evt_raw","type":"order.created"}`},
+		{name: "raw with -event-id", raw: raw, eventID: "evt_other", wantID: "evt_other",
+			wantBody: `{"id":"evt_raw","type":"order.created"}`},
+		{name: "payload", payload: data, eventID: "evt_1", wantID: "evt_1", wantData: `{"amount":42}`},
+		{name: "default data", eventID: "evt_1", wantID: "evt_1", wantData: `{}`},
+		{name: "payload not an object", payload: notObject, wantError: true},
+		{name: "missing file", raw: filepath.Join(dir, "missing.json"), wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, id, err := sendBody("order.created", tt.payload, tt.raw, tt.eventID, at)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("sendBody() error = %v, want error %v", err, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+			if id != tt.wantID {
+				t.Errorf("sendBody() id = %q, want %q", id, tt.wantID)
+			}
+			if tt.wantBody != "" {
+				if string(body) != tt.wantBody {
+					t.Errorf("sendBody() body = %s, want %s", body, tt.wantBody)
+				}
+				return
+			}
+			var event struct {
+				ID      string          `json:"id"`
+				Type    string          `json:"type"`
+				Data    json.RawMessage `json:"data"`
+				Created int64           `json:"created"`
+			}
+			if err := json.Unmarshal(body, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.ID != tt.wantID || event.Type != "order.created" || string(event.Data) != tt.wantData ||
+				event.Created != at.Unix() {
+				t.Errorf("sendBody() body = %s, want event %s with data %s", body, tt.wantID, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestRunSend(t *testing.T) {
+	tests := []struct {
+		name   string
+		verify bool
+		status int
+		args   []string
+		want   int
+	}{
+		{"accepted", true, http.StatusOK, nil, 0},
+		{"wrong secret rejected", true, http.StatusOK, []string{"-wrong-secret"}, 0},
+		{"stale rejected", true, http.StatusOK, []string{"-stale"}, 0},
+		{"wrong secret accepted", false, http.StatusOK, []string{"-wrong-secret"}, 1},
+		{"stale answered otherwise", false, http.StatusBadRequest, []string{"-stale"}, 1},
+		{"receiver failing", true, http.StatusInternalServerError, nil, 1},
+		{"no secret", true, http.StatusOK, []string{"-secret", ""}, 2},
+		{"payload and raw", true, http.StatusOK, []string{"-payload", "a.json", "-raw", "b.json"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testReceiver(t, tt.verify, tt.status)
+			args := append([]string{"-url", srv.URL, "-secret", testSecret, "-type", "order.created"}, tt.args...)
+			if got := runSend(args); got != tt.want {
+				t.Errorf("runSend(%q) = %d, want %d", args, got, tt.want)
+			}
+		})
+	}
+
+	if got := runSend([]string{"-url", "http://127.0.0.1:1/webhook", "-secret", testSecret, "-timeout", "1s"}); got != 1 {
+		t.Errorf("runSend() to an unreachable receiver = %d, want 1", got)
+	}
+}
@@ -1,40 +1,405 @@
 /*
 Go Webhook Receiver Example
 
-Installation:
-	go get github.com/gorilla/mux
+Installation (go.mod is in this directory; Go 1.25 or later):
+	go mod download
 
 Usage:
-	export WEBHOOK_SECRET="whsec_your_secret_here"
+	export WEBHOOK_SECRET="your_secret_here"   # as Codehooks returned it
 	go run receiver-go.go
+
+	# check configuration and dependencies without serving
+	go run receiver-go.go diagnose
+
+	# run handlers again for failed or unfinished events in EVENT_LOG
+	go run receiver-go.go reprocess
+
+	# run SCRIPT_DIR handlers in a sandbox; the receiver starts this itself
+	receiver run-script [-cpu 1s] [-memory bytes] script.js < event.json
+
+Zero-downtime reload:
+	go build -o receiver receiver-go.go && ./receiver
+	# after replacing the binary:
+	kill -HUP <pid>
 */
 
 package main
 
 import (
-	"crypto/hmac"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"hash"
+	"html/template"
+	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookclock"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookdedup"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooklog"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookreplay"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookscript"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooksend"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooktime"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookverify"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// webhookSecrets holds WEBHOOK_SECRET, or every WEBHOOK_SECRETS entry
+	// with the current secret first.
+	webhookSecrets []string
+	verifier       *webhookverify.Verifier
+)
+
+// clock drives signature tolerance, dedup TTLs and the anomaly and SLO
+// schedulers. FIXED_CLOCK replaces it with a clock that never moves, so
+// replayed fixtures verify and periodic jobs stay quiet. Socket deadlines,
+// relay retry backoff and the reload handover use the wall clock
+// regardless.
+var (
+	clock      webhookclock.Clock = webhookclock.Real
+	fixedClock string
+)
+
+// secretMatches counts verified deliveries per matching secret index, so
+// an old secret can be removed once its count stops growing.
+var secretMatches = expvar.NewMap("secret_matches")
+
+// placeholderSecret is the documented example value, used when
+// WEBHOOK_SECRET is unset.
+const placeholderSecret = "your_secret_here"
+
+// allowWeakSecret lets the receiver start with a weak or placeholder
+// secret, for local experiments only.
+var allowWeakSecret bool
+
+// requireContentDigest rejects deliveries without a Content-Digest header.
+var requireContentDigest bool
+
+// contentDigests counts Content-Digest outcomes: verified, mismatch and
+// missing.
+var contentDigests = expvar.NewMap("content_digest")
+
+// payloadTypeLimits maps event types to their maximum payload size in
+// bytes; "*" applies to types without their own entry.
+var payloadTypeLimits map[string]int
+
+// checkPayloadSize returns an error if a payload of size bytes is over the
+// limit for eventType.
+func checkPayloadSize(eventType string, size int) error {
+	limit, ok := payloadTypeLimits[eventType]
+	if !ok {
+		limit = payloadTypeLimits["*"]
+	}
+	if limit > 0 && size > limit {
+		return fmt.Errorf("payload is %d bytes, limit is %d", size, limit)
+	}
+	return nil
+}
+
+// payloadSizeBuckets are the upper bounds of the payload size histogram.
+var payloadSizeBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// payloadSizes holds a size histogram per event type: cumulative counts
+// per "le_<bytes>" bucket plus "count" and "sum", like a Prometheus
+// histogram.
+var (
+	payloadSizes   = expvar.NewMap("payload_size_bytes")
+	payloadSizesMu sync.Mutex
+)
+
+func recordPayloadSize(eventType string, size int) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	payloadSizesMu.Lock()
+	histogram, ok := payloadSizes.Get(eventType).(*expvar.Map)
+	if !ok {
+		histogram = new(expvar.Map).Init()
+		payloadSizes.Set(eventType, histogram)
+	}
+	payloadSizesMu.Unlock()
+
+	for _, bound := range payloadSizeBuckets {
+		if size <= bound {
+			histogram.Add("le_"+strconv.Itoa(bound), 1)
+		}
+	}
+	histogram.Add("le_inf", 1)
+	histogram.Add("count", 1)
+	histogram.Add("sum", int64(size))
+}
+
+// parsePayloadTypeLimits parses "order.created=65536,*=1048576".
+func parsePayloadTypeLimits(spec string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		eventType, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || eventType == "" {
+			return nil, fmt.Errorf("invalid entry %q, want type=bytes", entry)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid size in %q", entry)
+		}
+		limits[eventType] = n
+	}
+	return limits, nil
+}
+
+// Payload decryption settings, see decryptPayload.
+var (
+	payloadKey              []byte
+	requireEncryptedPayload bool
+)
+
+// Request hardening settings, see hardenRequests.
+var (
+	strictRequests bool
+	maxHeaderBytes = 32 << 10
+)
+
+// maxBodyBytes bounds webhook request bodies; larger ones get a 413 before
+// they are read to the end.
+var maxBodyBytes int64 = 1 << 20
+
+// Compressed bodies, see newBodyDecoder. maxDecodedBytes bounds a body
+// after decompression. signedBody says whether signatures cover the body
+// as sent ("encoded") or after decompression ("decoded").
+var (
+	maxDecodedBytes int64 = 10 << 20
+	signedBody            = "decoded"
 )
 
-var webhookSecret string
+// Rate limiting settings, see rateLimiter. limiter is nil unless
+// RATE_LIMIT_PER_IP or RATE_LIMIT_GLOBAL is set.
+var (
+	limiter        *rateLimiter
+	trustedProxies []*net.IPNet
+)
+
+// rejectedRequests counts requests refused before reaching a handler, keyed
+// by reason. It is published with expvar on METRICS_ADDR.
+var rejectedRequests = expvar.NewMap("rejected_requests")
+
+// logger writes the receiver's log. LOG_FORMAT selects the console format
+// for people (the default), or "text" or "json" lines from log/slog for
+// log aggregators.
+var logger = slog.New(newConsoleHandler(os.Stdout))
+
+func newLogger(format string) (*slog.Logger, error) {
+	// Messages start with an emoji for the console; the structured formats
+	// drop it so messages stay easy to match on.
+	opts := &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.MessageKey && len(groups) == 0 {
+			a.Value = slog.StringValue(strings.TrimLeftFunc(a.Value.String(), func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			}))
+		}
+		return a
+	}}
+	switch format {
+	case "", "console":
+		return slog.New(newConsoleHandler(os.Stdout)), nil
+	case "text":
+		// Event data reads better as JSON than as a Go map.
+		replace := opts.ReplaceAttr
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if m, ok := a.Value.Any().(map[string]interface{}); ok {
+				j, _ := json.Marshal(m)
+				a.Value = slog.StringValue(string(j))
+			}
+			return replace(groups, a)
+		}
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid LOG_FORMAT: %q, want console, text or json", format)
+}
+
+// consoleHandler prints the message followed by its attributes as
+// key=value pairs, without time or level, for reading in a terminal.
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	attrs  []slog.Attr
+	prefix string // open groups, such as "request."
+}
+
+func newConsoleHandler(w io.Writer) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	for _, a := range h.attrs {
+		appendConsoleAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendConsoleAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+func appendConsoleAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			appendConsoleAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	// Empty values, such as a delivery without X-Webhook-Id, add nothing.
+	if a.Key == "" || (v.Kind() == slog.KindString && v.String() == "") {
+		return
+	}
+	var s string
+	switch x := v.Any().(type) {
+	case error:
+		s = x.Error()
+	case map[string]interface{}, []interface{}, []string:
+		j, _ := json.Marshal(x)
+		s = string(j)
+	default:
+		s = v.String()
+	}
+	if strings.ContainsAny(s, " \"=") && !strings.HasPrefix(s, "{") && !strings.HasPrefix(s, "[") {
+		s = strconv.Quote(s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
+
+// tracer creates the receiver's spans. Until serve installs an exporter it
+// is a no-op, so spans cost nothing when tracing is off.
+var tracer = otel.Tracer("github.com/RestDB/codehooks-io-templates/webhook-delivery/examples")
+
+// tracingEnabled is set when the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variable names an OTLP/HTTP collector,
+// such as http://localhost:4318.
+var tracingEnabled bool
+
+// otlpEndpoint returns the collector URL traces go to, or "".
+func otlpEndpoint() string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+}
+
+// startTracing exports spans to the configured collector and accepts W3C
+// traceparent headers from senders. The returned function flushes spans
+// still buffered.
+func startTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	// The exporter reads the endpoint, headers and timeout from the
+	// OTEL_EXPORTER_OTLP_* variables itself.
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute.KeyValue{}
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		attrs = append(attrs, attribute.String("service.name", "webhook-receiver"))
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(attrs...), resource.WithFromEnv(), resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// endSpan marks span as failed if err is set and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
 
 type Event struct {
 	ID      string                 `json:"id"`
 	Type    string                 `json:"type"`
 	Data    map[string]interface{} `json:"data"`
 	Created int64                  `json:"created"`
+
+	// OccurredAt is when the source says the event happened: Created for
+	// Codehooks events, the provider's own timestamp field otherwise, and
+	// the signing or arrival time when there is none. See eventTime.
+	OccurredAt time.Time `json:"-"`
 }
 
 type VerificationRequest struct {
@@ -48,135 +413,6449 @@ type ChallengeResponse struct {
 	Challenge string `json:"challenge"`
 }
 
-func verifyWebhookSignature(payload []byte, signature string, timestamp string) bool {
-	// Reject old requests (older than 5 minutes)
-	ts, err := strconv.ParseInt(timestamp, 10, 64)
+// secretWeakness explains why secret is unfit for production, or returns
+// "" if it looks strong enough. Codehooks issues 64 hex characters.
+func secretWeakness(secret string) string {
+	if secret == placeholderSecret {
+		return "WEBHOOK_SECRET is not set, the placeholder secret is in use"
+	}
+	s := secret
+	if len(s) < 24 {
+		return fmt.Sprintf("secret is %d characters long, want at least 24", len(s))
+	}
+
+	// Estimate entropy from character frequencies, so long but repetitive
+	// secrets like "aaaa..." or "abcabcabc..." are caught too.
+	freq := map[rune]float64{}
+	for _, r := range s {
+		freq[r]++
+	}
+	bitsPerChar := 0.0
+	for _, n := range freq {
+		p := n / float64(len(s))
+		bitsPerChar -= p * math.Log2(p)
+	}
+	if bits := bitsPerChar * float64(len(s)); len(freq) < 8 || bits < 80 {
+		return fmt.Sprintf("secret has low entropy (about %.0f bits, %d distinct characters)", bits, len(freq))
+	}
+	return ""
+}
+
+// weakestSecret runs secretWeakness over every configured secret and
+// returns the first problem found, or "".
+func weakestSecret() string {
+	for i, secret := range webhookSecrets {
+		if weakness := secretWeakness(secret); weakness != "" {
+			if len(webhookSecrets) > 1 {
+				return fmt.Sprintf("WEBHOOK_SECRETS entry %d: %s", i+1, weakness)
+			}
+			return weakness
+		}
+	}
+	return ""
+}
+
+// digestAlgorithms are the RFC 9530 algorithms checked in Content-Digest.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// checkContentDigest validates an RFC 9530 Content-Digest header such as
+// "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:" against body.
+// It returns false when the header carries no supported algorithm, and an
+// error when a supported digest does not match.
+func checkContentDigest(header string, body []byte) (bool, error) {
+	checked := false
+	for _, member := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			continue
+		}
+		newHash, known := digestAlgorithms[strings.ToLower(name)]
+		if !known {
+			continue
+		}
+		// Byte sequences are wrapped in colons; drop any parameters.
+		value, _, _ = strings.Cut(value, ";")
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return true, fmt.Errorf("malformed %s digest", name)
+		}
+		want, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return true, fmt.Errorf("malformed %s digest: %v", name, err)
+		}
+
+		h := newHash()
+		h.Write(body)
+		if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+			return true, fmt.Errorf("%s digest does not match body", name)
+		}
+		checked = true
+	}
+	return checked, nil
+}
+
+// newBodyDecoder returns a reader that decompresses body according to
+// its Content-Encoding, gzip or zstd. zstd frames may ask for a window of
+// up to 8MB, the most the format asks decoders to support; larger ones
+// are refused rather than allocated.
+func newBodyDecoder(encoding string, body io.Reader) (io.Reader, func(), error) {
+	if encoding == "zstd" {
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(8<<20))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decoder, decoder.Close, nil
+	}
+	decoder, err := gzip.NewReader(body)
 	if err != nil {
-		return false
+		return nil, nil, err
 	}
+	return decoder, func() { decoder.Close() }, nil
+}
 
-	currentTime := time.Now().Unix()
-	if currentTime-ts > 300 || ts-currentTime > 300 {
-		log.Println("⚠️  Request timestamp too old")
-		return false
+// rejectBody refuses a delivery whose body could not be read: too large
+// as sent, or not valid for its Content-Encoding.
+func rejectBody(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		rejectRequest(w, r, "body_too_large", "Payload too large", http.StatusRequestEntityTooLarge)
+	case r.Header.Get("Content-Encoding") != "":
+		rejectRequest(w, r, "invalid_encoding", "Body does not match its Content-Encoding", http.StatusBadRequest)
+	default:
+		writeRejection(w, http.StatusBadRequest, "unreadable_body", "Failed to read body")
+	}
+}
+
+// jweHeader is the protected header of a JWE compact serialization.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Zip string `json:"zip"`
+}
+
+// isJWE reports whether body looks like a JWE compact serialization:
+// five base64url segments separated by dots.
+func isJWE(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && body[0] != '{' && bytes.Count(body, []byte(".")) == 4
+}
+
+// decryptPayload decrypts a JWE compact payload encrypted directly with the
+// shared key ("alg": "dir", "enc": "A128GCM", "A192GCM" or "A256GCM").
+func decryptPayload(body []byte, key []byte) ([]byte, error) {
+	parts := strings.Split(string(bytes.TrimSpace(body)), ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("not a JWE compact payload")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWE header encoding: %v", err)
+	}
+	var header jweHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("invalid JWE header: %v", err)
+	}
+	if header.Alg != "dir" {
+		return nil, fmt.Errorf("unsupported JWE alg %q, only \"dir\" is supported", header.Alg)
+	}
+	if header.Zip != "" {
+		return nil, fmt.Errorf("unsupported JWE zip %q", header.Zip)
+	}
+	if want := map[string]int{"A128GCM": 16, "A192GCM": 24, "A256GCM": 32}[header.Enc]; want != len(key) {
+		return nil, fmt.Errorf("JWE enc %q does not match the %d-byte payload key", header.Enc, len(key))
+	}
+	if parts[1] != "" {
+		return nil, fmt.Errorf("JWE with \"dir\" must not carry an encrypted key")
 	}
 
-	// Compute expected signature
-	sigBasestring := fmt.Sprintf("%s.%s", timestamp, string(payload))
-	mac := hmac.New(sha256.New, []byte(webhookSecret))
-	mac.Write([]byte(sigBasestring))
-	expectedSignature := "v1=" + hex.EncodeToString(mac.Sum(nil))
+	var segments [3][]byte
+	for i, part := range parts[2:] {
+		if segments[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, fmt.Errorf("invalid JWE segment encoding: %v", err)
+		}
+	}
+	iv, ciphertext, tag := segments[0], segments[1], segments[2]
 
-	// Compare signatures using constant-time comparison
-	return subtle.ConstantTimeCompare([]byte(expectedSignature), []byte(signature)) == 1
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	// The additional authenticated data is the encoded protected header.
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %v", err)
+	}
+	return plaintext, nil
 }
 
+// dedup remembers processed delivery IDs for dedupTTL, so retried
+// deliveries are acknowledged without processing them twice. It is nil
+// when DEDUP_TTL is 0. See deliveryID.
+var (
+	dedup               webhookdedup.Store
+	dedupTTL            = 24 * time.Hour
+	duplicateDeliveries = expvar.NewInt("duplicate_deliveries")
+)
+
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
-	signature := r.Header.Get("X-Webhook-Signature")
-	timestamp := r.Header.Get("X-Webhook-Timestamp")
+	start := time.Now()
+
+	// /webhook takes Codehooks deliveries; /webhook/{provider} takes those
+	// of a source configured in PROVIDER_SECRETS.
+	var adapter webhookverify.ProviderAdapter = verifier
+	provider := mux.Vars(r)["provider"]
+	if provider != "" {
+		if adapter = providers[provider]; adapter == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
 	webhookID := r.Header.Get("X-Webhook-Id")
+	if provider != "" {
+		webhookID = "" // known once the delivery is verified
+	}
+
+	// A sender that traces its deliveries sends a traceparent header; the
+	// span below then joins the sender's trace.
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "webhook.receive", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("webhook.id", webhookID)))
+
+	// Every delivery ends with one line summing up its outcome.
+	log := logger.With("webhook_id", webhookID)
+	if provider != "" {
+		log = logger.With("provider", provider)
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	verification := "missing"
+	var event Event
+	defer func() {
+		attrs := []interface{}{"status", rec.status, "verification", verification,
+			"event_type", event.Type, "event_id", event.ID,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000}
+		if sc := span.SpanContext(); sc.IsSampled() {
+			attrs = append(attrs, "trace_id", sc.TraceID().String())
+		}
+		log.Info("📨 Webhook handled", attrs...)
+
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", rec.status),
+			attribute.String("webhook.verification", verification),
+			attribute.String("webhook.event_type", event.Type),
+			attribute.String("webhook.event_id", event.ID),
+		)
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+		span.End()
+	}()
+
+	// Once a lifecycle event ended the subscription, nothing the sender
+	// delivers can verify; say so instead of failing every signature.
+	if refusePaused(adapter.Name()) {
+		rejectRequest(w, r, "endpoint_paused", "Endpoint paused after its subscription ended", http.StatusGone)
+		return
+	}
+
+	// Senders that authenticate with a client certificate instead of HMAC
+	// send no signature headers.
+	certName, certOK := clientCertName(r)
+	unsigned := !adapter.Signed(r)
+	if unsigned && !certOK {
+		writeRejection(w, http.StatusUnauthorized, "missing_signature", "Missing signature headers")
+		return
+	}
+
+	rejectSignature := func(body []byte, err error) {
+		logDelivery(r, body, webhooklog.Record{Status: webhooklog.StatusRejected, VerifyError: err.Error()})
+		setup.rejected(err)
+		verification = "rejected"
+		log.Warn("❌ Invalid signature", "error", err)
+		writeRejection(w, http.StatusUnauthorized, signatureRejection(err), "Invalid signature")
+	}
 
-	if signature == "" || timestamp == "" {
-		http.Error(w, "Missing signature headers", http.StatusUnauthorized)
+	if r.ContentLength > maxBodyBytes {
+		rejectRequest(w, r, "body_too_large", "Payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		encoding = ""
+	}
+	if encoding != "" && encoding != "gzip" && encoding != "x-gzip" && encoding != "zstd" {
+		rejectRequest(w, r, "unsupported_encoding", "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
 		return
 	}
 
-	body, err := ioutil.ReadAll(r.Body)
+	// Codehooks deliveries are hashed as the body is read, in the same
+	// pass, and stale or malformed signature headers are refused before
+	// reading it at all. The body itself is still read into memory, since
+	// it is parsed, logged and forwarded.
+	var stream *webhookverify.Stream
+	if provider == "" && !unsigned {
+		var err error
+		if stream, err = verifier.NewStream(r); err != nil {
+			rejectSignature(nil, err)
+			return
+		}
+	}
+	// A compressed body is kept as sent too: Content-Digest covers it, and
+	// so does the signature when SIGNED_BODY is "encoded".
+	var wire bytes.Buffer
+	var reader io.Reader = r.Body
+	if encoding != "" {
+		reader = io.TeeReader(reader, &wire)
+	}
+	if stream != nil && (encoding == "" || signedBody == "encoded") {
+		reader = io.TeeReader(reader, stream)
+	}
+	wireReader := reader
+	if encoding != "" {
+		decoder, closeDecoder, err := newBodyDecoder(encoding, reader)
+		if err != nil {
+			rejectBody(w, r, err)
+			return
+		}
+		defer closeDecoder()
+		reader = io.LimitReader(decoder, maxDecodedBytes+1)
+		if stream != nil && signedBody == "decoded" {
+			reader = io.TeeReader(reader, stream)
+		}
+	}
+	body, err := io.ReadAll(reader)
+	if err == nil && encoding != "" {
+		_, err = io.Copy(io.Discard, wireReader) // bytes after the compressed stream
+	}
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		rejectBody(w, r, err)
+		return
+	}
+	if int64(len(body)) > maxDecodedBytes {
+		rejectRequest(w, r, "decompressed_too_large", "Decompressed payload too large", http.StatusRequestEntityTooLarge)
 		return
 	}
+	sent, signed := body, body
+	if encoding != "" {
+		sent = wire.Bytes()
+		if signedBody == "encoded" {
+			signed = sent
+		}
+	}
 
-	fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println("📨 Webhook received")
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	// Content-Digest is checked independently of the signature, to catch
+	// bodies altered in transit by proxies.
+	checked, err := checkContentDigest(r.Header.Get("Content-Digest"), sent)
+	switch {
+	case err != nil:
+		contentDigests.Add("mismatch", 1)
+		log.Warn("❌ Content-Digest check failed", "error", err)
+		writeRejection(w, http.StatusBadRequest, "content_digest_mismatch", "Content-Digest mismatch")
+		return
+	case checked:
+		contentDigests.Add("verified", 1)
+		log.Info("✅ Content-Digest verified")
+	case requireContentDigest:
+		contentDigests.Add("missing", 1)
+		log.Warn("❌ Content-Digest header missing")
+		writeRejection(w, http.StatusBadRequest, "content_digest_missing", "Content-Digest required")
+		return
+	default:
+		contentDigests.Add("missing", 1)
+	}
 
 	// Try to parse as verification request first
 	var verifyReq VerificationRequest
 	if err := json.Unmarshal(body, &verifyReq); err == nil {
 		if verifyReq.Type == "webhook.verification" {
-			fmt.Println("🔍 Webhook verification request (Stripe-style)")
-			fmt.Printf("   Token: %s\n", verifyReq.VerificationToken)
-			fmt.Println("✅ Responding with 200 OK\n")
+			log.Info("🔍 Webhook verification request (Stripe-style)", "token", verifyReq.VerificationToken)
+			setup.handshake("Stripe-style")
+			verification = "handshake"
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("OK"))
 			return
 		}
 
 		if verifyReq.Type == "url_verification" {
-			fmt.Println("🔍 URL verification request (Slack-style)")
-			fmt.Printf("   Challenge: %s\n", verifyReq.Challenge)
-			fmt.Println("✅ Responding with challenge\n")
+			log.Info("🔍 URL verification request (Slack-style)", "challenge", verifyReq.Challenge)
+			setup.handshake("Slack-style")
+			verification = "handshake"
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(ChallengeResponse{Challenge: verifyReq.Challenge})
 			return
 		}
 	}
 
-	// Verify signature
-	if !verifyWebhookSignature(body, signature, timestamp) {
-		fmt.Println("❌ Invalid signature!")
-		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+	// Verify signature. Unsigned deliveries have no signed time, so the
+	// SLO clock starts on arrival.
+	signedAt := clock.Now()
+	if unsigned {
+		verification = "client_cert"
+		log.Info("✅ Client certificate verified", "name", certName)
+	} else {
+		_, verifySpan := tracer.Start(ctx, "webhook.verify")
+		var result webhookverify.Result
+		if stream != nil {
+			result, err = stream.Check(r.Context())
+		} else {
+			result, err = adapter.CheckRequest(r, signed)
+		}
+		if err == nil {
+			verifySpan.SetAttributes(attribute.String("webhook.signature_scheme", result.Scheme))
+		}
+		endSpan(verifySpan, err)
+		if err != nil {
+			rejectSignature(body, err)
+			return
+		}
+
+		verification = "verified"
+		if !result.Timestamp.IsZero() {
+			signedAt = result.Timestamp
+		}
+		log.Info("✅ Signature verified", "scheme", result.Scheme)
+		if provider == "" {
+			secretMatches.Add(strconv.Itoa(result.SecretIndex), 1)
+			if len(webhookSecrets) > 1 {
+				log.Info("🔑 Matched secret", "secret", result.SecretIndex+1, "secrets", len(webhookSecrets))
+			}
+		}
+		if result.Rotated {
+			log.Warn("⚠️  Sender still signs with a previous secret")
+		}
+	}
+
+	// Provider deliveries are not Codehooks events: the adapter knows
+	// their delivery ID and type.
+	var providerType string
+	if provider != "" {
+		webhookID, providerType = adapter.Describe(r, body)
+		log = log.With("webhook_id", webhookID)
+		span.SetAttributes(attribute.String("webhook.id", webhookID))
+	}
+
+	// Decrypt after verifying: the signature covers the payload as sent.
+	raw := body
+	failed := func(reason string) {
+		logDelivery(r, raw, webhooklog.Record{Verified: true, Endpoint: adapter.Name(), Status: webhooklog.StatusFailed,
+			Error: reason})
+	}
+	if payloadKey != nil && isJWE(body) {
+		plaintext, err := decryptPayload(body, payloadKey)
+		if err != nil {
+			failed("invalid encrypted payload: " + err.Error())
+			log.Warn("❌ Error decrypting payload", "error", err)
+			writeRejection(w, http.StatusBadRequest, "invalid_encrypted_payload", "Invalid encrypted payload")
+			return
+		}
+		body = plaintext
+		log.Info("🔓 Payload decrypted")
+	} else if requireEncryptedPayload {
+		failed("unencrypted payload")
+		log.Warn("❌ Unencrypted payload rejected")
+		writeRejection(w, http.StatusBadRequest, "encryption_required", "Encrypted payload required")
 		return
 	}
 
-	fmt.Println("✅ Signature verified")
+	// A batch is verified once, as a whole, then each of its events goes
+	// through the steps below on its own.
+	if provider == "" {
+		if items, ok := batchItems(body); ok {
+			job := eventJob{WebhookID: webhookID, SignedAt: signedAt, Trace: span.SpanContext()}
+			handleBatch(ctx, w, r, log, job, items)
+			return
+		}
+	}
 
 	// Parse event
-	var event Event
-	if err := json.Unmarshal(body, &event); err != nil {
-		fmt.Printf("❌ Error parsing event: %v\n", err)
-		http.Error(w, "Invalid payload", http.StatusBadRequest)
+	_, parseSpan := tracer.Start(ctx, "webhook.parse")
+	event, err = parseEvent(provider, r.Header, body, webhookID, providerType, signedAt)
+	endSpan(parseSpan, err)
+	if err != nil {
+		failed("invalid payload: " + err.Error())
+		log.Warn("❌ Error parsing event", "error", err)
+		writeRejection(w, http.StatusBadRequest, "invalid_payload", "Invalid payload")
 		return
 	}
 
-	fmt.Println("📋 Event details:")
-	fmt.Printf("   ID: %s\n", event.ID)
-	fmt.Printf("   Type: %s\n", event.Type)
-	fmt.Printf("   Webhook ID: %s\n", webhookID)
+	// Only trust the ID once the signature is verified, or anyone could
+	// suppress a real delivery by sending its ID first.
+	job := eventJob{Event: event, WebhookID: webhookID, DeliveryID: deliveryID(webhookID, event.ID),
+		SignedAt: signedAt, Trace: span.SpanContext()}
+	// Provider events are not spilled: the log keeps their payload as the
+	// provider sent it, not as an Event. The record names the endpoint and
+	// the delivery ID, so reprocess decodes the payload as it was here.
+	out := acceptEvent(ctx, log, job, adapter.Name(), len(body), provider == "", func(rec webhooklog.Record) int64 {
+		rec.Verified, rec.EventID, rec.EventType = true, event.ID, event.Type
+		rec.Endpoint, rec.DeliveryID = adapter.Name(), job.DeliveryID
+		return logDelivery(r, raw, rec)
+	})
+	switch out.Code {
+	case "payload_too_large":
+		rejectRequest(w, r, out.Code, "Payload too large for event type", http.StatusRequestEntityTooLarge)
+	case "unexpected_event_type":
+		writeRejection(w, http.StatusUnprocessableEntity, out.Code, "Event type not accepted by this endpoint")
+	case "schema_violation":
+		writeRejectionBody(w, http.StatusUnprocessableEntity, rejection{Code: out.Code,
+			Message: "Event data does not match the schema for its type", Hint: rejectionHints[out.Code],
+			Errors: out.Problems})
+	case "queue_full":
+		w.Header().Set("Retry-After", "5")
+		rejectRequest(w, r, out.Code, "Queue full, retry later", http.StatusServiceUnavailable)
+	default:
+		switch out.Status {
+		case "failed":
+			http.Error(w, "Processing failed", http.StatusInternalServerError)
+		case "queued":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("Accepted"))
+		default:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("OK"))
+		}
+	}
+}
+
+// eventOutcome is what acceptEvent did with an event. Status is one of
+// the batchResult statuses. Code and Error say why an event was
+// "rejected", or "failed" with "queue_full", and Problems lists the
+// errors of a schema_violation.
+type eventOutcome struct {
+	Status   string
+	Code     string
+	Error    string
+	Problems []schemaError
+}
+
+// acceptEvent takes a verified and parsed event, single or from a batch,
+// through the steps every event goes through: the payload limit for its
+// type, deduplication, allowed types, its schema, lifecycle events, the
+// event log, relays, and then the queue or processEvent. record logs the
+// delivery with the given status and returns its log ID; spill says
+// whether a full queue may spill the event to the log.
+func acceptEvent(ctx context.Context, log *slog.Logger, job eventJob, endpoint string, size int, spill bool,
+	record func(webhooklog.Record) int64) eventOutcome {
+	event := job.Event
+	reject := func(code, reason string) eventOutcome {
+		return eventOutcome{Status: "rejected", Code: code, Error: reason}
+	}
+	duplicate := func() eventOutcome {
+		duplicateDeliveries.Add(1)
+		log.Info("♻️  Duplicate delivery, already accepted", "delivery_id", job.DeliveryID)
+		return eventOutcome{Status: "duplicate"}
+	}
+
+	recordPayloadSize(event.Type, size)
+	if err := checkPayloadSize(event.Type, size); err != nil {
+		record(webhooklog.Record{Status: webhooklog.StatusFailed, Error: err.Error()})
+		log.Warn("❌ Payload over the limit for its event type", "event_id", event.ID, "error", err)
+		return reject("payload_too_large", err.Error())
+	}
+
+	if dedup != nil && job.DeliveryID != "" {
+		seen, err := dedup.Contains(ctx, job.DeliveryID)
+		if err != nil {
+			log.Warn("⚠️  Dedup store unavailable, processing anyway", "error", err)
+		} else if seen {
+			return duplicate()
+		}
+	}
 
-	fmt.Println("\n📦 Event data:")
-	dataJSON, _ := json.MarshalIndent(event.Data, "   ", "  ")
-	fmt.Printf("   %s\n", string(dataJSON))
+	setup.event(event)
+	if !eventTypeAllowed(endpoint, event.Type) {
+		unexpectedEvents.Add(endpoint+"/"+event.Type, 1)
+		log.Warn("🚧 Event type not accepted by this endpoint", "endpoint", endpoint, "event_type", event.Type,
+			"action", unexpectedEventAction)
+		// They are logged as ignored whatever the action, so reprocess,
+		// which picks up failed records, leaves them alone.
+		switch unexpectedEventAction {
+		case "reject":
+			record(webhooklog.Record{Status: webhooklog.StatusIgnored, Error: "event type not accepted"})
+			return reject("unexpected_event_type", "event type not accepted by this endpoint")
+		case "dead-letter":
+			job.LogID = record(webhooklog.Record{Status: webhooklog.StatusIgnored, Error: "event type not accepted"})
+			deadLetters.add(job, fmt.Errorf("event type %q not accepted by %s", event.Type, endpoint))
+			return eventOutcome{Status: "dead-lettered"}
+		}
+		record(webhooklog.Record{Status: webhooklog.StatusIgnored})
+		return eventOutcome{Status: "ignored"}
+	}
+	if problems := validateEventData(event); problems != nil {
+		schemaViolations.Add(event.Type, 1)
+		log.Warn("🚫 Event data does not match its schema", "event_id", event.ID, "errors", len(problems),
+			"path", problems[0].Path, "error", problems[0].Message, "action", invalidEventAction)
+		reason := fmt.Sprintf("schema violation at %q: %s", problems[0].Path, problems[0].Message)
+		job.LogID = record(webhooklog.Record{Status: webhooklog.StatusFailed, Error: reason})
+		if invalidEventAction == "dead-letter" {
+			deadLetters.add(job, errors.New(reason))
+			return eventOutcome{Status: "dead-lettered"}
+		}
+		out := reject("schema_violation", reason)
+		out.Problems = problems
+		return out
+	}
+	// Only a lifecycle event the endpoint accepts pauses it. The event
+	// itself is processed as usual, so handlers can clean up after the
+	// subscription.
+	if isLifecycleEvent(endpoint, event.Type) {
+		pauseEndpoint(endpoint, event.Type, event.ID)
+	}
 
-	// Process your webhook here
-	// ...
+	if !reserveDelivery(ctx, log, job) {
+		return duplicate()
+	}
+	job.LogID = record(webhooklog.Record{Status: webhooklog.StatusReceived})
+	job.Size = size
+	relayEvent(job)
+	if queue != nil {
+		switch queue.accept(ctx, job, spill) {
+		case queueFull:
+			releaseDelivery(ctx, log, job)
+			return eventOutcome{Status: "failed", Code: "queue_full", Error: "queue full, retry later"}
+		case queueSpilled:
+			log.Info("💾 Queue full, spilled to the event log", "event_id", event.ID, "spilled", queue.spilled.Load())
+		default:
+			log.Info("📥 Queued for processing", "event_id", event.ID, "waiting", len(queue.jobs))
+		}
+		return eventOutcome{Status: "queued"}
+	}
+	if err := processEvent(ctx, job); err != nil {
+		log.Error("❌ Processing failed", "event_id", event.ID, "error", err)
+		return eventOutcome{Status: "failed", Error: "processing failed"}
+	}
+	return eventOutcome{Status: "processed"}
+}
 
-	fmt.Println("\n✅ Webhook processed successfully\n")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+// reserveDelivery records job's delivery ID before the event is queued or
+// processed, so a retry that arrives meanwhile is a duplicate. It reports
+// false for such a retry. processEvent releases the ID if processing
+// fails, so the sender's next retry is processed again.
+func reserveDelivery(ctx context.Context, log *slog.Logger, job eventJob) bool {
+	if dedup == nil || job.DeliveryID == "" {
+		return true
+	}
+	reserved, err := dedup.Reserve(ctx, job.DeliveryID, dedupTTL)
+	if err != nil {
+		log.Warn("⚠️  Dedup store unavailable, processing anyway", "error", err)
+		return true
+	}
+	return reserved
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	response := map[string]interface{}{
-		"status":  "ok",
-		"message": "Go webhook receiver",
-		"endpoints": map[string]string{
-			"webhook": "POST /webhook",
-		},
+// releaseDelivery forgets the delivery ID of an event that was not
+// processed.
+func releaseDelivery(ctx context.Context, log *slog.Logger, job eventJob) {
+	if dedup == nil || job.DeliveryID == "" {
+		return
+	}
+	if err := dedup.Remove(ctx, job.DeliveryID); err != nil {
+		log.Warn("⚠️  Failed to release delivery", "error", err)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
 }
 
-func main() {
-	webhookSecret = os.Getenv("WEBHOOK_SECRET")
-	if webhookSecret == "" {
-		webhookSecret = "whsec_your_secret_here"
+// maxBatchEvents caps the events in one batch.
+const maxBatchEvents = 1000
+
+// batchItems returns the events of a batch body: a JSON array of events,
+// or an object with an "events" array and no "type" of its own.
+func batchItems(body []byte) ([]json.RawMessage, bool) {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if json.Unmarshal(trimmed, &items) == nil {
+			return items, true
+		}
+		return nil, false
 	}
+	var batch struct {
+		Type   *string           `json:"type"`
+		Events []json.RawMessage `json:"events"`
+	}
+	if json.Unmarshal(body, &batch) != nil || batch.Type != nil || batch.Events == nil {
+		return nil, false
+	}
+	return batch.Events, true
+}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/webhook", webhookHandler).Methods("POST")
-	r.HandleFunc("/", homeHandler).Methods("GET")
+// batchResult is what became of one event of a batch. Status is
+// "processed", "queued", "duplicate", "ignored" or "dead-lettered" for
+// events that need nothing more from the sender, "rejected" for events
+// that would fail again, and "failed" for events that may succeed when
+// the batch is retried.
+type batchResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"` // rejection code, as for a single event
+	Error  string `json:"error,omitempty"`
+}
 
-	fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println("🎯 Go Webhook Receiver")
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println("✅ Server running on http://localhost:8080")
-	secretConfigured := webhookSecret != "whsec_your_secret_here"
-	fmt.Printf("⚙️  Secret configured: %v\n", secretConfigured)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-	fmt.Println("Waiting for webhooks...\n")
+// handleBatch accepts the events of a verified batch one by one, and
+// answers with what became of each. The status is 200, or 202 with
+// WORKERS, when every event went through; 207 when some were rejected and
+// 422 when all were; and 500, or 503 when the queue is full, when any
+// failed in a way a retry can fix. Each event is deduplicated on its own
+// delivery ID, "{X-Webhook-Id}/{event id}", so a retried batch only
+// processes the events that did not get through the first time.
+func handleBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, log *slog.Logger, batch eventJob, items []json.RawMessage) {
+	if len(items) > maxBatchEvents {
+		rejectRequest(w, r, "batch_too_large", fmt.Sprintf("Batch has %d events, the limit is %d", len(items), maxBatchEvents),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	results := make([]batchResult, len(items))
+	counts := map[string]int{}
+	queueFull := false
+	for i, item := range items {
+		results[i] = acceptBatchEvent(ctx, r, log, batch, i, item)
+		counts[results[i].Status]++
+		queueFull = queueFull || results[i].Code == "queue_full"
+	}
+
+	status := http.StatusOK
+	switch {
+	case counts["failed"] > 0 && queueFull:
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "5")
+	case counts["failed"] > 0:
+		status = http.StatusInternalServerError
+	case counts["rejected"] == len(items) && len(items) > 0:
+		status = http.StatusUnprocessableEntity
+	case counts["rejected"] > 0:
+		status = http.StatusMultiStatus
+	case counts["queued"] > 0:
+		status = http.StatusAccepted
+	}
+	attrs := []interface{}{"events", len(items)}
+	for _, outcome := range []string{"processed", "queued", "duplicate", "ignored", "dead-lettered", "rejected", "failed"} {
+		if counts[outcome] > 0 {
+			attrs = append(attrs, outcome, counts[outcome])
+		}
+	}
+	log.Info("📦 Batch handled", attrs...)
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": results})
+}
+
+// acceptBatchEvent parses one event of a batch and hands it to
+// acceptEvent, like a single event.
+func acceptBatchEvent(ctx context.Context, r *http.Request, log *slog.Logger, job eventJob, index int, item json.RawMessage) batchResult {
+	res := batchResult{Index: index}
+	// Items are logged as their own deliveries, each with the event's JSON
+	// as the payload.
+	record := func(rec webhooklog.Record) int64 {
+		rec.WebhookID, rec.Verified, rec.EventID, rec.EventType = job.WebhookID, true, res.ID, res.Type
+		rec.Endpoint, rec.DeliveryID = verifier.Name(), job.DeliveryID
+		return logDelivery(r, item, rec)
+	}
+
+	var event Event
+	if err := json.Unmarshal(item, &event); err != nil {
+		record(webhooklog.Record{Status: webhooklog.StatusFailed, Error: "invalid payload: " + err.Error()})
+		res.Status, res.Code, res.Error = "rejected", "invalid_payload", err.Error()
+		return res
+	}
+	res.ID, res.Type = event.ID, event.Type
+	event.OccurredAt = eventTime("", event, job.SignedAt)
+	if event.Created == 0 {
+		event.Created = event.OccurredAt.Unix()
+	}
+	job.Event = event
+	// Items without an ID of their own are not deduplicated: their
+	// position is not an ID, the same in every batch.
+	job.DeliveryID = deliveryID(job.WebhookID, event.ID)
+
+	out := acceptEvent(ctx, log, job, verifier.Name(), len(item), true, record)
+	res.Status, res.Code, res.Error = out.Status, out.Code, out.Error
+	return res
+}
+
+// Event types each endpoint accepts, from ALLOWED_EVENT_TYPES. Endpoints
+// are named after their adapter: "codehooks" for /webhook, the provider
+// for /webhook/{provider}. An endpoint without an entry accepts every
+// type. Other types are handled as unexpectedEventAction says: "ignore"
+// answers 200 without processing, "reject" answers 422, and
+// "dead-letter" answers 200 and parks the event in the dead-letter queue.
+var (
+	allowedEventTypes     map[string][]string
+	unexpectedEventAction = "ignore"
+	unexpectedEvents      = expvar.NewMap("unexpected_events")
+)
+
+// parseAllowedEventTypes parses "codehooks=order.*|user.created,stripe=invoice.*".
+func parseAllowedEventTypes(spec string) (map[string][]string, error) {
+	allowed := map[string][]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		endpoint, patterns, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || endpoint == "" || patterns == "" {
+			return nil, fmt.Errorf("invalid entry %q, want endpoint=type|type", entry)
+		}
+		if endpoint != verifier.Name() && providers[endpoint] == nil {
+			return nil, fmt.Errorf("unknown endpoint %q, want %s or a PROVIDER_SECRETS name", endpoint, verifier.Name())
+		}
+		allowed[endpoint] = append(allowed[endpoint], strings.Split(patterns, "|")...)
+	}
+	return allowed, nil
+}
+
+func eventTypeAllowed(endpoint, eventType string) bool {
+	patterns, ok := allowedEventTypes[endpoint]
+	if !ok {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchesPattern(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lifecycle events tell an endpoint that its subscription is over: the
+// app was uninstalled, the webhook deleted or its secret revoked. Whatever
+// the sender delivers afterwards would only fail verification, so the
+// endpoint is paused instead: its deliveries get 410 endpoint_paused, and
+// operators are told once, until it is resumed through the admin API.
+// LIFECYCLE_EVENTS sets the types per endpoint in the ALLOWED_EVENT_TYPES
+// format. There are no defaults: events such as Stripe's
+// account.application.deauthorized or GitHub's installation.deleted end
+// one tenant's subscription, not the endpoint's, and pausing on them
+// would refuse every other tenant's deliveries.
+var (
+	lifecycleEvents    map[string][]string
+	lifecycleNotifyURL string
+)
+
+// endpointPause records why an endpoint is paused.
+type endpointPause struct {
+	Reason   string    `json:"reason"` // the lifecycle event type, or "manual"
+	EventID  string    `json:"event_id,omitempty"`
+	PausedAt time.Time `json:"paused_at"`
+	Refused  int64     `json:"refused"` // deliveries answered 410 since
+}
+
+// Paused endpoints, by name. They are kept in memory, so a restart
+// resumes them.
+var (
+	pausesMu sync.Mutex
+	pauses   = map[string]*endpointPause{}
+)
+
+func isLifecycleEvent(endpoint, eventType string) bool {
+	for _, pattern := range lifecycleEvents[endpoint] {
+		if matchesPattern(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// pauseEndpoint pauses endpoint unless it already is, and tells the
+// operators. It reports whether the endpoint was running.
+func pauseEndpoint(endpoint, reason, eventID string) bool {
+	pausesMu.Lock()
+	if pauses[endpoint] != nil {
+		pausesMu.Unlock()
+		return false
+	}
+	pauses[endpoint] = &endpointPause{Reason: reason, EventID: eventID, PausedAt: clock.Now()}
+	pausesMu.Unlock()
+
+	logger.Warn("⏸️  Endpoint paused", "endpoint", endpoint, "reason", reason, "event_id", eventID)
+	if lifecycleNotifyURL != "" {
+		text := fmt.Sprintf("⏸️ Webhook endpoint %s paused after %s. Its deliveries get 410 until it is resumed with POST /debug/endpoints/%s/resume.",
+			endpoint, reason, endpoint)
+		go func() {
+			if err := notify(context.Background(), lifecycleNotifyURL, text); err != nil {
+				logger.Warn("⚠️  Could not send pause notification", "endpoint", endpoint, "error", err)
+			}
+		}()
+	}
+	return true
+}
+
+// resumeEndpoint resumes endpoint and reports whether it was paused.
+func resumeEndpoint(endpoint string) bool {
+	pausesMu.Lock()
+	defer pausesMu.Unlock()
+	if pauses[endpoint] == nil {
+		return false
+	}
+	delete(pauses, endpoint)
+	logger.Info("▶️  Endpoint resumed", "endpoint", endpoint)
+	return true
+}
+
+// refusePaused reports whether endpoint is paused, counting the delivery
+// it refuses if so.
+func refusePaused(endpoint string) bool {
+	pausesMu.Lock()
+	defer pausesMu.Unlock()
+	if p := pauses[endpoint]; p != nil {
+		p.Refused++
+		return true
+	}
+	return false
+}
+
+// Schemas for event data, from SCHEMA_DIR and RegisterSchema. An event
+// must pass every schema whose pattern matches its type; types without a
+// schema pass. Events that fail are handled as invalidEventAction says:
+// "reject" answers 422 with the errors, "dead-letter" answers 200 and
+// parks the event in the dead-letter queue.
+var (
+	schemasMu          sync.RWMutex
+	schemas            []eventSchema
+	schemaDir          string
+	invalidEventAction = "reject"
+	schemaViolations   = expvar.NewMap("schema_violations")
+)
+
+type eventSchema struct {
+	pattern string
+	schema  *jsonschema.Schema
+}
+
+// schemaError is one way event data breaks its schema. Path is a JSON
+// Pointer into the event's data, "" for the data as a whole.
+type schemaError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// RegisterSchema validates the data of events matching pattern, such as
+// "order.created" or "order.*", against schema, a JSON Schema document.
+// The draft is taken from its "$schema", 2020-12 when it has none.
+func RegisterSchema(pattern string, schema []byte) error {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return fmt.Errorf("schema %q: %v", pattern, err)
+	}
+	c := jsonschema.NewCompiler()
+	loc := "mem:///" + url.PathEscape(pattern) + ".json"
+	if err := c.AddResource(loc, doc); err != nil {
+		return fmt.Errorf("schema %q: %v", pattern, err)
+	}
+	compiled, err := c.Compile(loc)
+	if err != nil {
+		return fmt.Errorf("schema %q: %v", pattern, err)
+	}
+	schemasMu.Lock()
+	schemas = append(schemas, eventSchema{pattern: pattern, schema: compiled})
+	schemasMu.Unlock()
+	return nil
+}
+
+// loadSchemaDir compiles every .json file in dir as the schema for the
+// pattern its name spells, such as order.created.json or order.*.json.
+// Schemas may $ref files in subdirectories, which are not patterns
+// themselves.
+func loadSchemaDir(dir string) ([]eventSchema, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	var loaded []eventSchema
+	for _, entry := range entries {
+		pattern, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		compiled, err := c.Compile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, eventSchema{pattern: pattern, schema: compiled})
+	}
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("no .json schemas in %s", dir)
+	}
+	return loaded, nil
+}
+
+// validateEventData returns what is wrong with the data of event, nil
+// when it passes every schema for its type.
+func validateEventData(event Event) []schemaError {
+	var data interface{}
+	if event.Data != nil {
+		data = event.Data
+	}
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+	var problems []schemaError
+	for _, s := range schemas {
+		if !matchesPattern(s.pattern, event.Type) {
+			continue
+		}
+		err := s.schema.Validate(data)
+		var invalid *jsonschema.ValidationError
+		if !errors.As(err, &invalid) {
+			continue
+		}
+		for _, unit := range invalid.BasicOutput().Errors {
+			if unit.Error == nil {
+				continue
+			}
+			// A failed $ref or allOf only says "validation failed"; the
+			// errors under it say why.
+			switch unit.Error.Kind.(type) {
+			case *kind.Reference, *kind.Group, *kind.AllOf:
+				continue
+			}
+			problems = append(problems, schemaError{Path: unit.InstanceLocation, Message: unit.Error.String()})
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems
+}
+
+// checkSchema is validateEventData as an error naming the first problem,
+// for retries and reprocessing: data that was invalid when it arrived
+// stays invalid until the schema changes.
+func checkSchema(event Event) error {
+	problems := validateEventData(event)
+	if problems == nil {
+		return nil
+	}
+	return fmt.Errorf("schema violation at %q: %s", problems[0].Path, problems[0].Message)
+}
+
+// eventJob is a verified event waiting to be processed.
+type eventJob struct {
+	Event      Event
+	WebhookID  string // X-Webhook-Id: the subscription, for Codehooks deliveries
+	DeliveryID string // this event's delivery, across sender retries; see deliveryID
+	SignedAt   time.Time
+	LogID      int64 // event log record, 0 if not logged
+	Size       int   // payload bytes, counted against QUEUE_MAX_BYTES
+
+	// Trace is the span of the request that delivered the event, so
+	// processing on a worker joins the same trace.
+	Trace trace.SpanContext
+}
+
+// deliveryID identifies the delivery of one event across sender retries.
+// Codehooks sends the subscription's ID as X-Webhook-Id with every event,
+// so it takes the event ID too: "{X-Webhook-Id}/{event id}". Provider
+// deliveries carry their own delivery ID, which is also their event ID.
+// It is empty without a webhook ID or an event ID, and such deliveries are
+// not deduplicated: X-Webhook-Id alone would make every later event of
+// the subscription without an ID a duplicate of the first.
+func deliveryID(webhookID, eventID string) string {
+	switch {
+	case webhookID == "" || eventID == "":
+		return ""
+	case eventID == webhookID:
+		return webhookID
+	}
+	return webhookID + "/" + eventID
+}
+
+// eventLog is nil unless EVENT_LOG is set.
+var eventLog *webhooklog.Log
+
+// logDelivery appends a delivery to the event log and returns its ID. It
+// returns 0 when the log is off or the write fails: a broken log is
+// reported but never blocks deliveries.
+func logDelivery(r *http.Request, body []byte, rec webhooklog.Record) int64 {
+	if eventLog == nil {
+		return 0
+	}
+	if rec.WebhookID == "" {
+		rec.WebhookID = r.Header.Get("X-Webhook-Id")
+	}
+	rec.Headers = r.Header.Clone()
+	rec.Payload = string(body)
+	rec.ReceivedAt = clock.Now()
+	id, err := eventLog.Append(r.Context(), rec)
+	if err != nil {
+		logger.Warn("⚠️  Event log write failed", "error", err)
+		return 0
+	}
+	eventLogVersion.Add(1)
+	return id
+}
+
+// openEventLog opens EVENT_LOG, with payload bodies in EVENT_LOG_BODIES if
+// set. A read-only replica opens it for queries only.
+func openEventLog() (*webhooklog.Log, error) {
+	open := webhooklog.Open
+	if readOnly {
+		open = webhooklog.OpenReadOnly
+	}
+	l, err := open(eventLogPath)
+	if err != nil {
+		return nil, err
+	}
+	if eventLogBodies != "" {
+		l.Bodies = webhooklog.DirBodies(eventLogBodies)
+	}
+	return l, nil
+}
+
+// openReplayCache opens REPLAY_CACHE: "memory", or the path of a SQLite
+// file that keeps accepted signatures across restarts.
+func openReplayCache() (webhookverify.ReplayCache, func() error, error) {
+	if replayCachePath == "memory" {
+		return &webhookverify.MemoryReplayCache{Clock: clock}, func() error { return nil }, nil
+	}
+	cache, err := webhookreplay.Open(replayCachePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache.Clock = clock
+	return cache, cache.Close, nil
+}
+
+// useReplayCache turns on replay protection for every source that signs a
+// timestamp. The others are covered by deduplication only.
+func useReplayCache(cache webhookverify.ReplayCache) {
+	verifier.Replays = cache
+	for _, adapter := range providers {
+		switch a := adapter.(type) {
+		case *webhookverify.Stripe:
+			a.Replays = cache
+		case *webhookverify.Slack:
+			a.Replays = cache
+		}
+	}
+}
+
+func markDelivery(ctx context.Context, id int64, status string, errMsg string) {
+	if eventLog == nil || id == 0 {
+		return
+	}
+	if err := eventLog.SetStatus(ctx, id, status, errMsg); err != nil {
+		logger.Warn("⚠️  Event log update failed", "id", id, "error", err)
+	}
+	eventLogVersion.Add(1)
+}
+
+// processEvent runs the application logic for a verified event, in the
+// handler or, when WORKERS is set, on a worker after the 202.
+func processEvent(ctx context.Context, job eventJob) error {
+	event := job.Event
+	log := logger.With("webhook_id", job.WebhookID, "event_id", event.ID, "event_type", event.Type)
+	log.Info("📋 Processing event", "data", event.Data)
+
+	if volume != nil {
+		volume.record(event.Type)
+	}
+
+	// Register your handlers in registerHandlers. They get the span in ctx,
+	// so spans they start are nested under it.
+	handleCtx, span := tracer.Start(ctx, "webhook.handle", trace.WithAttributes(
+		attribute.String("webhook.id", job.WebhookID),
+		attribute.String("webhook.event_type", event.Type),
+		attribute.String("webhook.event_id", event.ID),
+	))
+	event, err := enrich(handleCtx, event)
+	if err == nil {
+		err = router.Dispatch(handleCtx, event)
+	}
+	endSpan(span, err)
+	if errors.Is(err, webhookscript.ErrLimit) {
+		// A retry would only run the script into its budget again, so the
+		// delivery is acknowledged and left in the dead-letter queue.
+		markDelivery(ctx, job.LogID, webhooklog.StatusFailed, err.Error())
+		deadLetters.add(job, err)
+		releaseDelivery(ctx, log, job)
+		scriptBreaches.Add(event.Type, 1)
+		log.Warn("🧯 Script handler stopped", "error", err)
+		return nil
+	}
+	if err != nil {
+		markDelivery(ctx, job.LogID, webhooklog.StatusFailed, err.Error())
+		deadLetters.add(job, err)
+		releaseDelivery(ctx, log, job)
+		return err
+	}
+	markDelivery(ctx, job.LogID, webhooklog.StatusProcessed, "")
+	deadLetters.remove(job)
+	recordDigests(event)
+	forward(job)
+
+	// Recorded again after processing, for events that were not reserved
+	// when accepted, such as reprocessed and dead-letter retries; the TTL
+	// counts from here.
+	if dedup != nil && job.DeliveryID != "" {
+		if err := dedup.Add(ctx, job.DeliveryID, dedupTTL); err != nil {
+			log.Warn("⚠️  Failed to record delivery", "error", err)
+		}
+	}
+	if slo != nil {
+		slo.recordProcessed(clock.Now().Sub(job.SignedAt))
+	}
+
+	log.Info("✅ Webhook processed successfully")
+	return nil
+}
+
+// EventHandler processes one event. An error fails the delivery: the
+// sender gets a 500 and retries, or with WORKERS the failure is logged.
+type EventHandler func(ctx context.Context, event Event) error
+
+// EventRouter dispatches events to handlers by type. A pattern is either
+// an exact type such as "order.created" or a prefix ending in "*" such as
+// "order.*" ("*" alone matches everything). An exact match wins over
+// patterns, a longer pattern over a shorter one, and the default handler
+// runs when nothing matches.
+type EventRouter struct {
+	mu       sync.RWMutex
+	exact    map[string]EventHandler
+	prefixes map[string]EventHandler // "order." for "order.*"
+	fallback EventHandler
+}
+
+func NewEventRouter() *EventRouter {
+	return &EventRouter{exact: map[string]EventHandler{}, prefixes: map[string]EventHandler{}}
+}
+
+// On registers h for pattern, replacing any handler already registered
+// for the same pattern.
+func (rt *EventRouter) On(pattern string, h EventHandler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		rt.prefixes[prefix] = h
+	} else {
+		rt.exact[pattern] = h
+	}
+}
+
+// Route returns the pattern whose handler Dispatch runs for eventType,
+// "default" for the default handler, and "" when there is no handler.
+func (rt *EventRouter) Route(eventType string) string {
+	_, pattern := rt.lookup(eventType)
+	return pattern
+}
+
+// lookup finds the handler for eventType: an exact pattern, else the
+// longest matching prefix, else the default.
+func (rt *EventRouter) lookup(eventType string) (EventHandler, string) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	if h, ok := rt.exact[eventType]; ok {
+		return h, eventType
+	}
+	var h EventHandler
+	longest := -1
+	for prefix, ph := range rt.prefixes {
+		if strings.HasPrefix(eventType, prefix) && len(prefix) > longest {
+			h, longest = ph, len(prefix)
+		}
+	}
+	if h != nil {
+		return h, eventType[:longest] + "*"
+	}
+	if rt.fallback != nil {
+		return rt.fallback, "default"
+	}
+	return nil, ""
+}
+
+// Default registers the handler for events no pattern matches.
+func (rt *EventRouter) Default(h EventHandler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.fallback = h
+}
+
+// Dispatch runs the handler for event.Type. Events with no handler and no
+// default are acknowledged without processing. A handler that panics is
+// reported as an error, so one bad event cannot take the receiver down.
+func (rt *EventRouter) Dispatch(ctx context.Context, event Event) (err error) {
+	h, _ := rt.lookup(event.Type)
+	if h == nil {
+		logger.Info("ℹ️  No handler for event type", "event_type", event.Type)
+		return nil
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return h(ctx, event)
+}
+
+// Typed event data. Handlers can take their event's data as a struct
+// instead of a map:
+//
+//	Handle(rt, "order.created", func(ctx context.Context, event Event, order OrderCreated) error {
+//		...
+//	})
+//
+// RegisterEventType declares which struct goes with which types, so that
+// decoding can be strict about fields the struct does not have.
+type eventDataType struct {
+	pattern string
+	typ     reflect.Type
+	strict  bool
+}
+
+var (
+	eventDataTypesMu sync.RWMutex
+	eventDataTypes   []eventDataType
+)
+
+// RegisterEventType declares T as the data of events matching pattern,
+// replacing any type registered for the same pattern. With strict,
+// DecodeEvent fails on data fields that T does not have, so a sender that
+// renames or adds fields is noticed instead of its data being dropped.
+func RegisterEventType[T any](pattern string, strict bool) {
+	t := eventDataType{pattern: pattern, typ: reflect.TypeOf((*T)(nil)).Elem(), strict: strict}
+	eventDataTypesMu.Lock()
+	defer eventDataTypesMu.Unlock()
+	for i, existing := range eventDataTypes {
+		if existing.pattern == pattern {
+			eventDataTypes[i] = t
+			return
+		}
+	}
+	eventDataTypes = append(eventDataTypes, t)
+}
+
+// registeredEventType returns the type registered for eventType, matched
+// as EventRouter matches handlers: an exact pattern, else the longest
+// prefix.
+func registeredEventType(eventType string) (eventDataType, bool) {
+	eventDataTypesMu.RLock()
+	defer eventDataTypesMu.RUnlock()
+	var found eventDataType
+	longest := -1
+	for _, t := range eventDataTypes {
+		if t.pattern == eventType {
+			return t, true
+		}
+		if matchesPattern(t.pattern, eventType) && len(t.pattern) > longest {
+			found, longest = t, len(t.pattern)
+		}
+	}
+	return found, longest >= 0
+}
+
+// DecodeEvent decodes event.Data into a T, as encoding/json would decode
+// the data object of the payload. It is strict when T is registered for
+// the event's type with strict set. An event type registered with a type
+// other than T is an error: the handler is most likely on the wrong
+// pattern.
+func DecodeEvent[T any](event Event) (T, error) {
+	var data T
+	want := reflect.TypeOf((*T)(nil)).Elem()
+	registered, ok := registeredEventType(event.Type)
+	if ok && registered.typ != want {
+		return data, fmt.Errorf("%s data is registered as %s, not %s", event.Type, registered.typ, want)
+	}
+	raw, err := json.Marshal(event.Data)
+	if err != nil {
+		return data, fmt.Errorf("encoding %s data: %w", event.Type, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if ok && registered.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&data); err != nil {
+		return data, fmt.Errorf("decoding %s data into %s: %w", event.Type, want, err)
+	}
+	return data, nil
+}
+
+// Handle registers h on rt for pattern, with the event data decoded by
+// DecodeEvent. Data that does not decode fails the event like an error
+// from h, so it is retried and dead-lettered in the same way.
+func Handle[T any](rt *EventRouter, pattern string, h func(ctx context.Context, event Event, data T) error) {
+	rt.On(pattern, func(ctx context.Context, event Event) error {
+		data, err := DecodeEvent[T](event)
+		if err != nil {
+			return err
+		}
+		return h(ctx, event, data)
+	})
+}
+
+// deadLetter is an event whose handler failed. Repeated failures of the
+// same delivery, from sender retries or retries from the dead-letter
+// endpoint, update one entry.
+type deadLetter struct {
+	ID            string    `json:"id"`
+	WebhookID     string    `json:"webhook_id"`
+	Event         Event     `json:"event"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+
+	job eventJob
+}
+
+// deadLetterQueue keeps the most recent failed events in memory until a
+// retry succeeds. With EVENT_LOG set, failures are also kept on disk with
+// status "failed" and can be retried after a restart with reprocess.
+type deadLetterQueue struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*deadLetter
+	order   []string // oldest first
+}
+
+var deadLetters = newDeadLetterQueue(1000)
+
+func newDeadLetterQueue(capacity int) *deadLetterQueue {
+	q := &deadLetterQueue{capacity: capacity, entries: map[string]*deadLetter{}}
+	expvar.Publish("dead_letters", expvar.Func(func() interface{} {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.entries)
+	}))
+	return q
+}
+
+// deadLetterID identifies a delivery across retries: its delivery ID, or
+// the event ID when the sender sent no webhook ID.
+func deadLetterID(job eventJob) string {
+	if job.DeliveryID != "" {
+		return job.DeliveryID
+	}
+	return "evt:" + job.Event.ID
+}
+
+func (q *deadLetterQueue) add(job eventJob, err error) {
+	id := deadLetterID(job)
+	now := clock.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d, ok := q.entries[id]
+	if !ok {
+		d = &deadLetter{ID: id, WebhookID: job.WebhookID, Event: job.Event, FirstFailedAt: now, job: job}
+		q.entries[id] = d
+		q.order = append(q.order, id)
+		if len(q.order) > q.capacity {
+			delete(q.entries, q.order[0])
+			q.order = q.order[1:]
+		}
+	}
+	d.Error = err.Error()
+	d.Attempts++
+	d.LastFailedAt = now
+	logger.Warn("🪦 Dead-lettered", "id", id, "attempts", d.Attempts, "error", err)
+}
+
+func (q *deadLetterQueue) remove(job eventJob) {
+	id := deadLetterID(job)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[id]; !ok {
+		return
+	}
+	delete(q.entries, id)
+	for i, other := range q.order {
+		if other == id {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// list returns the dead letters, oldest first.
+func (q *deadLetterQueue) list() []deadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := make([]deadLetter, 0, len(q.order))
+	for _, id := range q.order {
+		list = append(list, *q.entries[id])
+	}
+	return list
+}
+
+// retry processes a dead letter again. It stays queued, with one more
+// attempt counted, if the handler fails again.
+func (q *deadLetterQueue) retry(ctx context.Context, id string) (found bool, err error) {
+	q.mu.Lock()
+	d, ok := q.entries[id]
+	var job eventJob
+	if ok {
+		job = d.job
+	}
+	q.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	logger.Info("🔁 Retrying dead letter", "id", id)
+	if err := checkSchema(job.Event); err != nil {
+		return true, err
+	}
+	return true, processEvent(ctx, job)
+}
+
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := json.Marshal(map[string]interface{}{"dead_letters": deadLetters.list()})
+	writeJSONWithETag(w, r, body, etagOf(body))
+}
+
+func retryDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	found, err := deadLetters.retry(r.Context(), mux.Vars(r)["id"])
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case !found:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "no such dead letter"})
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"status": "failed", "error": err.Error()})
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"status": "processed"})
+	}
+}
+
+// endpointState is an endpoint as listed by GET /debug/endpoints.
+type endpointState struct {
+	Endpoint string `json:"endpoint"`
+	Path     string `json:"path"`
+	Paused   bool   `json:"paused"`
+	*endpointPause
+}
+
+func endpointsHandler(w http.ResponseWriter, r *http.Request) {
+	names := []string{verifier.Name()}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+
+	pausesMu.Lock()
+	states := make([]endpointState, len(names))
+	for i, name := range names {
+		states[i] = endpointState{Endpoint: name, Path: "/webhook/" + name}
+		if name == verifier.Name() {
+			states[i].Path = "/webhook"
+		}
+		if p := pauses[name]; p != nil {
+			copied := *p
+			states[i].Paused, states[i].endpointPause = true, &copied
+		}
+	}
+	pausesMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"endpoints": states})
+}
+
+// pauseEndpointHandler pauses an endpoint by hand, as a lifecycle event
+// would.
+func pauseEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := mux.Vars(r)["endpoint"]
+	if endpoint != verifier.Name() && providers[endpoint] == nil {
+		http.Error(w, fmt.Sprintf("Unknown endpoint %q", endpoint), http.StatusNotFound)
+		return
+	}
+	status := "paused"
+	if !pauseEndpoint(endpoint, "manual", "") {
+		status = "already paused"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+func resumeEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := mux.Vars(r)["endpoint"]
+	if endpoint != verifier.Name() && providers[endpoint] == nil {
+		http.Error(w, fmt.Sprintf("Unknown endpoint %q", endpoint), http.StatusNotFound)
+		return
+	}
+	status := "resumed"
+	if !resumeEndpoint(endpoint) {
+		status = "not paused"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// UserCreated is the data of a user.created event.
+type UserCreated struct {
+	UserID string `json:"userId"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+}
+
+// router holds the handlers from registerHandlers.
+var router = NewEventRouter()
+
+// registerHandlers is where your application's event handlers go. The
+// ones below only log; replace them with your own processing.
+func registerHandlers(rt *EventRouter) {
+	// Sent by `webhookctl ping` and webhooksend.SendTestEvent to check the
+	// configuration; the signature was verified before it got here.
+	rt.On("webhook.test", func(ctx context.Context, event Event) error {
+		logger.Info("🧪 Test event received, signing and verification work", "event_id", event.ID)
+		return nil
+	})
+	Handle(rt, "user.created", func(ctx context.Context, event Event, user UserCreated) error {
+		logger.Info("👤 User created", "user_id", user.UserID)
+		return nil
+	})
+	rt.On("order.*", func(ctx context.Context, event Event) error {
+		logger.Info("🛒 Order event", "event_type", event.Type, "order_id", event.Data["id"],
+			"occurred_at", event.OccurredAt.Format(time.RFC3339))
+		return nil
+	})
+	rt.Default(func(ctx context.Context, event Event) error {
+		logger.Info("ℹ️  No specific handler, acknowledged", "event_type", event.Type)
+		return nil
+	})
+}
+
+// JobFunc is a periodic job. Its context is cancelled when the receiver
+// shuts down.
+type JobFunc func(ctx context.Context) error
+
+// Scheduler runs registered jobs on cron schedules for as long as the
+// receiver serves. Runs of one job never overlap: a run that is due while
+// the previous one is still going is skipped. When several instances run,
+// only the one the Leader elects runs jobs.
+type Scheduler struct {
+	Leader Leader
+
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	stop    chan struct{}
+	running sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+type scheduledJob struct {
+	name   string
+	spec   *cronSpec
+	fn     JobFunc
+	next   time.Time
+	active bool
+}
+
+// Leader decides whether this instance runs scheduled jobs.
+type Leader interface {
+	IsLeader(ctx context.Context) bool
+}
+
+// soleLeader is the default Leader for a single instance. Deployments with
+// several receivers need a Leader backed by a shared lock, such as a Redis
+// key with a TTL, or every instance runs every job.
+type soleLeader struct{}
+
+func (soleLeader) IsLeader(context.Context) bool { return true }
+
+var jobRuns = expvar.NewMap("scheduled_jobs")
+
+// scheduler holds the jobs from registerJobs.
+var scheduler = &Scheduler{Leader: soleLeader{}}
+
+// RegisterJob adds a job that runs on spec: five cron fields (minute hour
+// day-of-month month day-of-week), one of @hourly, @daily, @weekly and
+// @monthly, or "@every <duration>". Times are in the local time zone.
+func (s *Scheduler) RegisterJob(name string, spec string, fn JobFunc) error {
+	cs, err := parseCron(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	if cs.next(clock.Now()).IsZero() {
+		return fmt.Errorf("job %s: schedule %q never runs", name, spec)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, &scheduledJob{name: name, spec: cs, fn: fn})
+	return nil
+}
+
+// start schedules every registered job from now on.
+func (s *Scheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.jobs) == 0 {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	now := clock.Now()
+	for _, j := range s.jobs {
+		j.next = j.spec.next(now)
+	}
+	go s.loop()
+}
+
+func (s *Scheduler) loop() {
+	timer := clock.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		var wake time.Time
+		for _, j := range s.jobs {
+			if wake.IsZero() || j.next.Before(wake) {
+				wake = j.next
+			}
+		}
+		s.mu.Unlock()
+
+		timer.Reset(wake.Sub(clock.Now()))
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C():
+		}
+
+		now := clock.Now()
+		// Ask before taking the lock: a shared-lock Leader makes a network
+		// call, and finishing jobs and shutdown need s.mu meanwhile. A
+		// follower still moves its due jobs on to their next run.
+		leader := s.Leader.IsLeader(s.ctx)
+		s.mu.Lock()
+		select {
+		case <-s.stop: // shutdown won the race for the lock
+			s.mu.Unlock()
+			return
+		default:
+		}
+		for _, j := range s.jobs {
+			if j.next.After(now) {
+				continue
+			}
+			j.next = j.spec.next(now)
+			if leader {
+				s.runLocked(j)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// runLocked starts one run of j. Callers hold s.mu and have checked that
+// this instance is the leader.
+func (s *Scheduler) runLocked(j *scheduledJob) {
+	if j.active {
+		jobRuns.Add(j.name+":skipped", 1)
+		logger.Warn("⏭️  Job still running, skipping this run", "job", j.name)
+		return
+	}
+	j.active = true
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		start := clock.Now()
+		err := func() (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("job panicked: %v", p)
+				}
+			}()
+			return j.fn(s.ctx)
+		}()
+		jobRuns.Add(j.name+":runs", 1)
+		elapsed := new(expvar.Int)
+		elapsed.Set(clock.Now().Sub(start).Milliseconds())
+		jobRuns.Set(j.name+":last_duration_ms", elapsed)
+		if err != nil {
+			jobRuns.Add(j.name+":failures", 1)
+			logger.Error("❌ Job failed", "job", j.name, "duration", clock.Now().Sub(start), "error", err)
+		}
+		s.mu.Lock()
+		j.active = false
+		s.mu.Unlock()
+	}()
+}
+
+// shutdown stops scheduling, cancels running jobs' context and waits for
+// them to return, or for ctx to end.
+func (s *Scheduler) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stop == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	close(s.stop)
+	s.cancel()
+	idle := true
+	for _, j := range s.jobs {
+		idle = idle && !j.active
+	}
+	s.mu.Unlock()
+	if idle {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduled jobs still running: %w", ctx.Err())
+	}
+}
+
+// cronSpec is a parsed schedule. Fields are bit sets of allowed values.
+type cronSpec struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if v, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a duration of at least 1s", spec)
+		}
+		return &cronSpec{every: d}, nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want 5 fields", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses "*", "5", "1-5", "*/15", "10-50/10" and comma
+// separated lists of those.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad range in %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first scheduled time after t.
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every schedule matches within four years (Feb 29 is the rarest day).
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			// By wall clock: Truncate works in absolute time, which is
+			// off the hour in zones such as +05:30.
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if !next.After(t) { // the hour after a DST change repeats
+				next = t.Add(time.Hour).Truncate(time.Minute)
+			}
+			t = next
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{} // never matches, such as "0 0 30 2 *"
+}
+
+// dayMatches applies cron's day rule: when both day fields are restricted,
+// either may match.
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// DigestRule rolls matching events up into one notification per schedule,
+// instead of one per event.
+type DigestRule struct {
+	Name string
+
+	// Pattern selects events like EventRouter patterns: "order.created",
+	// "order.*" or "*".
+	Pattern string
+
+	// Schedule is a cron spec, as for RegisterJob, such as "@hourly".
+	Schedule string
+
+	// SumField, if set, is a dotted path into the event data, such as
+	// "amount" or "totals.gross", whose numeric values are summed.
+	SumField string
+
+	// NotifyURL receives the digest as {"text": "..."}; DIGEST_NOTIFY_URL
+	// is used when it is empty, and the log when both are.
+	NotifyURL string
+}
+
+// digestMaxIDs caps the event IDs listed in one digest.
+const digestMaxIDs = 20
+
+type digest struct {
+	rule DigestRule
+
+	mu     sync.Mutex
+	window digestWindow
+}
+
+// digestWindow is what a digest collected since its last notification.
+type digestWindow struct {
+	since  time.Time
+	count  int
+	sum    float64
+	summed int
+	ids    []string
+}
+
+var (
+	digestsMu sync.RWMutex
+	digests   []*digest
+)
+
+// digestNotifyURL is the default sink for digests.
+var digestNotifyURL string
+
+var digestsSent = expvar.NewMap("digests_sent")
+
+// RegisterDigest adds rule and schedules its notification on s.
+func RegisterDigest(s *Scheduler, rule DigestRule) error {
+	d := &digest{rule: rule, window: digestWindow{since: clock.Now()}}
+	if err := s.RegisterJob("digest:"+rule.Name, rule.Schedule, d.flush); err != nil {
+		return err
+	}
+	digestsMu.Lock()
+	digests = append(digests, d)
+	digestsMu.Unlock()
+	return nil
+}
+
+// recordDigests adds a processed event to every digest it matches.
+func recordDigests(event Event) {
+	digestsMu.RLock()
+	defer digestsMu.RUnlock()
+	for _, d := range digests {
+		if matchesPattern(d.rule.Pattern, event.Type) {
+			d.add(event)
+		}
+	}
+}
+
+func (d *digest) add(event Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := &d.window
+	w.count++
+	if len(w.ids) < digestMaxIDs {
+		w.ids = append(w.ids, event.ID)
+	}
+	if d.rule.SumField == "" {
+		return
+	}
+	if v, ok := dataField(event.Data, d.rule.SumField).(float64); ok {
+		w.sum += v
+		w.summed++
+	}
+}
+
+// dataField returns the value at a dotted path in data, or nil.
+func dataField(data map[string]interface{}, path string) interface{} {
+	var v interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// flush sends the events collected since the last digest. Nothing is sent
+// for an empty window. The window is swapped for a fresh one before the
+// notification goes out, so events recorded meanwhile count towards the
+// next digest; when the notification fails, the window is merged back and
+// rolled into the next digest too.
+func (d *digest) flush(ctx context.Context) error {
+	d.mu.Lock()
+	now := clock.Now()
+	w := d.window
+	d.window = digestWindow{since: now}
+	d.mu.Unlock()
+	if w.count == 0 {
+		return nil
+	}
+	text := w.text(d.rule, now)
+
+	notifyURL := d.rule.NotifyURL
+	if notifyURL == "" {
+		notifyURL = digestNotifyURL
+	}
+	if notifyURL == "" {
+		logger.Info(text)
+	} else if err := notify(ctx, notifyURL, text); err != nil {
+		d.mu.Lock()
+		d.window = w.merge(d.window)
+		d.mu.Unlock()
+		return fmt.Errorf("digest %s not sent: %w", d.rule.Name, err)
+	}
+	digestsSent.Add(d.rule.Name, 1)
+	return nil
+}
+
+// merge returns w followed by the later window next.
+func (w digestWindow) merge(next digestWindow) digestWindow {
+	w.count += next.count
+	w.sum += next.sum
+	w.summed += next.summed
+	for _, id := range next.ids {
+		if len(w.ids) >= digestMaxIDs {
+			break
+		}
+		w.ids = append(w.ids, id)
+	}
+	return w
+}
+
+// flushDigests sends every digest with events in its window, so a shutdown
+// does not drop them.
+func flushDigests(ctx context.Context) {
+	digestsMu.RLock()
+	defer digestsMu.RUnlock()
+	for _, d := range digests {
+		if err := d.flush(ctx); err != nil {
+			logger.Warn("⚠️  Digest lost on shutdown", "digest", d.rule.Name, "error", err)
+		}
+	}
+}
+
+func (w digestWindow) text(rule DigestRule, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 %s: %d %s event(s) from %s to %s", rule.Name, w.count, rule.Pattern,
+		w.since.Format("Jan 2 15:04"), now.Format("Jan 2 15:04"))
+	if rule.SumField != "" {
+		fmt.Fprintf(&b, "\nTotal %s: %s (from %d event(s))", rule.SumField,
+			strconv.FormatFloat(w.sum, 'f', -1, 64), w.summed)
+	}
+	fmt.Fprintf(&b, "\nIDs: %s", strings.Join(w.ids, ", "))
+	if more := w.count - len(w.ids); more > 0 {
+		fmt.Fprintf(&b, " and %d more", more)
+	}
+	return b.String()
+}
+
+// EnrichRule looks up extra data for matching events over HTTP and merges
+// it into the event data before handlers see it, such as the customer
+// record for data.customerId.
+type EnrichRule struct {
+	Name string
+
+	// Pattern selects events like EventRouter patterns: "order.created",
+	// "order.*" or "*".
+	Pattern string
+
+	// URL is fetched with GET. Placeholders such as {customerId} or
+	// {customer.id} are replaced with the URL-escaped value at that dotted
+	// path in the event data. Events missing a value are not enriched.
+	URL string
+
+	// Header is sent with each lookup, such as an Authorization header.
+	Header http.Header
+
+	// Into is the data key the JSON response is stored under. A 404 stores
+	// nothing.
+	Into string
+
+	// Timeout bounds each lookup, 2s when zero. CacheTTL is how long a
+	// response is reused for the same URL, 5m when zero; negative turns
+	// caching off.
+	Timeout  time.Duration
+	CacheTTL time.Duration
+
+	// Required fails the event when the lookup fails, so it is retried
+	// like a handler error. Otherwise the event is handled without the
+	// data.
+	Required bool
+}
+
+// After enrichFailureThreshold lookups in a row fail, a rule's circuit
+// opens: lookups are skipped for enrichCooldown, then one is tried again.
+const (
+	enrichFailureThreshold = 5
+	enrichCooldown         = 30 * time.Second
+	enrichCacheSize        = 1000
+)
+
+type enricher struct {
+	rule   EnrichRule
+	client *http.Client
+
+	mu        sync.Mutex
+	cache     map[string]enrichEntry
+	failures  int       // consecutive
+	openUntil time.Time // circuit open while in the future
+}
+
+type enrichEntry struct {
+	value   interface{} // nil for a 404
+	expires time.Time
+}
+
+var (
+	enrichersMu sync.RWMutex
+	enrichers   []*enricher
+)
+
+var enrichments = expvar.NewMap("enrichments")
+
+// errCircuitOpen is returned for lookups skipped while a circuit is open.
+var errCircuitOpen = errors.New("circuit open after repeated failures")
+
+// RegisterEnrichment adds rule. Lookups run in registration order, so a
+// later rule can use data an earlier one merged in.
+func RegisterEnrichment(rule EnrichRule) error {
+	if rule.Into == "" {
+		return fmt.Errorf("enrichment %q: Into is empty", rule.Name)
+	}
+	if strings.Count(rule.URL, "{") != strings.Count(rule.URL, "}") {
+		return fmt.Errorf("enrichment %q: unbalanced braces in URL %q", rule.Name, rule.URL)
+	}
+	if rule.Timeout <= 0 {
+		rule.Timeout = 2 * time.Second
+	}
+	if rule.CacheTTL == 0 {
+		rule.CacheTTL = 5 * time.Minute
+	}
+	e := &enricher{
+		rule:   rule,
+		client: &http.Client{Timeout: rule.Timeout},
+		cache:  map[string]enrichEntry{},
+	}
+	enrichersMu.Lock()
+	enrichers = append(enrichers, e)
+	enrichersMu.Unlock()
+	return nil
+}
+
+// enrich runs every matching rule on event and returns it with the merged
+// data. Its data map is copied, so the job's event, which dead letters and
+// retries keep, is not changed. A failed lookup is logged, and returned
+// only for a Required rule.
+func enrich(ctx context.Context, event Event) (Event, error) {
+	enrichersMu.RLock()
+	defer enrichersMu.RUnlock()
+	copied := false
+	for _, e := range enrichers {
+		if !matchesPattern(e.rule.Pattern, event.Type) {
+			continue
+		}
+		target, ok := expandURL(e.rule.URL, event.Data)
+		if !ok {
+			continue
+		}
+
+		spanCtx, span := tracer.Start(ctx, "webhook.enrich", trace.WithAttributes(
+			attribute.String("enrich.rule", e.rule.Name)))
+		value, err := e.lookup(spanCtx, target)
+		endSpan(span, err)
+		if err != nil {
+			enrichments.Add(e.rule.Name+":failures", 1)
+			logger.Warn("⚠️  Enrichment lookup failed", "rule", e.rule.Name, "event_id", event.ID, "error", err)
+			if e.rule.Required {
+				return event, fmt.Errorf("enrichment %s: %w", e.rule.Name, err)
+			}
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		if !copied {
+			data := make(map[string]interface{}, len(event.Data)+1)
+			for k, v := range event.Data {
+				data[k] = v
+			}
+			event.Data, copied = data, true
+		}
+		event.Data[e.rule.Into] = value
+	}
+	return event, nil
+}
+
+// matchesPattern reports whether eventType matches an EventRouter-style
+// pattern: an exact type, or a prefix followed by "*".
+func matchesPattern(pattern, eventType string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(eventType, prefix)
+	}
+	return eventType == pattern
+}
+
+// expandURL fills the {path} placeholders in template from data. It
+// reports false when a value is missing.
+func expandURL(template string, data map[string]interface{}) (string, bool) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			b.WriteString(template)
+			return b.String(), true
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", false
+		}
+		v := dataField(data, template[start+1:start+end])
+		if v == nil {
+			return "", false
+		}
+		b.WriteString(template[:start])
+		b.WriteString(url.PathEscape(fmt.Sprint(v)))
+		template = template[start+end+1:]
+	}
+}
+
+// lookup returns the decoded response for target, from the cache when
+// possible, and tracks the circuit.
+func (e *enricher) lookup(ctx context.Context, target string) (interface{}, error) {
+	now := clock.Now()
+	e.mu.Lock()
+	if entry, ok := e.cache[target]; ok && now.Before(entry.expires) {
+		e.mu.Unlock()
+		enrichments.Add(e.rule.Name+":cache_hits", 1)
+		return entry.value, nil
+	}
+	if now.Before(e.openUntil) {
+		e.mu.Unlock()
+		enrichments.Add(e.rule.Name+":skipped", 1)
+		return nil, errCircuitOpen
+	}
+	e.mu.Unlock()
+
+	enrichments.Add(e.rule.Name+":lookups", 1)
+	value, err := e.fetch(ctx, target)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.failures++
+		if e.failures >= enrichFailureThreshold {
+			// Stays open for the cooldown; one failure after it reopens.
+			e.openUntil = now.Add(enrichCooldown)
+			e.failures = enrichFailureThreshold - 1
+			logger.Warn("🔌 Enrichment circuit open", "rule", e.rule.Name, "cooldown", enrichCooldown.String())
+		}
+		return nil, err
+	}
+	e.failures = 0
+	if e.rule.CacheTTL > 0 {
+		if len(e.cache) >= enrichCacheSize {
+			for key, entry := range e.cache {
+				if !now.Before(entry.expires) || len(e.cache) >= enrichCacheSize {
+					delete(e.cache, key)
+				}
+			}
+		}
+		e.cache[target] = enrichEntry{value: value, expires: now.Add(e.rule.CacheTTL)}
+	}
+	return value, nil
+}
+
+func (e *enricher) fetch(ctx context.Context, target string) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.rule.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("lookup answered %s", resp.Status)
+	}
+	var value interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid lookup response: %v", err)
+	}
+	return value, nil
+}
+
+// registerEnrichments is where your lookups go, next to registerHandlers.
+// The example adds the customer record to order events when
+// CUSTOMER_LOOKUP_URL is set.
+func registerEnrichments() {
+	if customerLookupURL == "" {
+		return
+	}
+	header := http.Header{}
+	if customerLookupToken != "" {
+		header.Set("Authorization", "Bearer "+customerLookupToken)
+	}
+	if err := RegisterEnrichment(EnrichRule{
+		Name:    "customer",
+		Pattern: "order.*",
+		URL:     customerLookupURL,
+		Header:  header,
+		Into:    "customer",
+	}); err != nil {
+		logger.Warn("⚠️  Enrichment not registered", "error", err)
+	}
+}
+
+// ForwardTarget is a downstream URL that processed events are relayed to,
+// signed with the target's own secret the way Codehooks signs deliveries,
+// so the target verifies them with webhookverify like this receiver does.
+type ForwardTarget struct {
+	Name string
+	URL  string
+
+	// Pattern selects events like EventRouter patterns: "order.created",
+	// "order.*" or "*". Empty forwards every event.
+	Pattern string
+
+	// Secret signs the forwarded deliveries.
+	Secret string
+
+	// WebhookID is sent as X-Webhook-Id, naming this receiver's
+	// subscription at the target; Name when empty.
+	WebhookID string
+
+	// Header is sent with each delivery, such as an Authorization header.
+	Header http.Header
+
+	// Timeout bounds each attempt, 10s when zero.
+	Timeout time.Duration
+
+	// MaxAttempts is how often a delivery is tried, 5 when zero. A failed
+	// attempt is retried after Backoff, doubled for every further attempt
+	// up to MaxBackoff, with jitter; 1s and 1m when zero. Responses other
+	// than 2xx, 408 and 429 are not retried, since sending the same body
+	// again would fail the same way.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// After forwardFailureThreshold attempts in a row fail, a target's circuit
+// opens: deliveries wait for forwardCooldown before the next attempt, so
+// a target that is down is not hammered by every pending event.
+const (
+	forwardFailureThreshold = 5
+	forwardCooldown         = 30 * time.Second
+)
+
+// forwarder delivers to one target through a webhooksend.Sender. It runs
+// on the wall clock, not clock: backoff sleeps under a frozen FIXED_CLOCK
+// would never end.
+type forwarder struct {
+	target ForwardTarget
+	sender *webhooksend.Sender
+
+	mu        sync.Mutex
+	failures  int       // consecutive
+	openUntil time.Time // circuit open while in the future
+}
+
+var (
+	forwardersMu sync.RWMutex
+	forwarders   []*forwarder
+
+	// Forwards run in the background. drainForwards waits for them, and
+	// cancels forwardCtx when the drain deadline passes. Once it starts,
+	// forwardsClosed turns away new ones; forwardersMu guards it.
+	forwardCtx, stopForwards = context.WithCancel(context.Background())
+	forwardsInFlight         sync.WaitGroup
+	forwardsClosed           bool
+)
+
+// forwards counts "<target>:delivered", ":failed" (given up), ":retries"
+// and ":circuit_open" per target.
+var forwards = expvar.NewMap("forwards")
+
+// RegisterForward adds target. Events are forwarded once they were
+// processed successfully, so a delivery the sender retries is forwarded
+// once.
+func RegisterForward(target ForwardTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("forward %q: invalid URL %q", target.Name, target.URL)
+	}
+	if target.Secret == "" {
+		return fmt.Errorf("forward %q: Secret is empty", target.Name)
+	}
+	if target.Pattern == "" {
+		target.Pattern = "*"
+	}
+	if target.WebhookID == "" {
+		target.WebhookID = target.Name
+	}
+	f := &forwarder{target: target}
+	f.sender = &webhooksend.Sender{
+		Secret:        target.Secret,
+		WebhookID:     target.WebhookID,
+		Header:        target.Header,
+		Timeout:       target.Timeout,
+		MaxAttempts:   target.MaxAttempts,
+		Backoff:       target.Backoff,
+		MaxBackoff:    target.MaxBackoff,
+		BeforeAttempt: f.waitCircuit,
+	}
+	forwardersMu.Lock()
+	forwarders = append(forwarders, f)
+	forwardersMu.Unlock()
+	return nil
+}
+
+// forward starts delivering the event of job to every matching target.
+// The event is encoded again as a Codehooks event, also when a provider
+// sent it, so the body is not the one received. It goes out without
+// enrichment data, with its ID as X-Event-Id, or the delivery ID when it
+// has none, so the target can deduplicate per event.
+func forward(job eventJob) {
+	forwardersMu.RLock()
+	defer forwardersMu.RUnlock()
+	eventID := job.Event.ID
+	if eventID == "" {
+		eventID = job.DeliveryID
+	}
+	var body []byte
+	for _, f := range forwarders {
+		if !matchesPattern(f.target.Pattern, job.Event.Type) {
+			continue
+		}
+		if forwardsClosed {
+			logger.Warn("⚠️  Shutting down, event not forwarded", "target", f.target.Name, "event_id", job.Event.ID)
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(job.Event); err != nil {
+				logger.Error("❌ Cannot encode event for forwarding", "event_id", job.Event.ID, "error", err)
+				return
+			}
+		}
+		forwardsInFlight.Add(1)
+		go func(f *forwarder) {
+			defer forwardsInFlight.Done()
+			f.deliver(forwardCtx, eventID, job.Event, body)
+		}(f)
+	}
+}
+
+func (f *forwarder) deliver(ctx context.Context, eventID string, event Event, body []byte) {
+	name := f.target.Name
+	log := logger.With("target", name, "event_id", event.ID, "event_type", event.Type)
+	sender := *f.sender
+	sender.OnAttempt = func(n int, a *webhooksend.Attempt) {
+		f.record(a)
+		if a.Wait == 0 {
+			return
+		}
+		forwards.Add(name+":retries", 1)
+		why := a.Err
+		if a.Response != nil {
+			why = fmt.Errorf("target answered %d", a.Response.Status)
+		}
+		log.Warn("⚠️  Forwarding attempt failed, retrying", "attempt", n, "in", a.Wait.Round(time.Millisecond).String(), "error", why)
+	}
+	d, err := sender.DeliverBody(ctx, f.target.URL, eventID, body)
+	if err != nil {
+		forwards.Add(name+":failed", 1)
+		log.Error("❌ Forwarding failed", "attempts", len(d.Attempts), "error", err)
+		return
+	}
+	forwards.Add(name+":delivered", 1)
+	log.Info("📤 Forwarded", "attempt", len(d.Attempts))
+}
+
+// waitCircuit waits while the circuit is open.
+func (f *forwarder) waitCircuit(ctx context.Context) error {
+	f.mu.Lock()
+	wait := time.Until(f.openUntil)
+	f.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	forwards.Add(f.target.Name+":circuit_open", 1)
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// record tracks consecutive failures. A failure retrying would not fix
+// says nothing about the target's health, so it does not count.
+func (f *forwarder) record(a *webhooksend.Attempt) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case a.OK():
+		f.failures = 0
+	case !a.Retryable():
+	default:
+		f.failures++
+		if f.failures >= forwardFailureThreshold {
+			f.openUntil = time.Now().Add(forwardCooldown)
+			f.failures = forwardFailureThreshold - 1
+			logger.Warn("🔌 Forwarding circuit open", "target", f.target.Name, "cooldown", forwardCooldown.String())
+		}
+	}
+}
+
+// drainForwards waits for deliveries in progress, retries included. When
+// ctx ends first, they are abandoned. Events processed from then on are
+// not forwarded.
+func drainForwards(ctx context.Context) error {
+	forwardersMu.Lock()
+	forwardsClosed = true
+	forwardersMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		forwardsInFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		stopForwards()
+		return fmt.Errorf("forwarding abandoned: %w", ctx.Err())
+	}
+}
+
+// registerForwards is where your forward targets go, next to
+// registerHandlers. The example relays every event to each URL in
+// FORWARD_URLS, signed with FORWARD_SECRET.
+func registerForwards() {
+	for i, target := range forwardURLs {
+		if err := RegisterForward(ForwardTarget{
+			Name:   fmt.Sprintf("forward%d", i+1),
+			URL:    target,
+			Secret: forwardSecret,
+		}); err != nil {
+			logger.Warn("⚠️  Forward target not registered", "error", err)
+		}
+	}
+}
+
+// Script handlers, from SCRIPT_DIR: one .js file per pattern, named after
+// it like SCHEMA_DIR's schemas, such as order.created.js or order.*.js,
+// defining a handle(event) function. Each event runs in a child process
+// with SCRIPT_CPU of CPU time and SCRIPT_MEMORY of heap; a script that
+// goes over either is stopped, or killed, and its event is acknowledged
+// and parked in the dead-letter queue instead of retried. A script that
+// throws fails the delivery like any other handler.
+var (
+	scriptDir      string
+	scripts        map[string]string // pattern to script path
+	scriptSandbox  *webhookscript.Sandbox
+	scriptBreaches = expvar.NewMap("script_breaches")
+)
+
+// loadScriptDir checks that every .js file in dir parses and returns them
+// by the pattern their name spells.
+func loadScriptDir(dir string) (map[string]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]string{}
+	for _, entry := range entries {
+		pattern, ok := strings.CutSuffix(entry.Name(), ".js")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := webhookscript.Compile(entry.Name(), src); err != nil {
+			return nil, err
+		}
+		loaded[pattern] = path
+	}
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("no .js scripts in %s", dir)
+	}
+	return loaded, nil
+}
+
+// registerScripts registers a handler for each script in SCRIPT_DIR. Go
+// handlers registered in registerHandlers for the same pattern are
+// replaced.
+func registerScripts(rt *EventRouter) {
+	for pattern, path := range scripts {
+		path := path
+		rt.On(pattern, func(ctx context.Context, event Event) error {
+			body, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			return scriptSandbox.Handle(ctx, path, body)
+		})
+		logger.Info("📜 Script handler registered", "pattern", pattern, "script", path)
+	}
+}
+
+// registerJobs is where your periodic jobs go, next to registerHandlers.
+// Register jobs before serve starts the scheduler. An error, such as a
+// schedule that never runs, stops the receiver at startup.
+func registerJobs(s *Scheduler) error {
+	err := s.RegisterJob("dead-letter-report", "@hourly", func(ctx context.Context) error {
+		if n := len(deadLetters.list()); n > 0 {
+			logger.Info("🪦 Events in the dead-letter queue", "count", n)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return RegisterDigest(s, DigestRule{
+		Name:     "Hourly orders",
+		Pattern:  "order.created",
+		Schedule: "@hourly",
+		SumField: "amount",
+	})
+}
+
+// workQueue hands verified events to a fixed pool of workers, so the
+// handler can answer 202 without waiting for processing. It is bounded:
+// when it is full the handler answers 503 and the sender retries later.
+type workQueue struct {
+	jobs chan eventJob
+	wg   sync.WaitGroup
+
+	// abandoned is set when drain runs out of time: workers then stop
+	// taking jobs, and done is closed once the last one has returned.
+	abandoned atomic.Bool
+	done      chan struct{}
+
+	mu     sync.RWMutex
+	closed bool // set by drain; handlers that outlive the server see it
+
+	// Payload bytes of the queued events, kept under maxBytes when it is
+	// set. An event larger than maxBytes still fits in an empty queue.
+	bytes    atomic.Int64
+	maxBytes int64
+
+	// With EVENT_LOG, events that do not fit are spilled: left in the log
+	// as StatusSpilled, and fed back to the queue as it empties. wake is
+	// signalled when a worker frees room.
+	spilled atomic.Int64
+	wake    chan struct{}
+	stop    chan struct{}
+	feeding sync.WaitGroup
+}
+
+// queue is nil unless WORKERS is set, and events are processed in the
+// handler.
+var queue *workQueue
+
+func newWorkQueue(size int, maxBytes int64) *workQueue {
+	q := &workQueue{jobs: make(chan eventJob, size), maxBytes: maxBytes,
+		wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	expvar.Publish("queue_depth", expvar.Func(func() interface{} { return len(q.jobs) }))
+	expvar.Publish("queue_bytes", expvar.Func(func() interface{} { return q.bytes.Load() }))
+	expvar.Publish("queue_spilled", expvar.Func(func() interface{} { return q.spilled.Load() }))
+	return q
+}
+
+func (q *workQueue) start(workers int) {
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for job := range q.jobs {
+				if q.abandoned.Load() {
+					return // left in EVENT_LOG as received
+				}
+				ctx := trace.ContextWithSpanContext(context.Background(), job.Trace)
+				if err := processEvent(ctx, job); err != nil {
+					// The sender already has its 202; processEvent recorded
+					// nothing, so only a resend delivers the event again.
+					logger.Error("❌ Processing failed", "webhook_id", job.WebhookID, "error", err)
+				}
+				q.bytes.Add(-int64(job.Size))
+				select {
+				case q.wake <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+	go func() {
+		q.wg.Wait()
+		close(q.done)
+	}()
+	if eventLog != nil {
+		q.feeding.Add(1)
+		go q.feed()
+	}
+}
+
+// enqueue adds job without blocking and reports whether it was queued. It
+// fails when the queue is full or draining.
+func (q *workQueue) enqueue(job eventJob) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	size := int64(job.Size)
+	if n := q.bytes.Add(size); q.maxBytes > 0 && n > q.maxBytes && n != size {
+		q.bytes.Add(-size)
+		return false
+	}
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		q.bytes.Add(-size)
+		return false
+	}
+}
+
+// Outcomes of workQueue.accept.
+const (
+	queueAdded = iota
+	queueSpilled
+	queueFull
+)
+
+// accept queues job, or spills it to the event log when the queue is full
+// and the event can be read back from its record.
+func (q *workQueue) accept(ctx context.Context, job eventJob, spillable bool) int {
+	if q.enqueue(job) {
+		return queueAdded
+	}
+	q.mu.RLock()
+	closed := q.closed
+	q.mu.RUnlock()
+	if closed || !spillable || eventLog == nil || job.LogID == 0 {
+		return queueFull
+	}
+	if err := eventLog.SetStatus(ctx, job.LogID, webhooklog.StatusSpilled, ""); err != nil {
+		logger.Warn("⚠️  Event log write failed", "error", err)
+		return queueFull
+	}
+	eventLogVersion.Add(1)
+	q.spilled.Add(1)
+	return queueSpilled
+}
+
+// feed moves spilled events from the event log back to the queue, oldest
+// first, as workers make room. It also picks up events a previous process
+// spilled and never got to.
+func (q *workQueue) feed() {
+	defer q.feeding.Done()
+	ctx := context.Background()
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	for {
+		if room := cap(q.jobs) - len(q.jobs); room > 0 {
+			records, err := eventLog.Query(ctx, webhooklog.Filter{Statuses: []string{webhooklog.StatusSpilled}, Limit: room})
+			if err != nil {
+				logger.Warn("⚠️  Reading spilled events failed", "error", err)
+			}
+			for _, rec := range records {
+				if !q.unspill(ctx, rec) {
+					break
+				}
+			}
+			if len(records) == room {
+				continue // more may be waiting
+			}
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-poll.C:
+		}
+	}
+}
+
+// unspill queues a spilled record and reports whether it fit.
+func (q *workQueue) unspill(ctx context.Context, rec webhooklog.Record) bool {
+	job, err := loggedJob(rec)
+	if err != nil {
+		markDelivery(ctx, rec.ID, webhooklog.StatusFailed, err.Error())
+		q.spilled.Add(-1)
+		return true
+	}
+	// Marked received first, so a worker that finishes the event quickly
+	// is not overwritten.
+	markDelivery(ctx, rec.ID, webhooklog.StatusReceived, "")
+	if !q.enqueue(job) {
+		markDelivery(ctx, rec.ID, webhooklog.StatusSpilled, "")
+		return false
+	}
+	if q.spilled.Add(-1) < 0 {
+		q.spilled.Store(0) // spilled by an earlier process
+	}
+	return true
+}
+
+// drain stops accepting jobs and waits for the workers to finish the
+// queued ones, or for ctx to end. Then the workers finish the events in
+// hand and take no more. Call it once the server has stopped calling
+// enqueue.
+func (q *workQueue) drain(ctx context.Context) error {
+	close(q.stop)
+	q.feeding.Wait()
+	q.mu.Lock()
+	q.closed = true
+	close(q.jobs)
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.abandoned.Store(true)
+		return fmt.Errorf("%d queued events not processed: %w", len(q.jobs), ctx.Err())
+	}
+}
+
+// busy reports whether workers that drain gave up on are still running.
+func (q *workQueue) busy() bool {
+	if !q.abandoned.Load() {
+		return false
+	}
+	select {
+	case <-q.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// volumeMonitor keeps a rolling baseline of events per window for each
+// event type and flags windows that deviate from it by more than factor.
+// A window with no events at all counts as a drop, so a provider going
+// silent is reported after one window instead of hours later.
+type volumeMonitor struct {
+	window    time.Duration
+	factor    float64
+	notifyURL string
+
+	mu        sync.Mutex
+	counts    map[string]int
+	baselines map[string]*volumeBaseline
+}
+
+type volumeBaseline struct {
+	Average   float64 `json:"average"`
+	Windows   int     `json:"windows"`
+	Anomalous bool    `json:"anomalous"`
+}
+
+const (
+	volumeWarmupWindows = 6   // windows observed before flagging
+	volumeMinAverage    = 5.0 // ignore types quieter than this per window
+	volumeSmoothing     = 0.3 // weight of the latest window in the baseline
+	// Anomalous windows barely move the baseline, so an outage stays
+	// flagged while a lasting change in volume is still absorbed eventually.
+	volumeAnomalySmoothing = 0.05
+)
+
+var volumeAnomalies = expvar.NewMap("volume_anomalies")
+
+func newVolumeMonitor(window time.Duration, factor float64, notifyURL string) *volumeMonitor {
+	m := &volumeMonitor{
+		window:    window,
+		factor:    factor,
+		notifyURL: notifyURL,
+		counts:    map[string]int{},
+		baselines: map[string]*volumeBaseline{},
+	}
+	expvar.Publish("volume_baselines", expvar.Func(func() interface{} {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		snapshot := map[string]volumeBaseline{}
+		for eventType, b := range m.baselines {
+			snapshot[eventType] = *b
+		}
+		return snapshot
+	}))
+	return m
+}
+
+func (m *volumeMonitor) record(eventType string) {
+	m.mu.Lock()
+	m.counts[eventType]++
+	m.mu.Unlock()
+}
+
+func (m *volumeMonitor) run() {
+	ticker := clock.NewTicker(m.window)
+	for range ticker.C() {
+		m.closeWindow()
+	}
+}
+
+func (m *volumeMonitor) closeWindow() {
+	m.mu.Lock()
+	var alerts []string
+	for eventType := range m.counts {
+		if m.baselines[eventType] == nil {
+			m.baselines[eventType] = &volumeBaseline{}
+		}
+	}
+	for eventType, b := range m.baselines {
+		count := float64(m.counts[eventType])
+
+		if b.Windows >= volumeWarmupWindows && b.Average >= volumeMinAverage {
+			anomalous := count > b.Average*m.factor || count < b.Average/m.factor
+			if anomalous && !b.Anomalous {
+				kind := "spike"
+				if count < b.Average {
+					kind = "drop"
+				}
+				volumeAnomalies.Add(eventType+":"+kind, 1)
+				alerts = append(alerts, fmt.Sprintf("Volume %s for %s: %.0f events in the last %s, baseline %.1f",
+					kind, eventType, count, m.window, b.Average))
+			} else if !anomalous && b.Anomalous {
+				alerts = append(alerts, fmt.Sprintf("Volume for %s back to normal: %.0f events in the last %s",
+					eventType, count, m.window))
+			}
+			b.Anomalous = anomalous
+		}
+
+		weight := volumeSmoothing
+		if b.Anomalous {
+			weight = volumeAnomalySmoothing
+		}
+		if b.Windows == 0 {
+			weight = 1
+		}
+		b.Average = weight*count + (1-weight)*b.Average
+		b.Windows++
+	}
+	m.counts = map[string]int{}
+	m.mu.Unlock()
+
+	for _, alert := range alerts {
+		logger.Warn("📈 " + alert)
+		if m.notifyURL != "" {
+			if err := notify(context.Background(), m.notifyURL, alert); err != nil {
+				logger.Warn("⚠️  Anomaly notification failed", "error", err)
+			}
+		}
+	}
+}
+
+// notify posts text to a Slack-compatible incoming webhook URL. It gives
+// up when ctx ends, or after 10 seconds.
+func notify(ctx context.Context, notifyURL string, text string) error {
+	payload, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifyURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// volume is nil unless ANOMALY_WINDOW is set.
+var volume *volumeMonitor
+
+// sloTracker measures two service level indicators for /webhook over
+// sliding windows, in one-minute buckets:
+//
+//   - availability: requests answered without a 5xx
+//   - latency: accepted events processed within the threshold of the
+//     signed X-Webhook-Timestamp, which includes time spent in the sender
+//
+// Burn rate is the error rate over a window divided by the error budget
+// (1 - target); a burn rate of 1 spends the budget exactly on schedule.
+type sloTracker struct {
+	target    float64
+	latency   time.Duration
+	notifyURL string
+
+	mu       sync.Mutex
+	buckets  [sloBuckets]sloBucket
+	alerting map[string]bool
+}
+
+type sloBucket struct {
+	minute                 int64
+	total, available       int
+	processed, withinLimit int
+}
+
+// sloBurnRule alerts when both windows burn faster than threshold: the
+// long window shows the budget is really at risk, the short one that it
+// still is. These are the usual page and ticket rules for a 30-day SLO.
+type sloBurnRule struct {
+	Name      string
+	Long      time.Duration
+	Short     time.Duration
+	Threshold float64
+}
+
+var sloBurnRules = []sloBurnRule{
+	{Name: "fast", Long: time.Hour, Short: 5 * time.Minute, Threshold: 14.4},
+	{Name: "slow", Long: 6 * time.Hour, Short: 30 * time.Minute, Threshold: 6},
+}
+
+// sloBuckets covers the longest rule window.
+const sloBuckets = 6 * 60
+
+// sloWindows are the windows reported by /slo, keyed by label.
+var sloWindows = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+type sloWindow struct {
+	Requests             int     `json:"requests"`
+	Availability         float64 `json:"availability"`
+	AvailabilityBurnRate float64 `json:"availability_burn_rate"`
+	Processed            int     `json:"processed"`
+	LatencyCompliance    float64 `json:"latency_compliance"`
+	LatencyBurnRate      float64 `json:"latency_burn_rate"`
+}
+
+type sloReport struct {
+	Target           float64              `json:"target"`
+	LatencyThreshold string               `json:"latency_threshold"`
+	Windows          map[string]sloWindow `json:"windows"`
+	Alerts           []string             `json:"alerts"`
+}
+
+func newSLOTracker(target float64, latency time.Duration, notifyURL string) *sloTracker {
+	t := &sloTracker{target: target, latency: latency, notifyURL: notifyURL, alerting: map[string]bool{}}
+	expvar.Publish("slo", expvar.Func(func() interface{} { return t.report() }))
+	return t
+}
+
+// middleware records availability; latency is recorded by processEvent,
+// which may run after the response when WORKERS is set.
+func (t *sloTracker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		t.record(rec.status)
+	})
+}
+
+func (t *sloTracker) record(status int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(clock.Now().Unix() / 60)
+	b.total++
+	if status < 500 {
+		b.available++
+	}
+}
+
+// recordProcessed counts an event processed sinceSigned after the sender
+// signed it.
+func (t *sloTracker) recordProcessed(sinceSigned time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(clock.Now().Unix() / 60)
+	b.processed++
+	if sinceSigned <= t.latency {
+		b.withinLimit++
+	}
+}
+
+// bucket returns the bucket for minute, clearing it if it last held an
+// older minute. Callers hold t.mu.
+func (t *sloTracker) bucket(minute int64) *sloBucket {
+	b := &t.buckets[minute%sloBuckets]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	return b
+}
+
+// window sums the buckets of the last d, including the current minute.
+// Callers hold t.mu.
+func (t *sloTracker) window(d time.Duration) sloWindow {
+	now := clock.Now().Unix() / 60
+	var sum sloBucket
+	for i := int64(0); i < int64(d/time.Minute); i++ {
+		b := t.buckets[(now-i)%sloBuckets]
+		if b.minute != now-i {
+			continue
+		}
+		sum.total += b.total
+		sum.available += b.available
+		sum.processed += b.processed
+		sum.withinLimit += b.withinLimit
+	}
+
+	w := sloWindow{Requests: sum.total, Availability: 1, Processed: sum.processed, LatencyCompliance: 1}
+	budget := 1 - t.target
+	if sum.total > 0 {
+		w.Availability = float64(sum.available) / float64(sum.total)
+		w.AvailabilityBurnRate = math.Round((1-w.Availability)/budget*100) / 100
+	}
+	if sum.processed > 0 {
+		w.LatencyCompliance = float64(sum.withinLimit) / float64(sum.processed)
+		w.LatencyBurnRate = math.Round((1-w.LatencyCompliance)/budget*100) / 100
+	}
+	return w
+}
+
+// burning reports the alerts that currently fire, as "<sli> <rule>".
+// Callers hold t.mu.
+func (t *sloTracker) burning() map[string]bool {
+	firing := map[string]bool{}
+	for _, rule := range sloBurnRules {
+		long, short := t.window(rule.Long), t.window(rule.Short)
+		if long.AvailabilityBurnRate > rule.Threshold && short.AvailabilityBurnRate > rule.Threshold {
+			firing["availability "+rule.Name] = true
+		}
+		if long.LatencyBurnRate > rule.Threshold && short.LatencyBurnRate > rule.Threshold {
+			firing["latency "+rule.Name] = true
+		}
+	}
+	return firing
+}
+
+func (t *sloTracker) report() sloReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := sloReport{
+		Target:           t.target,
+		LatencyThreshold: t.latency.String(),
+		Windows:          map[string]sloWindow{},
+		Alerts:           []string{},
+	}
+	for _, w := range sloWindows {
+		r.Windows[w.Label] = t.window(w.Duration)
+	}
+	for _, rule := range sloBurnRules {
+		for _, sli := range []string{"availability", "latency"} {
+			if t.alerting[sli+" "+rule.Name] {
+				r.Alerts = append(r.Alerts, sli+" "+rule.Name)
+			}
+		}
+	}
+	return r
+}
+
+// run evaluates the burn-rate rules every minute and reports alerts as
+// they start and stop firing.
+func (t *sloTracker) run() {
+	ticker := clock.NewTicker(time.Minute)
+	for range ticker.C() {
+		t.mu.Lock()
+		firing := t.burning()
+		var alerts []string
+		for name := range firing {
+			if !t.alerting[name] {
+				alerts = append(alerts, fmt.Sprintf("SLO %s burn: error budget for %.2f%% is being spent too fast", name, t.target*100))
+			}
+		}
+		for name := range t.alerting {
+			if !firing[name] {
+				alerts = append(alerts, fmt.Sprintf("SLO %s burn resolved", name))
+			}
+		}
+		t.alerting = firing
+		t.mu.Unlock()
+
+		for _, alert := range alerts {
+			logger.Warn("🔥 " + alert)
+			if t.notifyURL != "" {
+				if err := notify(context.Background(), t.notifyURL, alert); err != nil {
+					logger.Warn("⚠️  SLO notification failed", "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (t *sloTracker) handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.report())
+}
+
+// slo is nil unless SLO_TARGET is set.
+var slo *sloTracker
+
+// readOnlyHandler answers deliveries sent to a read-only replica. The 503
+// makes the sender retry, by which time traffic should be back on the
+// primary.
+func readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "60")
+	rejectRequest(w, r, "read_only", "Read-only replica, not accepting deliveries", http.StatusServiceUnavailable)
+}
+
+func rejectRequest(w http.ResponseWriter, r *http.Request, reason string, message string, status int) {
+	rejectedRequests.Add(reason, 1)
+	logger.Warn("🚫 Rejected request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "reason", reason)
+	writeRejection(w, status, reason, message)
+}
+
+// rejection is the body of a response refusing a request. Codehooks keeps
+// the response of a failed delivery in its delivery log, so the code and
+// hint tell the sender's operator what to fix without a support thread.
+type rejection struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+
+	// Errors lists the schema violations of a schema_violation.
+	Errors []schemaError `json:"errors,omitempty"`
+}
+
+// rejectionHints explain the rejection codes that a sender can act on.
+var rejectionHints = map[string]string{
+	"missing_signature":         "Send X-Webhook-Signature and X-Webhook-Timestamp. Codehooks adds them to every delivery of a webhook registered with a secret.",
+	"signature_mismatch":        "Sign with the secret configured on the receiver, over \"{timestamp}.{body}\", and make sure nothing rewrites the body in transit.",
+	"stale_timestamp":           "The signed timestamp is outside the receiver's tolerance window, 5 minutes by default. Check the sender's clock and sign every retry anew.",
+	"malformed_signature":       "A signature header could not be parsed. X-Webhook-Timestamp must be Unix seconds, and X-Webhook-Signature a list of version=signature pairs.",
+	"unknown_scheme":            "No signature uses a scheme the receiver accepts. Sign with v1 (HMAC-SHA256).",
+	"replayed":                  "A delivery with this timestamp and body was already accepted. Sign every retry anew with a fresh timestamp.",
+	"signature_invalid":         "The signature could not be verified. Check the secret and the signature headers.",
+	"body_too_large":            "The body is over the receiver's MAX_BODY_BYTES. Send smaller events, or raise the limit.",
+	"decompressed_too_large":    "The body is over the receiver's MAX_DECOMPRESSED_BYTES once decompressed.",
+	"unsupported_encoding":      "Send the body uncompressed, or with Content-Encoding gzip or zstd.",
+	"invalid_encoding":          "The body could not be decompressed. Check that Content-Encoding matches how it was compressed.",
+	"content_digest_mismatch":   "The body does not match Content-Digest. A proxy may be rewriting the body.",
+	"content_digest_missing":    "The receiver requires a Content-Digest header (sha-256 or sha-512).",
+	"invalid_encrypted_payload": "The payload could not be decrypted. Check that the sender encrypts with the receiver's PAYLOAD_ENCRYPTION_KEY.",
+	"encryption_required":       "The receiver only accepts payloads encrypted as compact JWE.",
+	"invalid_payload":           "The body must be a JSON event with id, type and data.",
+	"payload_too_large":         "The payload is over the receiver's limit for its event type, see PAYLOAD_TYPE_LIMITS.",
+	"unexpected_event_type":     "This endpoint does not accept the event type, see ALLOWED_EVENT_TYPES. Unsubscribe the webhook from it, or allow it on the receiver.",
+	"schema_violation":          "The event's data does not match the receiver's JSON Schema for its type. Each entry in errors has the JSON Pointer of a field in data and what is wrong with it.",
+	"rate_limited_ip":           "Too many requests from this address. Retry after the Retry-After delay.",
+	"rate_limited_global":       "The receiver is at its request limit. Retry after the Retry-After delay.",
+	"endpoint_paused":           "The receiver paused this endpoint after a lifecycle event ended its subscription, such as an uninstall or a revoked secret. Subscribe again, then ask the operator to resume the endpoint.",
+	"batch_too_large":           "Split the batch: the receiver takes at most 1000 events per request.",
+	"read_only":                 "This instance is a read-only replica. Retry later, or deliver to the primary.",
+	"queue_full":                "The receiver is busy. Retry after the Retry-After delay.",
+}
+
+// writeRejection answers with a JSON rejection body, so a sender's
+// delivery log shows a stable code and a remediation hint next to the
+// status.
+func writeRejection(w http.ResponseWriter, status int, code string, message string) {
+	writeRejectionBody(w, status, rejection{Code: code, Message: message, Hint: rejectionHints[code]})
+}
+
+func writeRejectionBody(w http.ResponseWriter, status int, body rejection) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// signatureRejection returns the rejection code for a verification error.
+func signatureRejection(err error) string {
+	switch {
+	case errors.Is(err, webhookverify.ErrMissingHeader):
+		return "missing_signature"
+	case errors.Is(err, webhookverify.ErrMalformedHeader):
+		return "malformed_signature"
+	case errors.Is(err, webhookverify.ErrStaleTimestamp):
+		return "stale_timestamp"
+	case errors.Is(err, webhookverify.ErrSignatureMismatch):
+		return "signature_mismatch"
+	case errors.Is(err, webhookverify.ErrUnknownScheme):
+		return "unknown_scheme"
+	case errors.Is(err, webhookverify.ErrReplayed):
+		return "replayed"
+	}
+	return "signature_invalid"
+}
+
+// hardenRequests rejects requests with oversized headers and, in strict
+// mode, any request framed with Transfer-Encoding. Go's server drops
+// Content-Length when Transfer-Encoding is present, so a smuggling-style
+// combination of the two cannot be told apart from plain chunked encoding
+// here; strict mode requires Content-Length framing instead.
+func hardenRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strictRequests && len(r.TransferEncoding) > 0 {
+			rejectRequest(w, r, "transfer_encoding", "Transfer-Encoding not accepted, send Content-Length", http.StatusLengthRequired)
+			return
+		}
+
+		size := 0
+		for name, values := range r.Header {
+			for _, v := range values {
+				size += len(name) + len(v) + 4 // ": " and CRLF
+			}
+		}
+		if size > maxHeaderBytes {
+			rejectRequest(w, r, "header_too_large", "Request headers too large", http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit is a token bucket setting: rate tokens per second, up to burst
+// at once.
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+// parseRateLimit parses "10/s", "600/m" or "5000/h". The burst is the
+// count, so "600/m" allows 600 requests at once and then 10 per second.
+func parseRateLimit(spec string) (rateLimit, error) {
+	count, unit, ok := strings.Cut(spec, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 {
+		return rateLimit{}, fmt.Errorf("want count/unit, such as 10/s or 600/m")
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return rateLimit{}, fmt.Errorf("unit must be s, m or h")
+	}
+	return rateLimit{rate: float64(n) / per.Seconds(), burst: float64(n)}, nil
+}
+
+// tokenBucket is one bucket of a rateLimiter.
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool // rejected since the last allowed request
+}
+
+// refill adds the tokens earned since the last call.
+func (b *tokenBucket) refill(limit rateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = limit.burst
+	} else {
+		b.tokens = math.Min(limit.burst, b.tokens+now.Sub(b.last).Seconds()*limit.rate)
+	}
+	b.last = now
+}
+
+// wait is how long until the bucket holds a token again.
+func (b *tokenBucket) wait(limit rateLimit) time.Duration {
+	return time.Duration((1 - b.tokens) / limit.rate * float64(time.Second))
+}
+
+// maxRateLimitClients bounds the per-client buckets. Clients past it share
+// one bucket until idle ones are swept.
+const maxRateLimitClients = 100000
+
+// rateLimiter keeps a token bucket per client and one for all clients.
+// It runs on the wall clock, not clock: a frozen FIXED_CLOCK would never
+// refill the buckets.
+type rateLimiter struct {
+	perClient rateLimit // zero rate: no per-client limit
+	global    rateLimit // zero rate: no global limit
+
+	mu           sync.Mutex
+	globalBucket tokenBucket
+	clients      map[string]*tokenBucket
+	lastSweep    time.Time
+}
+
+func newRateLimiter(perClient, global rateLimit) *rateLimiter {
+	return &rateLimiter{perClient: perClient, global: global, clients: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from the client's bucket and the global one. If
+// either is empty it takes none and returns the limit that was hit ("ip"
+// or "global"), how long until a token is back, and whether this is the
+// first rejection since that bucket last allowed a request.
+func (l *rateLimiter) allow(client string, now time.Time) (limit string, wait time.Duration, first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var cb *tokenBucket
+	if l.perClient.rate > 0 {
+		if now.Sub(l.lastSweep) > time.Minute {
+			l.sweep(now)
+		}
+		cb = l.clients[client]
+		if cb == nil {
+			if len(l.clients) >= maxRateLimitClients {
+				client = "overflow"
+				cb = l.clients[client]
+			}
+			if cb == nil {
+				cb = &tokenBucket{}
+				l.clients[client] = cb
+			}
+		}
+		cb.refill(l.perClient, now)
+	}
+	if l.global.rate > 0 {
+		l.globalBucket.refill(l.global, now)
+	}
+
+	reject := func(b *tokenBucket, name string, limit rateLimit) (string, time.Duration, bool) {
+		first := !b.limited
+		b.limited = true
+		return name, b.wait(limit), first
+	}
+	if cb != nil && cb.tokens < 1 {
+		return reject(cb, "ip", l.perClient)
+	}
+	if l.global.rate > 0 && l.globalBucket.tokens < 1 {
+		return reject(&l.globalBucket, "global", l.global)
+	}
+	if cb != nil {
+		cb.tokens--
+		cb.limited = false
+	}
+	if l.global.rate > 0 {
+		l.globalBucket.tokens--
+		l.globalBucket.limited = false
+	}
+	return "", 0, false
+}
+
+// sweep drops the buckets that have refilled completely, since a new
+// bucket starts full anyway.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perClient.rate >= l.perClient.burst {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// middleware answers 429 with Retry-After once a bucket is empty. It runs
+// before the body is read, so a flood costs no verification work. Only
+// the first rejection of a run is logged; rejected_requests counts all of
+// them as rate_limited_ip or rate_limited_global.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		limit, wait, first := l.allow(rateLimitKey(ip), time.Now())
+		if limit == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rejectedRequests.Add("rate_limited_"+limit, 1)
+		if first {
+			logger.Warn("🚦 Rate limited", "limit", limit, "client", ip, "path", r.URL.Path, "retry_after", wait.Round(time.Millisecond))
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+		writeRejection(w, http.StatusTooManyRequests, "rate_limited_"+limit, "Too many requests")
+	})
+}
+
+// clientIP returns the address a request came from. When the connection
+// comes from one of TRUSTED_PROXIES, it is the right-most X-Forwarded-For
+// entry that is not a trusted proxy itself; earlier entries can be forged
+// by the client. Connections through RELAY_ADDR carry the address the
+// relay accepted them from (see relayConn); list a load balancer in front
+// of the relay in TRUSTED_PROXIES like one in front of the receiver.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey groups IPv6 clients by /64, the block a single host is
+// usually given, so rotating addresses within it does not dodge the limit.
+func rateLimitKey(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return addr
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// parseTrustedProxies parses "10.0.0.0/8,192.168.1.1". Single addresses
+// are taken as /32 or /128.
+func parseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// capturedRequest is one raw request kept in the capture ring buffer.
+type capturedRequest struct {
+	ReceivedAt    time.Time   `json:"received_at"`
+	Method        string      `json:"method"`
+	Path          string      `json:"path"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+	Status        int         `json:"status"`
+	DurationMs    float64     `json:"duration_ms"`
+}
+
+// captureMaxBody bounds how much of each body the ring buffer keeps.
+const captureMaxBody = 64 << 10
+
+// requestCapture keeps the last N requests in memory, including rejected
+// ones, for inspection through GET /debug/requests.
+type requestCapture struct {
+	mu      sync.Mutex
+	entries []capturedRequest
+	next    int
+	full    bool
+}
+
+func newRequestCapture(size int) *requestCapture {
+	return &requestCapture{entries: make([]capturedRequest, size)}
+}
+
+func (c *requestCapture) add(entry capturedRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[c.next] = entry
+	c.next = (c.next + 1) % len(c.entries)
+	if c.next == 0 {
+		c.full = true
+	}
+}
+
+// snapshot returns the captured requests, newest first.
+func (c *requestCapture) snapshot() []capturedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.next
+	if c.full {
+		n = len(c.entries)
+	}
+	out := make([]capturedRequest, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, c.entries[(c.next-i+len(c.entries))%len(c.entries)])
+	}
+	return out
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// middleware records every request except those to /debug/ endpoints. The
+// start of the body is read up front so requests rejected before their body
+// is read are still captured in full. Tokens in the Authorization header
+// and in ?token=, as WebSocket clients send them, are redacted.
+func (c *requestCapture) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		head, _ := io.ReadAll(io.LimitReader(r.Body, captureMaxBody+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+
+		headers := r.Header.Clone()
+		if headers.Get("Authorization") != "" {
+			headers.Set("Authorization", "[redacted]")
+		}
+		entry := capturedRequest{
+			ReceivedAt: start.UTC(),
+			Method:     r.Method,
+			Path:       redactedURI(r.URL),
+			RemoteAddr: r.RemoteAddr,
+			Headers:    headers,
+		}
+		if len(head) > captureMaxBody {
+			head, entry.BodyTruncated = head[:captureMaxBody], true
+		}
+		entry.Body = string(head)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		c.add(entry)
+	})
+}
+
+// redactedURI is the request URI of u with any ?token= redacted.
+func redactedURI(u *url.URL) string {
+	q := u.Query()
+	if !q.Has("token") {
+		return u.RequestURI()
+	}
+	q.Set("token", "[redacted]")
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.RequestURI()
+}
+
+// debugToken protects the /debug/ endpoints; they are not registered
+// without it.
+var debugToken string
+
+func requireDebugToken(next http.HandlerFunc) http.HandlerFunc {
+	return requireBearer(&debugToken, "debug_unauthorized", next)
+}
+
+// requireBearer answers 401 to requests without *token as their bearer
+// token, counting them as reason.
+func requireBearer(token *string, reason string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearer(r, *token) {
+			rejectRequest(w, r, reason, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// hasBearer reports whether r carries token, which must not be empty, as
+// its bearer token.
+func hasBearer(r *http.Request, token string) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// requireDebugTokenOrQuery is requireDebugToken for pages meant to be
+// opened in a browser, which also take the token as ?token=. Keep such
+// pages under /debug/, so the request capture never records the token.
+func requireDebugTokenOrQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(debugToken)) != 1 {
+			rejectRequest(w, r, "debug_unauthorized", "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestToken returns the ?token= of r, or else its bearer token.
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// capture is nil unless CAPTURE_REQUESTS is set.
+var capture *requestCapture
+
+func capturedRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := json.Marshal(map[string]interface{}{"requests": capture.snapshot()})
+	writeJSONWithETag(w, r, body, etagOf(body))
+}
+
+// eventLogHandler lists logged deliveries, oldest first. Query parameters:
+// type, status (comma-separated), since (RFC 3339), after (record ID, for
+// paging) and limit.
+func eventLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := webhooklog.Filter{EventType: q.Get("type")}
+	if v := q.Get("status"); v != "" {
+		f.Statuses = strings.Split(v, ",")
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid since, want RFC 3339", http.StatusBadRequest)
+			return
+		}
+		f.Since = since
+	}
+	f.AfterID, _ = strconv.ParseInt(q.Get("after"), 10, 64)
+	f.Limit, _ = strconv.Atoi(q.Get("limit"))
+
+	key := q.Encode() // sorted, so equivalent queries share an entry
+	if cached, ok := eventsCache.get(key); ok {
+		responseCacheStats.Add("hits", 1)
+		writeJSONWithETag(w, r, cached.body, cached.etag)
+		return
+	}
+	responseCacheStats.Add("misses", 1)
+	version := eventLogVersion.Load()
+	records, err := eventLog.Query(r.Context(), f)
+	if err != nil {
+		http.Error(w, "Event log query failed", http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []webhooklog.Record{}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"events": records})
+	etag := etagOf(body)
+	eventsCache.put(key, cachedResponse{version: version, stored: clock.Now(), body: body, etag: etag})
+	writeJSONWithETag(w, r, body, etag)
+}
+
+// consumerToken protects the /consumers/ endpoints, the pull API for
+// consumer groups over EVENT_LOG; they are not registered without it.
+var consumerToken string
+
+// consumerClaims counts the events claimed per consumer group.
+var consumerClaims = expvar.NewMap("consumer_claims")
+
+// Claim limits for the pull API.
+const (
+	defaultClaimLease = 30 * time.Second
+	maxClaimLease     = time.Hour
+	maxClaimBatch     = 1000
+)
+
+func requireConsumerToken(next http.HandlerFunc) http.HandlerFunc {
+	return requireBearer(&consumerToken, "consumer_unauthorized", next)
+}
+
+// consumerGroupsHandler lists consumer groups with their cursor and lag.
+func consumerGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := eventLog.Groups(r.Context())
+	if err != nil {
+		http.Error(w, "Event log query failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"groups": groups})
+}
+
+// consumerClaimHandler leases the next events of the stream to a group.
+// Query parameters: max (default 100) and lease (a duration, default 30s).
+func consumerClaimHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	max := 100
+	if v := q.Get("max"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxClaimBatch {
+			http.Error(w, fmt.Sprintf("Invalid max, want 1 to %d", maxClaimBatch), http.StatusBadRequest)
+			return
+		}
+		max = n
+	}
+	lease := defaultClaimLease
+	if v := q.Get("lease"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxClaimLease {
+			http.Error(w, "Invalid lease, want a duration up to "+maxClaimLease.String(), http.StatusBadRequest)
+			return
+		}
+		lease = d
+	}
+	group := mux.Vars(r)["group"]
+	claims, err := eventLog.Claim(r.Context(), group, max, lease)
+	if !consumerOK(w, err) {
+		return
+	}
+	if claims == nil {
+		claims = []webhooklog.Claim{}
+	}
+	consumerClaims.Add(group, int64(len(claims)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": claims})
+}
+
+// consumerAckHandler marks events as done for a group and answers with
+// its cursor. The body is {"ids": [...]}.
+func consumerAckHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := consumerIDs(w, r)
+	if !ok {
+		return
+	}
+	group := mux.Vars(r)["group"]
+	cursor, err := eventLog.Ack(r.Context(), group, ids...)
+	if !consumerOK(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"cursor": cursor})
+}
+
+// consumerNackHandler hands events back for the group to claim again
+// right away, instead of when their lease runs out. The body is
+// {"ids": [...]}.
+func consumerNackHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := consumerIDs(w, r)
+	if !ok {
+		return
+	}
+	if consumerOK(w, eventLog.Nack(r.Context(), mux.Vars(r)["group"], ids...)) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteConsumerHandler removes a group, so a group of the same name
+// starts again from the beginning of the stream.
+func deleteConsumerHandler(w http.ResponseWriter, r *http.Request) {
+	if consumerOK(w, eventLog.DeleteGroup(r.Context(), mux.Vars(r)["group"])) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func consumerIDs(w http.ResponseWriter, r *http.Request) ([]int64, bool) {
+	var body struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil || len(body.IDs) == 0 {
+		http.Error(w, `Invalid body, want {"ids": [...]}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return body.IDs, true
+}
+
+// consumerOK answers for err, if any, and reports whether there was none.
+func consumerOK(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, webhooklog.ErrInvalidGroup):
+		http.Error(w, "Invalid consumer group name: use up to 64 letters, digits, '.', '_' and '-'", http.StatusBadRequest)
+	case err != nil:
+		logger.Error("❌ Consumer group update failed", "error", err)
+		http.Error(w, "Event log update failed", http.StatusInternalServerError)
+	default:
+		return true
+	}
+	return false
+}
+
+// The WebSocket relay at /ws streams verified events to connected clients
+// as they arrive, for dashboards and debugging tools; it is not registered
+// without WS_TOKEN. Browsers cannot set headers on a WebSocket, so the
+// token may also be passed as ?token=. ?types=order.*,user.created limits
+// a client to the types its patterns match. Delivery is best effort: a
+// client that falls wsClientBuffer events behind is disconnected, and
+// clients that need every event should use consumer groups instead.
+var (
+	wsToken    string
+	wsOrigins  []string // besides the receiver's own; "*" allows any
+	wsHub      = newEventHub()
+	wsClients  = expvar.NewInt("ws_clients")
+	wsDropped  = expvar.NewInt("ws_dropped")
+	wsUpgrader = websocket.Upgrader{CheckOrigin: wsOriginAllowed}
+)
+
+const (
+	maxWSClients   = 100
+	wsClientBuffer = 256
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// relayedEvent is one message on /ws.
+type relayedEvent struct {
+	WebhookID  string    `json:"webhook_id,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Event      Event     `json:"event"`
+}
+
+// eventHub fans events out to the connected clients.
+type eventHub struct {
+	mu       sync.Mutex
+	clients  map[*wsClient]struct{}
+	closed   bool
+	handlers sync.WaitGroup // connections still open
+}
+
+type wsClient struct {
+	types []string
+	send  chan []byte
+	done  chan struct{} // closed when the hub drops the client
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: map[*wsClient]struct{}{}}
+}
+
+// join adds a client, or returns false when the hub is full or closed.
+func (h *eventHub) join(c *wsClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || len(h.clients) >= maxWSClients {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	h.handlers.Add(1)
+	wsClients.Set(int64(len(h.clients)))
+	return true
+}
+
+func (h *eventHub) leave(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dropLocked(c)
+}
+
+func (h *eventHub) dropLocked(c *wsClient) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.done)
+		wsClients.Set(int64(len(h.clients)))
+	}
+}
+
+// broadcast sends job's event to the clients that want its type. It never
+// blocks on a client.
+func (h *eventHub) broadcast(job eventJob) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) == 0 {
+		return
+	}
+	msg, err := json.Marshal(relayedEvent{WebhookID: job.WebhookID, ReceivedAt: clock.Now(),
+		OccurredAt: job.Event.OccurredAt, Event: job.Event})
+	if err != nil {
+		return
+	}
+	for c := range h.clients {
+		if !c.wants(job.Event.Type) {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			wsDropped.Add(1)
+			logger.Warn("🐢 WebSocket client too slow, disconnecting", "behind", wsClientBuffer)
+			h.dropLocked(c)
+		}
+	}
+}
+
+// close disconnects every client, refuses new ones, and waits until the
+// clients are sent their close frames or ctx is done.
+func (h *eventHub) close(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	for c := range h.clients {
+		h.dropLocked(c)
+	}
+	h.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		h.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *wsClient) wants(eventType string) bool {
+	if len(c.types) == 0 {
+		return true
+	}
+	for _, pattern := range c.types {
+		if matchesPattern(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// relayEvent hands an accepted event to the WebSocket relay, if it is on.
+func relayEvent(job eventJob) {
+	if wsToken != "" {
+		wsHub.broadcast(job)
+	}
+}
+
+// wsOriginAllowed accepts clients without an Origin, such as command-line
+// tools, those from the receiver's own host, and those in WS_ORIGINS.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range wsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(wsToken)) != 1 {
+		rejectRequest(w, r, "ws_unauthorized", "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	client := &wsClient{send: make(chan []byte, wsClientBuffer), done: make(chan struct{})}
+	if v := r.URL.Query().Get("types"); v != "" {
+		client.types = strings.Split(v, ",")
+	}
+	if !wsHub.join(client) {
+		rejectRequest(w, r, "ws_unavailable", "Too many WebSocket clients", http.StatusServiceUnavailable)
+		return
+	}
+	defer wsHub.handlers.Done()
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wsHub.leave(client) // the upgrader has answered
+		return
+	}
+	log := logger.With("remote", r.RemoteAddr)
+	log.Info("🔌 WebSocket client connected", "types", client.types)
+	defer log.Info("🔌 WebSocket client disconnected")
+
+	// Clients send nothing but control frames; reading handles those and
+	// notices when the client goes away.
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	})
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				wsHub.leave(client)
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	defer conn.Close()
+	for {
+		var err error
+		select {
+		case msg := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, msg)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case <-client.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+			return
+		}
+		if err != nil {
+			wsHub.leave(client)
+			return
+		}
+	}
+}
+
+// Traffic reports cover at most maxTrafficRange, 24 hours by default.
+const (
+	defaultTrafficRange = 24 * time.Hour
+	maxTrafficRange     = 31 * 24 * time.Hour
+)
+
+// trafficReportHandler reports deliveries per hour and event type from
+// EVENT_LOG, with their failure rates and p95 latencies: as a heatmap for
+// browsers, as JSON otherwise. Query parameters: to (RFC 3339, default the
+// end of the current hour), and from (RFC 3339) or hours (default 24).
+func trafficReportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := clock.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid to, want RFC 3339", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultTrafficRange)
+	if v := q.Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid hours", http.StatusBadRequest)
+			return
+		}
+		from = to.Add(-time.Duration(n) * time.Hour)
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid from, want RFC 3339", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) || to.Sub(from) > maxTrafficRange {
+		http.Error(w, fmt.Sprintf("Invalid range, want from before to and at most %d hours", int(maxTrafficRange.Hours())),
+			http.StatusBadRequest)
+		return
+	}
+
+	report, err := eventLog.Traffic(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Event log query failed", http.StatusInternalServerError)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		trafficPage.Execute(w, heatmap(report, q.Get("token")))
+		return
+	}
+	body, _ := json.Marshal(report)
+	writeJSONWithETag(w, r, body, etagOf(body))
+}
+
+// heatmapRow is a row of the traffic heatmap: all types, or one type.
+type heatmapRow struct {
+	Label string
+	Total int
+	Cells []heatmapCell
+}
+
+type heatmapCell struct {
+	Text  string
+	Title string
+	Style template.CSS
+}
+
+// heatmap lays report out as rows of hours, the totals first, then the
+// event types by number of deliveries. A cell is greener the fewer of its
+// deliveries failed, red from 20% on, and darker the more it has.
+func heatmap(report *webhooklog.TrafficReport, token string) map[string]interface{} {
+	busiest := 1
+	for _, h := range report.Hours {
+		if h.Deliveries > busiest {
+			busiest = h.Deliveries
+		}
+	}
+	cell := func(c webhooklog.TrafficCell, label string) heatmapCell {
+		title := c.Hour.Format("2006-01-02 15:00 UTC") + ", " + label
+		if c.Deliveries == 0 {
+			return heatmapCell{Title: title + ": no deliveries", Style: "background: #f4f4f4"}
+		}
+		hue := 120 * (1 - math.Min(c.FailureRate*5, 1))
+		light := 90 - 45*math.Log1p(float64(c.Deliveries))/math.Log1p(float64(busiest))
+		text := "#222"
+		if light < 55 {
+			text = "#fff"
+		}
+		return heatmapCell{
+			Text: strconv.Itoa(c.Deliveries),
+			Title: fmt.Sprintf("%s: %d deliveries, %.1f%% failed, p95 %.0f ms",
+				title, c.Deliveries, 100*c.FailureRate, c.LatencyP95),
+			Style: template.CSS(fmt.Sprintf("background: hsl(%.0f, 70%%, %.0f%%); color: %s", hue, light, text)),
+		}
+	}
+
+	index := map[int64]int{}
+	hours := make([]string, len(report.Hours))
+	all := heatmapRow{Label: "All deliveries"}
+	for i, h := range report.Hours {
+		index[h.Hour.Unix()] = i
+		hours[i] = h.Hour.Format("15")
+		all.Total += h.Deliveries
+		all.Cells = append(all.Cells, cell(h, "all deliveries"))
+	}
+	byType := map[string]*heatmapRow{}
+	var types []*heatmapRow
+	for _, c := range report.Cells {
+		label := c.EventType
+		if label == "" {
+			label = "(unparsed)"
+		}
+		row := byType[label]
+		if row == nil {
+			row = &heatmapRow{Label: label, Cells: make([]heatmapCell, len(report.Hours))}
+			for i, h := range report.Hours {
+				row.Cells[i] = cell(webhooklog.TrafficCell{Hour: h.Hour}, label)
+			}
+			byType[label] = row
+			types = append(types, row)
+		}
+		row.Total += c.Deliveries
+		row.Cells[index[c.Hour.Unix()]] = cell(c, label)
+	}
+	sort.SliceStable(types, func(i, j int) bool { return types[i].Total > types[j].Total })
+	rows := []heatmapRow{all}
+	for _, row := range types {
+		rows = append(rows, *row)
+	}
+	return map[string]interface{}{
+		"From": report.From.Format("2006-01-02 15:00"), "To": report.To.Format("2006-01-02 15:00"),
+		"Hours": hours, "Rows": rows, "Token": token,
+	}
+}
+
+var trafficPage = template.Must(template.New("traffic").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Traffic: Go webhook receiver</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 3rem; color: #222; }
+table { border-collapse: separate; border-spacing: 2px; font-size: 0.8rem; }
+th { font-weight: normal; color: #666; }
+th.type { text-align: right; padding-right: 0.5rem; white-space: nowrap; }
+td { min-width: 2rem; height: 1.6rem; text-align: center; }
+.detail { color: #666; font-size: 0.9rem; }
+</style>
+</head>
+<body>
+<h1>Traffic</h1>
+<p class="detail">{{.From}} to {{.To}} UTC. Green cells had no failures, red ones 20% or more; darker cells had more deliveries. Hover a cell for its failure rate and p95 latency.
+Last <a href="?hours=24{{with .Token}}&amp;token={{.}}{{end}}">24 hours</a>, <a href="?hours=168{{with .Token}}&amp;token={{.}}{{end}}">7 days</a>.</p>
+<table>
+<tr><th></th>{{range .Hours}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><th class="type">{{.Label}} ({{.Total}})</th>{{range .Cells}}<td style="{{.Style}}" title="{{.Title}}">{{.Text}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// validateRequest is the body of POST /debug/validate: a delivery as the
+// sender made it.
+type validateRequest struct {
+	// Endpoint is "codehooks" for /webhook, the default, or the provider
+	// of /webhook/{provider}.
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"` // the exact bytes signed
+}
+
+// validationReport is what a delivery would run into, step by step.
+type validationReport struct {
+	Endpoint  string          `json:"endpoint"`
+	Signature signatureCheck  `json:"signature"`
+	Payload   payloadCheck    `json:"payload"`
+	Routing   routingDecision `json:"routing"`
+}
+
+type signatureCheck struct {
+	Valid bool   `json:"valid"`
+	Code  string `json:"code,omitempty"` // rejection code, as in the response to a delivery
+	Error string `json:"error,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+
+	Scheme   string     `json:"scheme,omitempty"`
+	Secret   int        `json:"secret,omitempty"` // 1 for the current secret
+	Rotated  bool       `json:"rotated,omitempty"`
+	SignedAt *time.Time `json:"signed_at,omitempty"`
+
+	// BaseString is the message the signature must cover, for comparing
+	// with what the sender signed.
+	BaseString string `json:"base_string,omitempty"`
+}
+
+type payloadCheck struct {
+	Valid      bool              `json:"valid"`
+	DeliveryID string            `json:"delivery_id,omitempty"`
+	EventID    string            `json:"event_id,omitempty"`
+	EventType  string            `json:"event_type,omitempty"`
+	OccurredAt *time.Time        `json:"occurred_at,omitempty"`
+	Bytes      int               `json:"bytes"`
+	Checks     []validationCheck `json:"checks"`
+}
+
+// validationCheck is one check of the payload. Code is the rejection code
+// a delivery failing it gets.
+type validationCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Code   string `json:"code,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type routingDecision struct {
+	// Action is "process", "handshake", "duplicate", "reject", "ignore",
+	// "dead-letter", "paused" or "batch", and Status the response a
+	// delivery gets, or would get if every event of a batch went through.
+	Action string `json:"action"`
+	Status int    `json:"status"`
+
+	// Handler is the EventRouter pattern that would run, "default" for
+	// the default handler. Forwards are the forward targets.
+	Handler  string   `json:"handler,omitempty"`
+	Forwards []string `json:"forwards,omitempty"`
+}
+
+// validateHandler runs a delivery through the receiver's checks without
+// processing it: no handler runs, nothing is logged or forwarded, and an
+// accepted delivery is not recorded in the replay cache, so the same
+// delivery can still be sent for real. It never returns a signature it
+// computed, or the endpoint would sign anything for whoever holds
+// DEBUG_TOKEN.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	// Room for a body at the limit, escaped as a JSON string.
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxBodyBytes+64<<10)
+	var in validateRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "Invalid request, want {\"endpoint\", \"headers\", \"body\"}", http.StatusBadRequest)
+		return
+	}
+	if in.Endpoint == "" {
+		in.Endpoint = verifier.Name()
+	}
+	adapter, provider, path := webhookverify.ProviderAdapter(verifier), "", "/webhook"
+	if in.Endpoint != verifier.Name() {
+		if adapter = providers[in.Endpoint]; adapter == nil {
+			http.Error(w, fmt.Sprintf("Unknown endpoint %q", in.Endpoint), http.StatusBadRequest)
+			return
+		}
+		provider, path = in.Endpoint, path+"/"+in.Endpoint
+	}
+	adapter = withoutReplays(adapter)
+
+	body := []byte(in.Body)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Host, req.TLS = r.Host, r.TLS
+	for name, value := range in.Headers {
+		req.Header.Set(name, value)
+	}
+
+	report := validationReport{Endpoint: in.Endpoint}
+	report.Signature = checkSignature(req, adapter, body)
+	report.Payload, report.Routing = checkPayload(req, adapter, provider, body)
+	if !report.Signature.Valid && report.Routing.Action != "handshake" {
+		report.Routing = routingDecision{Action: "reject", Status: http.StatusUnauthorized}
+	}
+	pausesMu.Lock()
+	if pauses[in.Endpoint] != nil {
+		report.Routing = routingDecision{Action: "paused", Status: http.StatusGone}
+	}
+	pausesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// withoutReplays returns a copy of adapter that does not record the
+// signatures it accepts.
+func withoutReplays(adapter webhookverify.ProviderAdapter) webhookverify.ProviderAdapter {
+	switch a := adapter.(type) {
+	case *webhookverify.Verifier:
+		c := *a
+		c.Replays = nil
+		return &c
+	case *webhookverify.Stripe:
+		c := *a
+		c.Replays = nil
+		return &c
+	case *webhookverify.Slack:
+		c := *a
+		c.Replays = nil
+		return &c
+	}
+	return adapter
+}
+
+func checkSignature(req *http.Request, adapter webhookverify.ProviderAdapter, body []byte) signatureCheck {
+	var check signatureCheck
+	if bs, ok := adapter.(webhookverify.BaseStringer); ok {
+		check.BaseString, _ = bs.BaseString(req, body)
+	}
+	if !adapter.Signed(req) {
+		check.Code, check.Error = "missing_signature", webhookverify.ErrMissingHeader.Error()
+		check.Hint = rejectionHints[check.Code]
+		return check
+	}
+	result, err := adapter.CheckRequest(req, body)
+	if err != nil {
+		check.Code, check.Error = signatureRejection(err), err.Error()
+		check.Hint = rejectionHints[check.Code]
+		return check
+	}
+	check.Valid, check.Scheme = true, result.Scheme
+	check.Secret, check.Rotated = result.SecretIndex+1, result.Rotated
+	if !result.Timestamp.IsZero() {
+		t := result.Timestamp.UTC()
+		check.SignedAt = &t
+	}
+	return check
+}
+
+// checkPayload follows webhookHandler from the handshake check on, and
+// decides where a delivery with a valid signature would go. provider is
+// empty for Codehooks deliveries.
+func checkPayload(req *http.Request, adapter webhookverify.ProviderAdapter, provider string, body []byte) (payloadCheck, routingDecision) {
+	check := payloadCheck{Bytes: len(body), Checks: []validationCheck{}}
+	fail := func(name, code string, status int, detail string) (payloadCheck, routingDecision) {
+		check.Checks = append(check.Checks, validationCheck{Name: name, Code: code, Detail: detail})
+		return check, routingDecision{Action: "reject", Status: status}
+	}
+	pass := func(name, detail string) {
+		check.Checks = append(check.Checks, validationCheck{Name: name, OK: true, Detail: detail})
+	}
+
+	var handshake VerificationRequest
+	if json.Unmarshal(body, &handshake) == nil && adapter.Signed(req) &&
+		(handshake.Type == "webhook.verification" || handshake.Type == "url_verification") {
+		check.Valid, check.EventType = true, handshake.Type
+		return check, routingDecision{Action: "handshake", Status: http.StatusOK}
+	}
+
+	var providerType string
+	if provider != "" {
+		check.DeliveryID, providerType = adapter.Describe(req, body)
+	}
+	var err error
+
+	if payloadKey != nil && isJWE(body) {
+		if body, err = decryptPayload(body, payloadKey); err != nil {
+			return fail("decrypt", "invalid_encrypted_payload", http.StatusBadRequest, err.Error())
+		}
+		pass("decrypt", "")
+	} else if requireEncryptedPayload {
+		return fail("decrypt", "encryption_required", http.StatusBadRequest, "payload is not encrypted")
+	}
+
+	if items, ok := batchItems(body); ok && provider == "" {
+		if len(items) > maxBatchEvents {
+			return fail("batch", "batch_too_large", http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("%d events, the limit is %d", len(items), maxBatchEvents))
+		}
+		pass("batch", fmt.Sprintf("%d events, each checked and routed on its own", len(items)))
+		check.Valid = true
+		return check, routingDecision{Action: "batch", Status: http.StatusOK}
+	}
+	event, err := parseEvent(provider, req.Header, body, check.DeliveryID, providerType, clock.Now())
+	if err != nil {
+		return fail("parse", "invalid_payload", http.StatusBadRequest, err.Error())
+	}
+	pass("parse", "")
+	check.EventID, check.EventType = event.ID, event.Type
+	check.OccurredAt = &event.OccurredAt
+
+	if err := checkPayloadSize(event.Type, len(body)); err != nil {
+		return fail("payload_size", "payload_too_large", http.StatusRequestEntityTooLarge, err.Error())
+	}
+	pass("payload_size", fmt.Sprintf("%d bytes", len(body)))
+
+	if provider == "" {
+		check.DeliveryID = deliveryID(req.Header.Get("X-Webhook-Id"), event.ID)
+	}
+	if dedup != nil && check.DeliveryID != "" {
+		if seen, err := dedup.Contains(req.Context(), check.DeliveryID); err == nil && seen {
+			check.Valid = true
+			return check, routingDecision{Action: "duplicate", Status: http.StatusOK}
+		}
+	}
+
+	if isLifecycleEvent(adapter.Name(), event.Type) {
+		pass("lifecycle", "ends the subscription; the endpoint would be paused")
+	}
+	if !eventTypeAllowed(adapter.Name(), event.Type) {
+		check.Checks = append(check.Checks, validationCheck{Name: "event_type_allowed", Code: "unexpected_event_type",
+			Detail: "not in ALLOWED_EVENT_TYPES for " + adapter.Name()})
+		if unexpectedEventAction == "reject" {
+			return check, routingDecision{Action: "reject", Status: http.StatusUnprocessableEntity}
+		}
+		check.Valid = true
+		return check, routingDecision{Action: unexpectedEventAction, Status: http.StatusOK}
+	}
+	pass("event_type_allowed", "")
+
+	if problems := validateEventData(event); problems != nil {
+		for _, p := range problems {
+			check.Checks = append(check.Checks, validationCheck{Name: "schema", Code: "schema_violation",
+				Detail: fmt.Sprintf("%q: %s", p.Path, p.Message)})
+		}
+		if invalidEventAction == "dead-letter" {
+			check.Valid = true
+			return check, routingDecision{Action: "dead-letter", Status: http.StatusOK}
+		}
+		return check, routingDecision{Action: "reject", Status: http.StatusUnprocessableEntity}
+	}
+	pass("schema", "")
+
+	check.Valid = true
+	decision := routingDecision{Action: "process", Status: http.StatusOK, Handler: router.Route(event.Type)}
+	forwardersMu.RLock()
+	for _, f := range forwarders {
+		if matchesPattern(f.target.Pattern, event.Type) {
+			decision.Forwards = append(decision.Forwards, f.target.Name)
+		}
+	}
+	forwardersMu.RUnlock()
+	return check, decision
+}
+
+// eventLogVersion counts writes to the event log by this process. Cached
+// query responses are dropped when it changes.
+var eventLogVersion atomic.Int64
+
+// Other processes write to the log too: reprocess, webhookctl, a receiver
+// sharing the file or the process before a reload, and the primary of a
+// read-only replica. Cached responses are kept for at most
+// responseCacheTTL, so their writes show within that time.
+const responseCacheTTL = 5 * time.Second
+
+// responseCache keeps recent /debug/events responses, so dashboards polling
+// an unchanged log do not query the store each time.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	version int64
+	stored  time.Time
+	body    []byte
+	etag    string
+}
+
+const responseCacheSize = 64
+
+var (
+	eventsCache        = &responseCache{entries: map[string]cachedResponse{}}
+	responseCacheStats = expvar.NewMap("response_cache")
+)
+
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.fresh(entry) {
+		return cachedResponse{}, false
+	}
+	return entry, true
+}
+
+func (c *responseCache) fresh(entry cachedResponse) bool {
+	if clock.Now().Sub(entry.stored) >= responseCacheTTL {
+		return false
+	}
+	return readOnly || entry.version == eventLogVersion.Load()
+}
+
+func (c *responseCache) put(key string, entry cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= responseCacheSize {
+		for k, e := range c.entries {
+			if !c.fresh(e) || len(c.entries) >= responseCacheSize {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = entry
+}
+
+// etagOf returns a strong ETag for a response body.
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeJSONWithETag writes body, or 304 Not Modified when the client's
+// If-None-Match already names etag. Cache-Control makes browsers revalidate
+// on every poll instead of reusing a stale copy.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, body []byte, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			responseCacheStats.Add("not_modified", 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// loggedJob reads an event back from the payload of its log record,
+// decoded as the endpoint that accepted it decoded it, and checks it
+// against the payload limit for its type as it was checked on arrival.
+// Records logged before the endpoint was are Codehooks events.
+func loggedJob(rec webhooklog.Record) (eventJob, error) {
+	job := eventJob{WebhookID: rec.WebhookID, DeliveryID: rec.DeliveryID, SignedAt: rec.ReceivedAt, LogID: rec.ID}
+	body := []byte(rec.Payload)
+	if payloadKey != nil && isJWE(body) {
+		var err error
+		if body, err = decryptPayload(body, payloadKey); err != nil {
+			return job, fmt.Errorf("invalid encrypted payload: %v", err)
+		}
+	}
+	provider := rec.Endpoint
+	if provider == verifier.Name() {
+		provider = ""
+	}
+	event, err := parseEvent(provider, rec.Headers, body, rec.DeliveryID, rec.EventType, rec.ReceivedAt)
+	if err != nil {
+		return job, fmt.Errorf("invalid payload: %v", err)
+	}
+	if err := checkPayloadSize(event.Type, len(body)); err != nil {
+		return job, err
+	}
+	if rec.Endpoint == "" {
+		job.DeliveryID = deliveryID(rec.WebhookID, event.ID)
+	}
+	job.Event, job.Size = event, len(body)
+	return job, nil
+}
+
+// reprocess runs the handlers again for logged events that failed or were
+// never finished, such as events still queued when the receiver stopped.
+// Stop the receiver first, or both may process the same event.
+func reprocess() int {
+	if err := configure(); err != nil {
+		logger.Error("❌ Configuration error", "error", err)
+		return exitConfig
+	}
+	if eventLogPath == "" {
+		logger.Error("❌ reprocess needs EVENT_LOG")
+		return exitConfig
+	}
+	if readOnly {
+		logger.Error("❌ reprocess cannot run on a read-only replica; run it on the primary")
+		return exitConfig
+	}
+	var err error
+	if eventLog, err = openEventLog(); err != nil {
+		logger.Error("❌ Cannot open EVENT_LOG", "path", eventLogPath, "error", err)
+		return exitUnavailable
+	}
+	defer eventLog.Close()
+	registerHandlers(router)
+	registerScripts(router)
+	registerEnrichments()
+	registerForwards()
+
+	ctx := context.Background()
+	filter := webhooklog.Filter{Statuses: []string{webhooklog.StatusReceived, webhooklog.StatusSpilled, webhooklog.StatusFailed}}
+	var ok, failed int
+	for {
+		records, err := eventLog.Query(ctx, filter)
+		if err != nil {
+			logger.Error("❌ Event log query failed", "error", err)
+			return exitUnavailable
+		}
+		if len(records) == 0 {
+			break
+		}
+		for _, rec := range records {
+			filter.AfterID = rec.ID
+			job, err := loggedJob(rec)
+			if err != nil {
+				markDelivery(ctx, rec.ID, webhooklog.StatusFailed, err.Error())
+				failed++
+				continue
+			}
+			if err := checkSchema(job.Event); err != nil {
+				markDelivery(ctx, rec.ID, webhooklog.StatusFailed, err.Error())
+				failed++
+				continue
+			}
+			logger.Info("🔁 Reprocessing", "id", rec.ID, "event_type", job.Event.Type)
+			if err := processEvent(ctx, job); err != nil {
+				logger.Error("❌ Failed again", "id", rec.ID, "error", err)
+				failed++
+				continue
+			}
+			ok++
+		}
+	}
+
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	if err := drainForwards(drainCtx); err != nil {
+		logger.Warn("⚠️  Drain incomplete", "error", err)
+	}
+	logger.Info("✅ Reprocessed events", "processed", ok, "failed", failed)
+	if failed > 0 {
+		return exitRuntime
+	}
+	return exitOK
+}
+
+// setupProgress remembers the milestones a new receiver goes through, for
+// the status page.
+type setupProgress struct {
+	mu            sync.Mutex
+	handshakeAt   time.Time
+	handshakeKind string
+	eventAt       time.Time
+	eventType     string
+	eventID       string
+	rejectedAt    time.Time
+	rejectReason  string
+}
+
+var setup setupProgress
+
+func (p *setupProgress) handshake(kind string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handshakeAt, p.handshakeKind = clock.Now(), kind
+}
+
+func (p *setupProgress) event(event Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.eventAt, p.eventType, p.eventID = clock.Now(), event.Type, event.ID
+}
+
+func (p *setupProgress) rejected(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rejectedAt, p.rejectReason = clock.Now(), err.Error()
+}
+
+// setupCheck is one item of the setup checklist. Optional items do not
+// hold up the overall status.
+type setupCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Optional bool   `json:"optional,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+// publicURL is where senders reach the receiver, from PUBLIC_URL. When it
+// is empty, the status page asks a local ngrok agent.
+var publicURL string
+
+// ngrokTunnelsURL is the local ngrok agent API.
+const ngrokTunnelsURL = "http://127.0.0.1:4040/api/tunnels"
+
+// ngrokPublicURL returns the public URL of a running ngrok tunnel, or "".
+func ngrokPublicURL() string {
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+	resp, err := client.Get(ngrokTunnelsURL)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Tunnels []struct {
+			PublicURL string `json:"public_url"`
+		} `json:"tunnels"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) != nil {
+		return ""
+	}
+	found := ""
+	for _, t := range body.Tunnels {
+		if strings.HasPrefix(t.PublicURL, "https://") {
+			return t.PublicURL
+		}
+		found = t.PublicURL
+	}
+	return found
+}
+
+// notifySink is a configured notification URL.
+type notifySink struct {
+	name, url string
+}
+
+func notifySinks() []notifySink {
+	var sinks []notifySink
+	if volume != nil && volume.notifyURL != "" {
+		sinks = append(sinks, notifySink{"ANOMALY_NOTIFY_URL", volume.notifyURL})
+	}
+	if slo != nil && slo.notifyURL != "" {
+		sinks = append(sinks, notifySink{"SLO_NOTIFY_URL", slo.notifyURL})
+	}
+	if digestNotifyURL != "" {
+		sinks = append(sinks, notifySink{"DIGEST_NOTIFY_URL", digestNotifyURL})
+	}
+	if lifecycleNotifyURL != "" {
+		sinks = append(sinks, notifySink{"LIFECYCLE_NOTIFY_URL", lifecycleNotifyURL})
+	}
+	return sinks
+}
+
+// setupProbeTTL is how long the status page reuses what it found out over
+// the network, so a page refreshing every few seconds, or anyone fetching
+// it, does not dial the sinks and the ngrok agent on every request.
+const setupProbeTTL = time.Minute
+
+var setupProbes = struct {
+	mu      sync.Mutex
+	results map[string]setupProbe
+}{results: map[string]setupProbe{}}
+
+type setupProbe struct {
+	value string
+	at    time.Time
+}
+
+// probe returns what run returned for key within setupProbeTTL, or runs it.
+func probe(key string, run func() string) string {
+	setupProbes.mu.Lock()
+	p, ok := setupProbes.results[key]
+	setupProbes.mu.Unlock()
+	if ok && clock.Now().Sub(p.at) < setupProbeTTL {
+		return p.value
+	}
+	value := run()
+	setupProbes.mu.Lock()
+	setupProbes.results[key] = setupProbe{value: value, at: clock.Now()}
+	setupProbes.mu.Unlock()
+	return value
+}
+
+// dialURL checks that the host of raw accepts TCP connections.
+func dialURL(dialer *net.Dialer, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// setupChecklist reports what is configured and what has happened so far.
+// Without details, items only say whether they pass and what to do when
+// they do not: notification URLs can be credentials, such as Slack
+// webhook URLs, and event IDs and rejection reasons are nobody else's
+// business.
+func setupChecklist(details bool) []setupCheck {
+	ago := func(t time.Time) string {
+		return clock.Now().Sub(t).Round(time.Second).String() + " ago"
+	}
+	var checks []setupCheck
+
+	secret := setupCheck{Name: "Webhook secret configured", OK: webhookSecrets[0] != placeholderSecret}
+	switch weakness := weakestSecret(); {
+	case !secret.OK:
+		secret.Detail = "set WEBHOOK_SECRET to the secret shown when you create the webhook"
+	case !details:
+	case weakness != "":
+		secret.Detail = "⚠️ " + weakness
+	default:
+		secret.Detail = fmt.Sprintf("%d secret(s)", len(webhookSecrets))
+	}
+	checks = append(checks, secret)
+
+	public := setupCheck{Name: "Public URL"}
+	switch u := publicURL; {
+	case u != "":
+		public.OK, public.Detail = true, strings.TrimSuffix(u, "/")+"/webhook"
+	case relayAddr != "":
+		public.OK, public.Detail = true, "deliveries arrive through a relay"
+		if details {
+			public.Detail += " at " + relayAddr
+		}
+	default:
+		if u := probe("ngrok", ngrokPublicURL); u != "" {
+			public.OK, public.Detail = true, u+"/webhook (ngrok)"
+		} else {
+			public.Detail = "start a tunnel such as `ngrok http 8080`, or set PUBLIC_URL"
+		}
+	}
+	checks = append(checks, public)
+
+	setup.mu.Lock()
+	handshake := setupCheck{Name: "Verification handshake completed", Optional: true, Detail: "not received yet; only some senders send one"}
+	if !setup.handshakeAt.IsZero() {
+		handshake.OK, handshake.Detail = true, setup.handshakeKind+", "+ago(setup.handshakeAt)
+	}
+	event := setupCheck{Name: "Signed event received", Detail: "none yet; send a test event from the sender"}
+	if !setup.eventAt.IsZero() {
+		event.OK, event.Detail = true, "received "+ago(setup.eventAt)
+		if details {
+			event.Detail = fmt.Sprintf("%s %s, %s", setup.eventType, setup.eventID, ago(setup.eventAt))
+		}
+	}
+	if setup.rejectedAt.After(setup.eventAt) {
+		event.Detail += "; last rejected " + ago(setup.rejectedAt)
+		if details {
+			event.Detail += ": " + setup.rejectReason
+		}
+	}
+	setup.mu.Unlock()
+	checks = append(checks, handshake, event)
+
+	sinks := notifySinks()
+	if len(sinks) == 0 {
+		checks = append(checks, setupCheck{Name: "Notification sinks", OK: true, Optional: true, Detail: "none configured"})
+	}
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	for _, sink := range sinks {
+		failure := probe("sink "+sink.url, func() string {
+			if err := dialURL(dialer, sink.url); err != nil {
+				return err.Error()
+			}
+			return ""
+		})
+		check := setupCheck{Name: sink.name + " reachable", OK: failure == ""}
+		switch {
+		case !details && !check.OK:
+			check.Detail = "cannot connect"
+		case !details:
+		case check.OK:
+			check.Detail = sink.url
+		default:
+			check.Detail = failure
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>Go webhook receiver</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 3rem auto; color: #222; }
+li { list-style: none; margin: 0.75rem 0; }
+.detail { color: #666; font-size: 0.9rem; margin-left: 1.75rem; }
+</style>
+</head>
+<body>
+<h1>Go webhook receiver</h1>
+<p>{{if .ReadOnly}}📖 Read-only replica: queries are served, deliveries are refused.{{else if .Ready}}✅ Ready to receive webhooks.{{else}}Setup is not finished yet. This page refreshes every few seconds.{{end}}</p>
+<ul>
+{{range .Checks}}<li>{{if .OK}}✅{{else if .Optional}}⬜{{else}}❌{{end}} {{.Name}}<div class="detail">{{.Detail}}</div></li>
+{{end}}</ul>
+<p>Endpoint: <code>POST /webhook</code></p>
+{{if .Reports}}<p><a href="/debug/reports/traffic">Traffic report</a>, with <code>?token=</code> set to <code>DEBUG_TOKEN</code></p>
+{{end}}</body>
+</html>
+`))
+
+// homeHandler serves the setup checklist, as a page for browsers and as
+// JSON otherwise.
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	checks := setupChecklist(hasBearer(r, debugToken))
+	ready := true
+	for _, c := range checks {
+		if !c.OK && !c.Optional {
+			ready = false
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusPage.Execute(w, map[string]interface{}{"Ready": ready, "Checks": checks, "ReadOnly": readOnly,
+			"Reports": eventLog != nil && debugToken != ""})
+		return
+	}
+	endpoints := map[string]string{"webhook": "POST /webhook"}
+	for name := range providers {
+		endpoints[name] = "POST /webhook/" + name
+	}
+	response := map[string]interface{}{
+		"status":    "ok",
+		"message":   "Go webhook receiver",
+		"endpoints": endpoints,
+		"ready":     ready,
+		"setup":     checks,
+		"read_only": readOnly,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Environment variables used to hand the listening socket to a reloaded
+// process. The parent passes the socket and a readiness pipe as inherited
+// file descriptors (see reload).
+const (
+	listenFDEnv = "RECEIVER_LISTEN_FD"
+	readyFDEnv  = "RECEIVER_READY_FD"
+)
+
+// listen returns the socket inherited from a previous process if there is
+// one, otherwise it binds addr.
+func listen(addr string) (net.Listener, error) {
+	fd := os.Getenv(listenFDEnv)
+	if fd == "" {
+		return net.Listen("tcp", addr)
+	}
+
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", listenFDEnv, err)
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// signalReady tells the parent process that this process is serving, so it
+// can stop accepting and drain its in-flight requests.
+func signalReady() {
+	fd := os.Getenv(readyFDEnv)
+	if fd == "" {
+		return
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// reload starts a new copy of the current executable that inherits the
+// listening socket, and returns once the new process is serving. Requests
+// arriving in the meantime queue in the shared socket's backlog, so none
+// are refused.
+func reload(ln net.Listener) error {
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("listener does not support descriptor passing")
+	}
+	lf, err := tcp.File()
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{lf, readyW} // fd 3 and 4 in the child
+	cmd.Env = append(os.Environ(), listenFDEnv+"=3", readyFDEnv+"=4")
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	// The child writes one byte once it is serving. EOF without that byte
+	// means it exited during startup.
+	result := make(chan error, 1)
+	go func() {
+		if n, _ := ready.Read(make([]byte, 1)); n == 1 {
+			result <- nil
+		} else {
+			result <- fmt.Errorf("new process exited before it was ready")
+		}
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			cmd.Wait()
+		}
+		return err
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new process did not become ready within 30s")
+	}
+}
+
+// relayListener accepts deliveries over connections it dials out to a
+// relay (see "webhookctl relay"), for receivers in networks that cannot
+// expose an inbound port. It keeps a pool of idle tunnel connections; each
+// one becomes an accepted connection once the relay assigns it a client.
+type relayListener struct {
+	addr      string
+	token     string
+	tlsConfig *tls.Config
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	idle map[net.Conn]bool
+}
+
+// Tunnel protocol bytes sent by the relay, see webhookctl's relay.go.
+const (
+	relayHeartbeat  = 0
+	relayAccept     = 1
+	relayAcceptFrom = 2 // followed by the client's "<ip>:<port>\n"
+)
+
+// relayConn is a tunnel connection assigned to a client. Its remote
+// address is the client's as the relay saw it, so logs and per-IP rate
+// limits see the sender rather than the relay. It is not a *tls.Conn, so
+// the TLS session with the relay is not mistaken for the client's.
+type relayConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *relayConn) RemoteAddr() net.Addr { return c.remote }
+
+func newRelayListener(addr, token string, pool int, tlsConfig *tls.Config) *relayListener {
+	l := &relayListener{
+		addr:      addr,
+		token:     token,
+		tlsConfig: tlsConfig,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+		idle:      map[net.Conn]bool{},
+	}
+	for i := 0; i < pool; i++ {
+		go l.keepTunnel()
+	}
+	return l
+}
+
+// keepTunnel keeps one idle tunnel connection open, handing it to Accept
+// when the relay assigns a client and dialing a replacement.
+func (l *relayListener) keepTunnel() {
+	backoff := time.Second
+	for {
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+
+		conn, err := l.dialTunnel()
+		if err != nil {
+			logger.Warn("⚠️  Relay connection failed, retrying", "backoff", backoff, "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-l.done:
+				return
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		client, ok := l.waitForClient(conn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		select {
+		case l.conns <- client:
+		case <-l.done:
+			client.Close()
+			return
+		}
+	}
+}
+
+func (l *relayListener) dialTunnel() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if l.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", l.addr, l.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", l.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte(l.token + "\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// waitForClient blocks until the relay assigns a client to conn, and
+// returns conn as that client's connection. It returns false if the tunnel
+// dies or the listener is closed first.
+func (l *relayListener) waitForClient(conn net.Conn) (net.Conn, bool) {
+	l.mu.Lock()
+	l.idle[conn] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.idle, conn)
+		l.mu.Unlock()
+	}()
+
+	b := make([]byte, 1)
+	for {
+		// The relay sends a heartbeat every 30s while the tunnel is idle.
+		conn.SetReadDeadline(time.Now().Add(90 * time.Second))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, false
+		}
+		switch b[0] {
+		case relayAccept:
+			conn.SetReadDeadline(time.Time{})
+			return &relayConn{Conn: conn, remote: conn.RemoteAddr()}, true
+		case relayAcceptFrom:
+			remote, err := readRelayClient(conn)
+			if err != nil {
+				logger.Warn("⚠️  Relay sent an invalid client address", "error", err)
+				return nil, false
+			}
+			conn.SetReadDeadline(time.Time{})
+			return &relayConn{Conn: conn, remote: remote}, true
+		}
+	}
+}
+
+// readRelayClient reads the client address the relay sends after
+// relayAcceptFrom. It reads a byte at a time, so none of the client's
+// request is consumed.
+func readRelayClient(conn net.Conn) (net.Addr, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if line = append(line, b[0]); len(line) > 64 {
+			return nil, fmt.Errorf("client address too long")
+		}
+	}
+	addr, err := netip.ParseAddrPort(string(line))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client address: %q", line)
+	}
+	return net.TCPAddrFromAddrPort(addr), nil
+}
+
+func (l *relayListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *relayListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.mu.Lock()
+		for conn := range l.idle {
+			conn.Close()
+		}
+		l.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *relayListener) Addr() net.Addr {
+	return relayNetAddr(l.addr)
+}
+
+type relayNetAddr string
+
+func (a relayNetAddr) Network() string { return "relay" }
+func (a relayNetAddr) String() string  { return string(a) }
+
+// Exit codes, so orchestration and runbooks can branch on why the
+// receiver stopped.
+const (
+	exitOK          = 0 // clean shutdown, or diagnose found no problems
+	exitRuntime     = 1 // unexpected error while serving
+	exitConfig      = 2 // invalid configuration
+	exitBind        = 3 // could not bind a listening address
+	exitUnavailable = 4 // a dependency (relay, notification URL) is unreachable
+)
+
+const listenAddr = ":8080"
+
+// Settings read by configure that are only used while starting up.
+var (
+	metricsAddr    string
+	drainTimeout   = 30 * time.Second
+	eventLogPath   string
+	eventLogBodies string
+	readOnly       bool
+	queueWorkers   int
+	relayAddr      string
+	relayToken     string
+	relayPool      = 4
+	relayTLS       bool
+	tlsConfig      *tls.Config // nil serves plain HTTP
+
+	customerLookupURL   string
+	customerLookupToken string
+
+	forwardURLs   []string
+	forwardSecret string
+
+	replayCachePath string
+)
+
+// configure reads the environment into the settings above. It does not
+// start anything, so diagnose can call it too.
+func configure() error {
+	var err error
+	if logger, err = newLogger(os.Getenv("LOG_FORMAT")); err != nil {
+		logger = slog.New(newConsoleHandler(os.Stdout))
+		return err
+	}
+
+	webhookSecrets = nil
+	if v := os.Getenv("WEBHOOK_SECRETS"); v != "" {
+		if os.Getenv("WEBHOOK_SECRET") != "" {
+			return fmt.Errorf("set WEBHOOK_SECRET or WEBHOOK_SECRETS, not both")
+		}
+		for _, secret := range strings.Split(v, ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				webhookSecrets = append(webhookSecrets, secret)
+			}
+		}
+		if len(webhookSecrets) == 0 {
+			return fmt.Errorf("invalid WEBHOOK_SECRETS: no secrets listed")
+		}
+	} else if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		webhookSecrets = []string{secret}
+	} else {
+		webhookSecrets = []string{placeholderSecret}
+	}
+	clock = webhookclock.Real
+	fixedClock = os.Getenv("FIXED_CLOCK")
+	if fixedClock != "" {
+		at, err := time.Parse(time.RFC3339, fixedClock)
+		if err != nil {
+			return fmt.Errorf("invalid FIXED_CLOCK: %q, want RFC 3339 such as 2024-01-01T00:00:00Z", fixedClock)
+		}
+		clock = webhookclock.NewFake(at)
+	}
+
+	verifier = webhookverify.New(webhookSecrets[0])
+	verifier.Clock = clock
+	verifier.Secrets = webhookSecrets[1:]
+	if v := os.Getenv("SIGNATURE_SCHEMES"); v != "" {
+		verifier.Schemes = nil
+		for _, name := range strings.Split(v, ",") {
+			scheme, ok := webhookverify.Lookup(strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("invalid SIGNATURE_SCHEMES: unknown scheme %q", name)
+			}
+			verifier.Schemes = append(verifier.Schemes, scheme)
+		}
+	}
+	allowWeakSecret = os.Getenv("ALLOW_WEAK_SECRET") == "true"
+
+	if v := os.Getenv("PAYLOAD_ENCRYPTION_KEY"); v != "" {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("invalid PAYLOAD_ENCRYPTION_KEY: must be base64: %v", err)
+		}
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return fmt.Errorf("invalid PAYLOAD_ENCRYPTION_KEY: got %d bytes, want 16, 24 or 32", n)
+		}
+		payloadKey = key
+	}
+	requireEncryptedPayload = os.Getenv("REQUIRE_ENCRYPTED_PAYLOAD") == "true"
+	if requireEncryptedPayload && payloadKey == nil {
+		return fmt.Errorf("REQUIRE_ENCRYPTED_PAYLOAD needs PAYLOAD_ENCRYPTION_KEY")
+	}
+
+	if v := os.Getenv("ANOMALY_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid ANOMALY_WINDOW: %q", v)
+		}
+		factor := 3.0
+		if v := os.Getenv("ANOMALY_FACTOR"); v != "" {
+			if factor, err = strconv.ParseFloat(v, 64); err != nil || factor <= 1 {
+				return fmt.Errorf("invalid ANOMALY_FACTOR: %q, must be greater than 1", v)
+			}
+		}
+		volume = newVolumeMonitor(window, factor, os.Getenv("ANOMALY_NOTIFY_URL"))
+	}
+
+	dedupCapacity := 10000
+	if v := os.Getenv("DEDUP_CAPACITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid DEDUP_CAPACITY: %q", v)
+		}
+		dedupCapacity = n
+	}
+	if v := os.Getenv("DEDUP_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("invalid DEDUP_TTL: %q", v)
+		}
+		dedupTTL = ttl
+	}
+	dedup = nil
+	if dedupTTL > 0 {
+		store := webhookdedup.NewMemory(dedupCapacity)
+		store.Clock = clock
+		dedup = store
+	}
+
+	queue = nil
+	if v := os.Getenv("WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid WORKERS: %q", v)
+		}
+		queueWorkers = n
+		size := 1000
+		if v := os.Getenv("QUEUE_SIZE"); v != "" {
+			if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+				return fmt.Errorf("invalid QUEUE_SIZE: %q", v)
+			}
+		}
+		var maxBytes int64
+		if v := os.Getenv("QUEUE_MAX_BYTES"); v != "" {
+			if maxBytes, err = strconv.ParseInt(v, 10, 64); err != nil || maxBytes <= 0 {
+				return fmt.Errorf("invalid QUEUE_MAX_BYTES: %q", v)
+			}
+		}
+		queue = newWorkQueue(size, maxBytes)
+	} else if os.Getenv("QUEUE_SIZE") != "" {
+		return fmt.Errorf("QUEUE_SIZE needs WORKERS")
+	} else if os.Getenv("QUEUE_MAX_BYTES") != "" {
+		return fmt.Errorf("QUEUE_MAX_BYTES needs WORKERS")
+	}
+
+	if v := os.Getenv("SLO_TARGET"); v != "" {
+		target, err := strconv.ParseFloat(v, 64)
+		if err != nil || target <= 0 || target >= 1 {
+			return fmt.Errorf("invalid SLO_TARGET: %q, must be between 0 and 1", v)
+		}
+		latency := 30 * time.Second
+		if v := os.Getenv("SLO_LATENCY"); v != "" {
+			if latency, err = time.ParseDuration(v); err != nil || latency <= 0 {
+				return fmt.Errorf("invalid SLO_LATENCY: %q", v)
+			}
+		}
+		slo = newSLOTracker(target, latency, os.Getenv("SLO_NOTIFY_URL"))
+	}
+	digestNotifyURL = os.Getenv("DIGEST_NOTIFY_URL")
+	publicURL = os.Getenv("PUBLIC_URL")
+	tracingEnabled = otlpEndpoint() != ""
+
+	debugToken = os.Getenv("DEBUG_TOKEN")
+	eventLogPath = os.Getenv("EVENT_LOG")
+	eventLogBodies = os.Getenv("EVENT_LOG_BODIES")
+	readOnly = os.Getenv("READ_ONLY") == "true"
+	replayCachePath = os.Getenv("REPLAY_CACHE")
+	customerLookupURL = os.Getenv("CUSTOMER_LOOKUP_URL")
+	customerLookupToken = os.Getenv("CUSTOMER_LOOKUP_TOKEN")
+	forwardURLs, forwardSecret = nil, os.Getenv("FORWARD_SECRET")
+	if v := os.Getenv("FORWARD_URLS"); v != "" {
+		if forwardSecret == "" {
+			return fmt.Errorf("FORWARD_URLS needs FORWARD_SECRET")
+		}
+		for _, target := range strings.Split(v, ",") {
+			forwardURLs = append(forwardURLs, strings.TrimSpace(target))
+		}
+	}
+	if eventLogBodies != "" && eventLogPath == "" {
+		return fmt.Errorf("EVENT_LOG_BODIES needs EVENT_LOG")
+	}
+	wsToken, wsOrigins = os.Getenv("WS_TOKEN"), nil
+	if v := os.Getenv("WS_ORIGINS"); v != "" {
+		if wsToken == "" {
+			return fmt.Errorf("WS_ORIGINS needs WS_TOKEN")
+		}
+		for _, origin := range strings.Split(v, ",") {
+			wsOrigins = append(wsOrigins, strings.TrimSpace(origin))
+		}
+	}
+	consumerToken = os.Getenv("CONSUMER_TOKEN")
+	if consumerToken != "" && eventLogPath == "" {
+		return fmt.Errorf("CONSUMER_TOKEN needs EVENT_LOG")
+	}
+	if v := os.Getenv("CAPTURE_REQUESTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid CAPTURE_REQUESTS: %q", v)
+		}
+		if debugToken == "" {
+			return fmt.Errorf("CAPTURE_REQUESTS needs DEBUG_TOKEN to protect GET /debug/requests")
+		}
+		capture = newRequestCapture(n)
+	}
+
+	if v := os.Getenv("PAYLOAD_TYPE_LIMITS"); v != "" {
+		limits, err := parsePayloadTypeLimits(v)
+		if err != nil {
+			return fmt.Errorf("invalid PAYLOAD_TYPE_LIMITS: %v", err)
+		}
+		payloadTypeLimits = limits
+	}
+
+	requireContentDigest = os.Getenv("REQUIRE_CONTENT_DIGEST") == "true"
+	strictRequests = os.Getenv("STRICT_REQUESTS") == "true"
+	if v := os.Getenv("MAX_HEADER_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MAX_HEADER_BYTES: %q", v)
+		}
+		maxHeaderBytes = n
+	}
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MAX_BODY_BYTES: %q", v)
+		}
+		maxBodyBytes = n
+	}
+	if v := os.Getenv("MAX_DECOMPRESSED_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MAX_DECOMPRESSED_BYTES: %q", v)
+		}
+		maxDecodedBytes = n
+	}
+	switch signedBody = os.Getenv("SIGNED_BODY"); signedBody {
+	case "":
+		signedBody = "decoded"
+	case "decoded", "encoded":
+	default:
+		return fmt.Errorf("invalid SIGNED_BODY: %q, want decoded or encoded", signedBody)
+	}
+
+	limiter = nil
+	var perIP, global rateLimit
+	if v := os.Getenv("RATE_LIMIT_PER_IP"); v != "" {
+		if perIP, err = parseRateLimit(v); err != nil {
+			return fmt.Errorf("invalid RATE_LIMIT_PER_IP: %q, %v", v, err)
+		}
+	}
+	if v := os.Getenv("RATE_LIMIT_GLOBAL"); v != "" {
+		if global, err = parseRateLimit(v); err != nil {
+			return fmt.Errorf("invalid RATE_LIMIT_GLOBAL: %q, %v", v, err)
+		}
+	}
+	if perIP.rate > 0 || global.rate > 0 {
+		limiter = newRateLimiter(perIP, global)
+	}
+	trustedProxies = nil
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		if trustedProxies, err = parseTrustedProxies(v); err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
+	metricsAddr = os.Getenv("METRICS_ADDR")
+	if v := os.Getenv("DRAIN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid DRAIN_TIMEOUT: %v", err)
+		}
+		drainTimeout = d
+	}
+
+	relayAddr = os.Getenv("RELAY_ADDR")
+	if relayAddr != "" {
+		relayToken = os.Getenv("RELAY_TOKEN")
+		if relayToken == "" {
+			return fmt.Errorf("RELAY_ADDR needs RELAY_TOKEN")
+		}
+		if v := os.Getenv("RELAY_POOL"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid RELAY_POOL: %q", v)
+			}
+			relayPool = n
+		}
+		relayTLS = os.Getenv("RELAY_TLS") == "true"
+	}
+
+	// Twilio signs the public URL, so PUBLIC_URL must be read by now.
+	if providers, err = loadProviders(os.Getenv("PROVIDER_SECRETS")); err != nil {
+		return err
+	}
+	allowedEventTypes = nil
+	if v := os.Getenv("ALLOWED_EVENT_TYPES"); v != "" {
+		if allowedEventTypes, err = parseAllowedEventTypes(v); err != nil {
+			return fmt.Errorf("invalid ALLOWED_EVENT_TYPES: %v", err)
+		}
+	}
+	lifecycleEvents = nil
+	if v := os.Getenv("LIFECYCLE_EVENTS"); v != "" {
+		if lifecycleEvents, err = parseAllowedEventTypes(v); err != nil {
+			return fmt.Errorf("invalid LIFECYCLE_EVENTS: %v", err)
+		}
+	}
+	lifecycleNotifyURL = os.Getenv("LIFECYCLE_NOTIFY_URL")
+	switch unexpectedEventAction = os.Getenv("UNEXPECTED_EVENT_ACTION"); unexpectedEventAction {
+	case "":
+		unexpectedEventAction = "ignore"
+	case "ignore", "reject", "dead-letter":
+	default:
+		return fmt.Errorf("invalid UNEXPECTED_EVENT_ACTION: %q, want ignore, reject or dead-letter", unexpectedEventAction)
+	}
+
+	schemaDir = os.Getenv("SCHEMA_DIR")
+	schemas = nil
+	if schemaDir != "" {
+		if schemas, err = loadSchemaDir(schemaDir); err != nil {
+			return fmt.Errorf("invalid SCHEMA_DIR: %v", err)
+		}
+	}
+	scriptDir = os.Getenv("SCRIPT_DIR")
+	scripts, scriptSandbox = nil, nil
+	if scriptDir != "" {
+		if scripts, err = loadScriptDir(scriptDir); err != nil {
+			return fmt.Errorf("invalid SCRIPT_DIR: %v", err)
+		}
+		var limits webhookscript.Limits
+		if v := os.Getenv("SCRIPT_CPU"); v != "" {
+			if limits.CPU, err = time.ParseDuration(v); err != nil || limits.CPU <= 0 {
+				return fmt.Errorf("invalid SCRIPT_CPU: %q", v)
+			}
+		}
+		if v := os.Getenv("SCRIPT_MEMORY"); v != "" {
+			if limits.Memory, err = strconv.ParseInt(v, 10, 64); err != nil || limits.Memory <= 0 {
+				return fmt.Errorf("invalid SCRIPT_MEMORY: %q", v)
+			}
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("SCRIPT_DIR needs the receiver's executable: %v", err)
+		}
+		scriptSandbox = &webhookscript.Sandbox{
+			Command: []string{exe, "run-script"},
+			Limits:  limits,
+			Console: func(script, line string) {
+				logger.Info("📜 Script output", "script", script, "line", line)
+			},
+		}
+	} else if os.Getenv("SCRIPT_CPU") != "" || os.Getenv("SCRIPT_MEMORY") != "" {
+		return fmt.Errorf("SCRIPT_CPU and SCRIPT_MEMORY need SCRIPT_DIR")
+	}
+	switch invalidEventAction = os.Getenv("INVALID_EVENT_ACTION"); invalidEventAction {
+	case "":
+		invalidEventAction = "reject"
+	case "reject", "dead-letter":
+	default:
+		return fmt.Errorf("invalid INVALID_EVENT_ACTION: %q, want reject or dead-letter", invalidEventAction)
+	}
+	tlsConfig, err = loadTLSConfig()
+	return err
+}
+
+// providerData decodes a provider payload into event data: a JSON object
+// as is, a JSON array, such as a SendGrid batch, under "events", and a form,
+// such as a Twilio callback, with one string per field.
+func providerData(header http.Header, body []byte) (map[string]interface{}, error) {
+	if strings.HasPrefix(header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		data := make(map[string]interface{}, len(form))
+		for name := range form {
+			data[name] = form.Get(name)
+		}
+		return data, nil
+	}
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	switch p := payload.(type) {
+	case map[string]interface{}:
+		return p, nil
+	case []interface{}:
+		return map[string]interface{}{"events": p}, nil
+	}
+	return nil, fmt.Errorf("payload is neither a JSON object nor an array")
+}
+
+// parseEvent decodes a verified, decrypted body as provider's endpoint
+// does, provider being empty for Codehooks events. A provider payload
+// becomes the data of an event with the delivery ID and type its adapter
+// described. signedAt stands in for the time the event happened when the
+// payload does not say.
+func parseEvent(provider string, header http.Header, body []byte, id, eventType string, signedAt time.Time) (Event, error) {
+	var event Event
+	var err error
+	if provider != "" {
+		event = Event{ID: id, Type: eventType}
+		event.Data, err = providerData(header, body)
+	} else {
+		err = json.Unmarshal(body, &event)
+	}
+	if err != nil {
+		return event, err
+	}
+	event.OccurredAt = eventTime(provider, event, signedAt)
+	if event.Created == 0 {
+		event.Created = event.OccurredAt.Unix()
+	}
+	return event, nil
+}
+
+// Where each provider puts the time an event happened, in the order they
+// are tried. The formats differ: Stripe and Slack send Unix seconds,
+// Slack's event_ts has a fraction, Shopify and GitHub send RFC 3339,
+// Twilio RFC 1123, and SendGrid Unix seconds per event in a batch.
+var providerTimeFields = map[string][]string{
+	"stripe":   {"created"},
+	"github":   {"head_commit.timestamp", "pull_request.updated_at", "issue.updated_at", "release.published_at", "repository.pushed_at"},
+	"slack":    {"event_time", "event.event_ts", "action_ts"},
+	"shopify":  {"updated_at", "created_at", "processed_at"},
+	"twilio":   {"Timestamp", "DateUpdated", "DateCreated"},
+	"sendgrid": {"events.0.timestamp"},
+}
+
+// eventTime returns when event happened according to its source, in UTC.
+// Codehooks events carry it in created; provider events in one of
+// providerTimeFields. fallback, the signing or arrival time, is used when
+// neither holds a time webhooktime understands.
+func eventTime(provider string, event Event, fallback time.Time) time.Time {
+	if provider == "" {
+		if event.Created != 0 {
+			return webhooktime.Unix(event.Created)
+		}
+	} else if t, ok := webhooktime.Field(event.Data, providerTimeFields[provider]...); ok {
+		return t
+	}
+	return fallback.UTC()
+}
+
+// Adapters for the sources in PROVIDER_SECRETS, by name, served on
+// /webhook/{provider}.
+var providers map[string]webhookverify.ProviderAdapter
+
+// loadProviders parses PROVIDER_SECRETS, a comma-separated list of
+// name=secret pairs. A name listed more than once accepts each of its
+// secrets, newest first, as while rotating. SendGrid takes one public key,
+// or an API key ("SG.…") to fetch it with.
+func loadProviders(v string) (map[string]webhookverify.ProviderAdapter, error) {
+	secrets := map[string][]string{}
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, secret, ok := strings.Cut(pair, "=")
+		if !ok || secret == "" {
+			return nil, fmt.Errorf("invalid PROVIDER_SECRETS: %q is not name=secret", name)
+		}
+		secrets[name] = append(secrets[name], secret)
+	}
+
+	adapters := map[string]webhookverify.ProviderAdapter{}
+	for name, list := range secrets {
+		switch name {
+		case "stripe":
+			adapters[name] = &webhookverify.Stripe{Secret: list[0], Secrets: list[1:], Clock: clock}
+		case "github":
+			adapters[name] = &webhookverify.GitHub{Secret: list[0], Secrets: list[1:]}
+		case "slack":
+			adapters[name] = &webhookverify.Slack{Secret: list[0], Secrets: list[1:], Clock: clock}
+		case "shopify":
+			adapters[name] = &webhookverify.Shopify{Secret: list[0], Secrets: list[1:]}
+		case "twilio":
+			adapters[name] = &webhookverify.Twilio{Secret: list[0], Secrets: list[1:], BaseURL: publicURL}
+		case "sendgrid":
+			if len(list) > 1 {
+				return nil, fmt.Errorf("invalid PROVIDER_SECRETS: sendgrid takes one key")
+			}
+			if strings.HasPrefix(list[0], "SG.") {
+				adapters[name] = &webhookverify.SendGrid{APIKey: list[0]}
+			} else {
+				adapters[name] = &webhookverify.SendGrid{PublicKey: list[0]}
+			}
+		default:
+			return nil, fmt.Errorf("invalid PROVIDER_SECRETS: unknown provider %q, want stripe, github, slack, shopify, twilio or sendgrid", name)
+		}
+	}
+	return adapters, nil
+}
+
+// Client certificate names accepted in place of a signature, from
+// TLS_CLIENT_NAMES. Empty accepts any certificate the CA bundle verifies.
+var tlsClientNames []string
+
+// loadTLSConfig reads TLS_CERT_FILE and TLS_KEY_FILE, and TLS_CLIENT_CA_FILE
+// for mTLS. It returns nil when no certificate is configured. Certificates
+// are read once; renewed files are picked up by a reload (SIGHUP).
+func loadTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	tlsClientNames = nil
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("set both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS_CERT_FILE/TLS_KEY_FILE: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS_CLIENT_CA_FILE: %v", err)
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("invalid TLS_CLIENT_CA_FILE: no PEM certificates in %s", caFile)
+	}
+	switch v := os.Getenv("TLS_CLIENT_AUTH"); v {
+	case "", "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		// Senders without a certificate still get in, and must sign.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid TLS_CLIENT_AUTH: %q, want require or optional", v)
+	}
+	for _, name := range strings.Split(os.Getenv("TLS_CLIENT_NAMES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			tlsClientNames = append(tlsClientNames, name)
+		}
+	}
+	return cfg, nil
+}
+
+// clientCertName returns the name of the verified client certificate r was
+// sent with, if it is one TLS_CLIENT_NAMES accepts. Requests through the
+// relay or over plain HTTP never have one.
+func clientCertName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	if len(tlsClientNames) == 0 {
+		return names[0], true
+	}
+	for _, name := range names {
+		for _, allowed := range tlsClientNames {
+			if name != "" && name == allowed {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func serve() int {
+	r := mux.NewRouter()
+	paths := []string{"/webhook"}
+	if len(providers) > 0 {
+		paths = append(paths, "/webhook/{provider}")
+	}
+	for _, path := range paths {
+		var handler http.Handler = http.HandlerFunc(webhookHandler)
+		switch {
+		case readOnly:
+			handler = http.HandlerFunc(readOnlyHandler)
+		case slo != nil:
+			handler = slo.middleware(handler)
+		}
+		// Outside the SLO middleware: a throttled flood is not an outage.
+		if limiter != nil {
+			handler = limiter.middleware(handler)
+		}
+		r.Handle(path, handler).Methods("POST")
+	}
+	r.HandleFunc("/", homeHandler).Methods("GET")
+	if capture != nil {
+		r.HandleFunc("/debug/requests", requireDebugToken(capturedRequestsHandler)).Methods("GET")
+	}
+
+	if debugToken != "" {
+		r.HandleFunc("/debug/dead-letters", requireDebugToken(deadLettersHandler)).Methods("GET")
+		r.HandleFunc("/debug/validate", requireDebugToken(validateHandler)).Methods("POST")
+		r.HandleFunc("/debug/endpoints", requireDebugToken(endpointsHandler)).Methods("GET")
+		if !readOnly {
+			r.HandleFunc("/debug/dead-letters/{id:.+}/retry", requireDebugToken(retryDeadLetterHandler)).Methods("POST")
+			r.HandleFunc("/debug/endpoints/{endpoint}/pause", requireDebugToken(pauseEndpointHandler)).Methods("POST")
+			r.HandleFunc("/debug/endpoints/{endpoint}/resume", requireDebugToken(resumeEndpointHandler)).Methods("POST")
+		}
+	}
+
+	if wsToken != "" {
+		r.HandleFunc("/ws", wsHandler).Methods("GET")
+	}
+
+	if eventLogPath != "" {
+		var err error
+		if eventLog, err = openEventLog(); err != nil {
+			logger.Error("❌ Cannot open EVENT_LOG", "path", eventLogPath, "error", err)
+			return exitUnavailable
+		}
+		// Workers a drain gave up on may still be writing; the log is
+		// then left to close when the process exits.
+		defer func() {
+			if queue == nil || !queue.busy() {
+				eventLog.Close()
+			}
+		}()
+		if debugToken != "" {
+			r.HandleFunc("/debug/events", requireDebugToken(eventLogHandler)).Methods("GET")
+			r.HandleFunc("/debug/reports/traffic", requireDebugTokenOrQuery(trafficReportHandler)).Methods("GET")
+		}
+		if consumerToken != "" {
+			r.HandleFunc("/consumers", requireConsumerToken(consumerGroupsHandler)).Methods("GET")
+			if !readOnly {
+				r.HandleFunc("/consumers/{group}", requireConsumerToken(deleteConsumerHandler)).Methods("DELETE")
+				r.HandleFunc("/consumers/{group}/claim", requireConsumerToken(consumerClaimHandler)).Methods("POST")
+				r.HandleFunc("/consumers/{group}/ack", requireConsumerToken(consumerAckHandler)).Methods("POST")
+				r.HandleFunc("/consumers/{group}/nack", requireConsumerToken(consumerNackHandler)).Methods("POST")
+			}
+		}
+	}
+	if replayCachePath != "" && !readOnly {
+		cache, closeCache, err := openReplayCache()
+		if err != nil {
+			logger.Error("❌ Cannot open REPLAY_CACHE", "path", replayCachePath, "error", err)
+			return exitUnavailable
+		}
+		defer closeCache()
+		useReplayCache(cache)
+	}
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejectRequest(w, r, "method_not_allowed", "Method not allowed", http.StatusMethodNotAllowed)
+	})
+
+	ln, err := listen(listenAddr)
+	if err != nil {
+		logger.Error("❌ Cannot listen", "addr", listenAddr, "error", err)
+		return exitBind
+	}
+
+	// Metrics stay off the public port; bind METRICS_ADDR to an internal
+	// interface, e.g. 127.0.0.1:9090.
+	if metricsAddr != "" {
+		metricsLn, err := net.Listen("tcp", metricsAddr)
+		if err != nil {
+			logger.Error("❌ Cannot listen on METRICS_ADDR", "addr", metricsAddr, "error", err)
+			return exitBind
+		}
+		metrics := http.NewServeMux()
+		metrics.Handle("/", expvar.Handler())
+		if slo != nil {
+			metrics.HandleFunc("/slo", slo.handler)
+		}
+		go http.Serve(metricsLn, metrics)
+	}
+
+	if volume != nil {
+		go volume.run()
+	}
+	if slo != nil {
+		go slo.run()
+	}
+	if tracingEnabled {
+		shutdown, err := startTracing(context.Background())
+		if err != nil {
+			logger.Error("❌ Cannot start tracing", "error", err)
+			return exitConfig
+		}
+		// Runs last, after the drain, so spans of drained events are
+		// exported too.
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Warn("⚠️  Trace export incomplete", "error", err)
+			}
+		}()
+	}
+	// A read-only replica processes nothing: no workers, no jobs.
+	if queue != nil && !readOnly {
+		queue.start(queueWorkers)
+	}
+	if !readOnly {
+		scheduler.start()
+	}
+
+	secretConfigured := webhookSecrets[0] != placeholderSecret
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	logger.Info("🎯 Go Webhook Receiver running", "url", scheme+"://localhost:8080", "pid", os.Getpid(),
+		"secret_configured", secretConfigured)
+	if len(webhookSecrets) > 1 {
+		logger.Info("🔑 Rotation: several secrets active, current one first", "secrets", len(webhookSecrets))
+	}
+	if weakness := weakestSecret(); weakness != "" {
+		logger.Warn("⚠️  WEAK SECRET ALLOWED BY ALLOW_WEAK_SECRET, DO NOT USE IN PRODUCTION", "weakness", weakness)
+	}
+	if queue != nil {
+		logger.Info("⚙️  Async processing", "workers", queueWorkers, "queue_size", cap(queue.jobs),
+			"queue_max_bytes", queue.maxBytes, "spill", eventLog != nil)
+	}
+	if limiter != nil {
+		logger.Info("🚦 Rate limiting webhook requests", "per_ip", os.Getenv("RATE_LIMIT_PER_IP"),
+			"global", os.Getenv("RATE_LIMIT_GLOBAL"), "trusted_proxies", len(trustedProxies))
+	}
+	if readOnly {
+		logger.Warn("📖 Read-only replica: deliveries are refused, events are not processed")
+	}
+	if wsToken != "" {
+		logger.Info("🔌 Relaying events over WebSocket", "path", "/ws", "origins", wsOrigins)
+	}
+	if tracingEnabled {
+		logger.Info("🔭 Exporting traces over OTLP", "endpoint", otlpEndpoint())
+	}
+	if fixedClock != "" {
+		logger.Warn("⚠️  FIXED_CLOCK: time is frozen, DO NOT USE IN PRODUCTION", "at", fixedClock)
+	}
+	if len(verifier.Schemes) > 0 {
+		names := make([]string, len(verifier.Schemes))
+		for i, scheme := range verifier.Schemes {
+			names[i] = scheme.Version()
+		}
+		logger.Info("⚙️  Signature schemes", "schemes", strings.Join(names, ", "))
+	}
+	if len(providers) > 0 {
+		names := make([]string, 0, len(providers))
+		for name := range providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		logger.Info("🔌 Accepting provider webhooks on /webhook/{provider}", "providers", strings.Join(names, ", "))
+	}
+	forwardersMu.RLock()
+	for _, f := range forwarders {
+		logger.Info("📤 Forwarding events", "target", f.target.Name, "url", f.target.URL, "pattern", f.target.Pattern)
+	}
+	forwardersMu.RUnlock()
+	schemasMu.RLock()
+	if len(schemas) > 0 {
+		patterns := make([]string, len(schemas))
+		for i, s := range schemas {
+			patterns[i] = s.pattern
+		}
+		logger.Info("📐 Validating event data", "dir", schemaDir, "types", strings.Join(patterns, ", "),
+			"on_invalid", invalidEventAction)
+	}
+	schemasMu.RUnlock()
+
+	// Headers past the limit that hardenRequests enforces (plus the 4KB
+	// slack net/http adds) are refused by the server itself with a 431.
+	handler := hardenRequests(r)
+	if capture != nil {
+		handler = capture.middleware(handler)
+	}
+	srv := &http.Server{Handler: handler, MaxHeaderBytes: maxHeaderBytes,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn)}
+	serveErr := make(chan error, 2)
+	go func() {
+		// TLS wraps the accepted connections only; ln itself stays a TCP
+		// listener that reload can hand over.
+		publicLn := ln
+		if tlsConfig != nil {
+			publicLn = tls.NewListener(ln, tlsConfig)
+		}
+		if err := srv.Serve(publicLn); err != nil && err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
+
+	if relayAddr != "" {
+		var tlsConfig *tls.Config
+		if relayTLS {
+			host, _, _ := net.SplitHostPort(relayAddr)
+			tlsConfig = &tls.Config{ServerName: host}
+		}
+		relayLn := newRelayListener(relayAddr, relayToken, relayPool, tlsConfig)
+		go func() {
+			if err := srv.Serve(relayLn); err != nil && err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}()
+		logger.Info("🔀 Accepting deliveries through relay", "relay", relayAddr)
+	}
+	signalReady()
+
+	// SIGHUP hands the socket to a freshly started copy of the binary, then
+	// this process stops accepting and lets in-flight deliveries finish.
+	// SIGINT and SIGTERM drain the same way without a successor.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+wait:
+	for {
+		select {
+		case err := <-serveErr:
+			logger.Error("❌ Server error", "error", err)
+			return exitRuntime
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				logger.Info("🛑 Draining in-flight requests", "signal", sig.String(), "pid", os.Getpid())
+				break wait
+			}
+			logger.Info("♻️  Reload requested, starting new process")
+			if err := reload(ln); err != nil {
+				logger.Error("❌ Reload failed, keeping current process", "error", err)
+				continue
+			}
+			logger.Info("⏳ New process is serving, draining in-flight requests", "pid", os.Getpid())
+			break wait
+		}
+	}
+
+	// A second SIGINT or SIGTERM gives up on draining.
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				logger.Warn("⚠️  Second signal, exiting without draining", "signal", sig.String())
+				os.Exit(exitRuntime)
+			}
+		}
+	}()
+
+	// Every stage shares the drain deadline and runs even if an earlier
+	// one ran out of time, so pending work is at least accounted for.
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	code := exitOK
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn("⚠️  Drain incomplete", "error", err)
+		code = exitRuntime
+	}
+	// Shutdown leaves upgraded connections alone.
+	if err := wsHub.close(ctx); err != nil {
+		logger.Warn("⚠️  Drain incomplete", "error", err)
+		code = exitRuntime
+	}
+	if queue != nil && !readOnly {
+		if err := queue.drain(ctx); err != nil {
+			logger.Warn("⚠️  Drain incomplete", "error", err)
+			if eventLog != nil {
+				logger.Warn("⚠️  Unprocessed events stay in EVENT_LOG as received; run reprocess to finish them")
+			}
+			code = exitRuntime
+		}
+		if n := queue.spilled.Load(); n > 0 {
+			logger.Info("💾 Spilled events stay in EVENT_LOG for the next start", "spilled", n)
+		}
+	}
+	if err := scheduler.shutdown(ctx); err != nil {
+		logger.Warn("⚠️  Drain incomplete", "error", err)
+		code = exitRuntime
+	}
+	if err := drainForwards(ctx); err != nil {
+		logger.Warn("⚠️  Drain incomplete", "error", err)
+		code = exitRuntime
+	}
+	if !readOnly {
+		flushDigests(ctx)
+	}
+	if code == exitOK {
+		logger.Info("👋 Exited cleanly", "pid", os.Getpid())
+	}
+	return code
+}
+
+// diagnose checks the configuration, the listening addresses and outbound
+// dependencies without serving, and exits with the code of the first kind
+// of problem found: config, then bind, then unavailable.
+func diagnose() int {
+	fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("🩺 Go Webhook Receiver diagnostics")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	if err := configure(); err != nil {
+		fmt.Printf("❌ Configuration: %v\n", err)
+		return exitConfig
+	}
+	fmt.Println("✅ Configuration parsed")
+
+	failures := map[int]bool{}
+	check := func(name string, code int, err error) {
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", name, err)
+			failures[code] = true
+			return
+		}
+		fmt.Printf("✅ %s\n", name)
+	}
+
+	var secretErr error
+	if weakness := weakestSecret(); weakness != "" {
+		secretErr = fmt.Errorf("%s", weakness)
+	}
+	check("Webhook secret", exitConfig, secretErr)
+
+	canBind := func(addr string) error {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return ln.Close()
+	}
+	check("Listen address "+listenAddr, exitBind, canBind(listenAddr))
+	if metricsAddr != "" {
+		check("METRICS_ADDR "+metricsAddr, exitBind, canBind(metricsAddr))
+	}
+
+	if eventLogPath != "" {
+		openLog := func() error {
+			l, err := openEventLog()
+			if err != nil {
+				return err
+			}
+			return l.Close()
+		}
+		check("EVENT_LOG "+eventLogPath, exitUnavailable, openLog())
+	}
+	if eventLogBodies != "" {
+		check("EVENT_LOG_BODIES "+eventLogBodies, exitUnavailable, os.MkdirAll(eventLogBodies, 0o700))
+	}
+	if replayCachePath != "" && replayCachePath != "memory" {
+		_, closeCache, err := openReplayCache()
+		if err == nil {
+			err = closeCache()
+		}
+		check("REPLAY_CACHE "+replayCachePath, exitUnavailable, err)
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if relayAddr != "" {
+		var err error
+		var conn net.Conn
+		if relayTLS {
+			host, _, _ := net.SplitHostPort(relayAddr)
+			conn, err = tls.DialWithDialer(dialer, "tcp", relayAddr, &tls.Config{ServerName: host})
+		} else {
+			conn, err = dialer.Dial("tcp", relayAddr)
+		}
+		if err == nil {
+			conn.Close()
+		}
+		check("Relay "+relayAddr, exitUnavailable, err)
+	}
+	for _, sink := range notifySinks() {
+		check(sink.name+" host", exitUnavailable, dialURL(dialer, sink.url))
+	}
+	if tracingEnabled {
+		check("OTLP endpoint "+otlpEndpoint(), exitUnavailable, dialURL(dialer, otlpEndpoint()))
+	}
+
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, code := range []int{exitConfig, exitBind, exitUnavailable} {
+		if failures[code] {
+			fmt.Printf("❌ Problems found (exit %d)\n", code)
+			return code
+		}
+	}
+	fmt.Println("✅ Ready to receive webhooks")
+	return exitOK
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "run-script" {
+		os.Exit(webhookscript.ChildMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diagnose" {
+		os.Exit(diagnose())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		os.Exit(reprocess())
+	}
+
+	if err := configure(); err != nil {
+		logger.Error("❌ Configuration error", "error", err)
+		os.Exit(exitConfig)
+	}
+	if weakness := weakestSecret(); weakness != "" && !allowWeakSecret && !readOnly {
+		logger.Error("❌ Refusing to start", "reason", weakness,
+			"hint", "use the secret Codehooks returned when you registered the webhook, generate one with "+
+				"`go run ./cmd/webhookctl gen-secret`, or set ALLOW_WEAK_SECRET=true for local testing")
+		os.Exit(exitConfig)
+	}
+	registerHandlers(router)
+	registerScripts(router)
+	registerEnrichments()
+	registerForwards()
+	if err := registerJobs(scheduler); err != nil {
+		logger.Error("❌ Cannot schedule jobs", "error", err)
+		os.Exit(exitConfig)
+	}
+	os.Exit(serve())
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookclock"
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhooklog"
+)
+
+func TestCronNext(t *testing.T) {
+	zone := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Skipf("time zone %s not available: %v", name, err)
+		}
+		return loc
+	}
+	utc := time.UTC
+	kolkata := zone("Asia/Kolkata")     // +05:30
+	newYork := zone("America/New_York") // DST from 2024-03-10 02:00 to 03:00
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 1, 10, 0, 30, 0, utc), time.Date(2024, 1, 1, 10, 1, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 15, 0, 0, utc), time.Date(2024, 1, 1, 10, 30, 0, 0, utc)},
+		{"@hourly", time.Date(2024, 1, 1, 10, 0, 0, 0, utc), time.Date(2024, 1, 1, 11, 0, 0, 0, utc)},
+		{"@daily", time.Date(2024, 12, 31, 23, 59, 0, 0, utc), time.Date(2025, 1, 1, 0, 0, 0, 0, utc)},
+		{"@weekly", time.Date(2024, 1, 1, 0, 0, 0, 0, utc), time.Date(2024, 1, 7, 0, 0, 0, 0, utc)},
+		{"@monthly", time.Date(2024, 1, 15, 0, 0, 0, 0, utc), time.Date(2024, 2, 1, 0, 0, 0, 0, utc)},
+		{"@every 90s", time.Date(2024, 1, 1, 10, 0, 30, 0, utc), time.Date(2024, 1, 1, 10, 2, 0, 0, utc)},
+		{"30 9 * * 1-5", time.Date(2024, 1, 5, 10, 0, 0, 0, utc), time.Date(2024, 1, 8, 9, 30, 0, 0, utc)},
+		{"0 0 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, utc), time.Date(2024, 1, 7, 0, 0, 0, 0, utc)},
+		{"0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		// Both day fields restricted: either matches.
+		{"0 0 15 * 1", time.Date(2024, 1, 2, 0, 0, 0, 0, utc), time.Date(2024, 1, 8, 0, 0, 0, 0, utc)},
+		{"0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+		// Hours are wall-clock hours, also in zones off the hour.
+		{"0 9 * * *", time.Date(2024, 1, 1, 8, 0, 0, 0, kolkata), time.Date(2024, 1, 1, 9, 0, 0, 0, kolkata)},
+		{"15 9 * * *", time.Date(2024, 1, 1, 0, 45, 0, 0, kolkata), time.Date(2024, 1, 1, 9, 15, 0, 0, kolkata)},
+		{"0 * * * *", time.Date(2024, 1, 1, 9, 10, 0, 0, kolkata), time.Date(2024, 1, 1, 10, 0, 0, 0, kolkata)},
+		// 02:30 does not exist on the day DST starts.
+		{"30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"0 3 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 10, 3, 0, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec+" from "+tt.from.Format(time.RFC3339), func(t *testing.T) {
+			c, err := parseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.spec, err)
+			}
+			if got := c.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"*/0 * * * *", "5-1 * * * *", "a * * * *", "@every 500ms", "@every soon", "@yearly",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) accepted an invalid schedule", spec)
+		}
+	}
+}
+
+func TestDeliveryID(t *testing.T) {
+	tests := []struct {
+		webhookID, eventID, want string
+	}{
+		{"wh_sub", "evt_1", "wh_sub/evt_1"},
+		{"wh_sub", "", ""}, // the subscription alone does not tell events apart
+		{"", "evt_1", ""},
+		{"evt_1", "evt_1", "evt_1"}, // providers send one ID for both
+	}
+	for _, tt := range tests {
+		if got := deliveryID(tt.webhookID, tt.eventID); got != tt.want {
+			t.Errorf("deliveryID(%q, %q) = %q, want %q", tt.webhookID, tt.eventID, got, tt.want)
+		}
+	}
+}
+
+func TestLoggedJob(t *testing.T) {
+	form := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	tests := []struct {
+		name           string
+		rec            webhooklog.Record
+		wantID         string
+		wantType       string
+		wantDeliveryID string
+		wantField      string // a key of the event's data
+	}{
+		{"codehooks",
+			webhooklog.Record{Endpoint: "codehooks", WebhookID: "wh_sub", DeliveryID: "wh_sub/evt_1",
+				Payload: `{"id":"evt_1","type":"order.created","data":{"order":1}}`},
+			"evt_1", "order.created", "wh_sub/evt_1", "order"},
+		{"logged before the endpoint was",
+			webhooklog.Record{WebhookID: "wh_sub", Payload: `{"id":"evt_1","type":"order.created","data":{"order":1}}`},
+			"evt_1", "order.created", "wh_sub/evt_1", "order"},
+		{"github",
+			webhooklog.Record{Endpoint: "github", DeliveryID: "72d3162e", EventType: "push", EventID: "72d3162e",
+				Payload: `{"ref":"refs/heads/main"}`},
+			"72d3162e", "push", "72d3162e", "ref"},
+		{"stripe keeps the whole payload as data",
+			webhooklog.Record{Endpoint: "stripe", DeliveryID: "evt_1", EventType: "invoice.paid", EventID: "evt_1",
+				Payload: `{"id":"evt_1","type":"invoice.paid","data":{"object":{}}}`},
+			"evt_1", "invoice.paid", "evt_1", "data"},
+		{"twilio form",
+			webhooklog.Record{Endpoint: "twilio", DeliveryID: "tw_1", EventType: "delivered", EventID: "tw_1",
+				Headers: form, Payload: "MessageSid=SM1&MessageStatus=delivered"},
+			"tw_1", "delivered", "tw_1", "MessageStatus"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := loggedJob(tt.rec)
+			if err != nil {
+				t.Fatalf("loggedJob() error = %v", err)
+			}
+			if job.Event.ID != tt.wantID || job.Event.Type != tt.wantType || job.DeliveryID != tt.wantDeliveryID {
+				t.Errorf("loggedJob() id, type, delivery ID = %q, %q, %q, want %q, %q, %q", job.Event.ID, job.Event.Type,
+					job.DeliveryID, tt.wantID, tt.wantType, tt.wantDeliveryID)
+			}
+			if _, ok := job.Event.Data[tt.wantField]; !ok {
+				t.Errorf("loggedJob() data = %v, want a %q field", job.Event.Data, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestResponseCacheFresh(t *testing.T) {
+	fake := webhookclock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer func(c webhookclock.Clock, ro bool) { clock, readOnly = c, ro }(clock, readOnly)
+	clock = fake
+
+	tests := []struct {
+		name     string
+		readOnly bool
+		age      time.Duration
+		written  bool
+		want     bool
+	}{
+		{"unchanged", false, time.Second, false, true},
+		{"written by this process", false, time.Second, true, false},
+		{"expired, written elsewhere", false, responseCacheTTL, false, false},
+		{"replica", true, time.Second, true, true},
+		{"replica expired", true, responseCacheTTL, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readOnly = tt.readOnly
+			c := &responseCache{entries: map[string]cachedResponse{}}
+			c.put("q", cachedResponse{version: eventLogVersion.Load(), stored: fake.Now()})
+			fake.Advance(tt.age)
+			if tt.written {
+				eventLogVersion.Add(1)
+			}
+			if _, got := c.get("q"); got != tt.want {
+				t.Errorf("get() found = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactedURI(t *testing.T) {
+	tests := []struct {
+		uri, want string
+	}{
+		{"/webhook", "/webhook"},
+		{"/webhook?source=stripe", "/webhook?source=stripe"},
+		{"/ws?token=s3cret", "/ws?token=%5Bredacted%5D"},
+		{"/ws?types=order.*&token=s3cret&token=again", "/ws?token=%5Bredacted%5D&types=order.%2A"},
+	}
+	for _, tt := range tests {
+		u, err := url.ParseRequestURI(tt.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactedURI(u); got != tt.want {
+			t.Errorf("redactedURI(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
@@ -7,7 +7,7 @@ Installation:
     pip install flask
 
 Usage:
-    export WEBHOOK_SECRET="whsec_your_secret_here"
+    export WEBHOOK_SECRET="your_secret_here"
     python receiver-python.py
 """
 
@@ -19,7 +19,7 @@ import os
 
 app = Flask(__name__)
 
-WEBHOOK_SECRET = os.environ.get('WEBHOOK_SECRET', 'whsec_your_secret_here')
+WEBHOOK_SECRET = os.environ.get('WEBHOOK_SECRET', 'your_secret_here')
 
 
 def verify_webhook_signature(payload, signature, timestamp):
@@ -122,7 +122,7 @@ if __name__ == '__main__':
     print('🎯 Python Webhook Receiver')
     print('━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━')
     print('✅ Server running on http://localhost:5000')
-    print(f"⚙️  Secret configured: {WEBHOOK_SECRET != 'whsec_your_secret_here'}")
+    print(f"⚙️  Secret configured: {WEBHOOK_SECRET != 'your_secret_here'}")
     print('━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n')
     print('Waiting for webhooks...\n')
 
@@ -0,0 +1,148 @@
+package webhookclock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when told to, for tests and for a
+// receiver replaying fixtures at a fixed time. Timers and tickers fire
+// from Advance and Set, in time order, on the calling goroutine.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration // 0 for After
+	c      chan time.Time
+}
+
+// NewFake returns a Fake set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now implements Clock.
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements Clock.
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).c
+}
+
+// NewTicker implements Clock.
+func (c *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("webhookclock: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{clock: c, w: c.add(d, d)}
+}
+
+// NewTimer implements Clock.
+func (c *Fake) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{clock: c, w: c.add(d, 0)}
+}
+
+func (c *Fake) add(d, period time.Duration) *fakeWaiter {
+	w := &fakeWaiter{period: period, c: make(chan time.Time, 1)}
+	c.schedule(w, d)
+	return w
+}
+
+// schedule sets w to fire d from now, at once when d is not positive.
+func (c *Fake) schedule(w *fakeWaiter, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.at = c.now.Add(d)
+	if d <= 0 {
+		w.c <- c.now
+		return
+	}
+	c.waiters = append(c.waiters, w)
+}
+
+// Advance moves the clock forward by d, firing every timer and tick that
+// falls due on the way.
+func (c *Fake) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, firing every timer and tick due by then. Moving
+// it backwards fires nothing.
+func (c *Fake) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(t) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.c <- w.at:
+		default: // reader is behind; drop the tick like time.Ticker
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = t
+}
+
+// remove unschedules w and reports whether it was pending.
+func (c *Fake) remove(w *fakeWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTicker struct {
+	clock *Fake
+	w     *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.clock.remove(t.w) }
+
+type fakeTimer struct {
+	clock *Fake
+	w     *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.w.c }
+
+func (t *fakeTimer) Stop() bool {
+	pending := t.clock.remove(t.w)
+	t.drain()
+	return pending
+}
+
+func (t *fakeTimer) Reset(d time.Duration) {
+	t.Stop()
+	t.clock.schedule(t.w, d)
+}
+
+// drain drops a time that fired but was not received.
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.w.c:
+	default:
+	}
+}
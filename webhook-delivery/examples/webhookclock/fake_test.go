@@ -0,0 +1,127 @@
+package webhookclock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// received returns the times waiting on c, without blocking.
+func received(c <-chan time.Time) []time.Duration {
+	var got []time.Duration
+	for {
+		select {
+		case t := <-c:
+			got = append(got, t.Sub(start))
+		default:
+			return got
+		}
+	}
+}
+
+func TestFakeTimers(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(c *Fake) <-chan time.Time
+		advance []time.Duration
+		want    []time.Duration // times received, as offsets from start
+	}{
+		{
+			name:    "After fires once due",
+			run:     func(c *Fake) <-chan time.Time { return c.After(time.Minute) },
+			advance: []time.Duration{59 * time.Second, time.Second, time.Hour},
+			want:    []time.Duration{time.Minute},
+		},
+		{
+			name: "After with no delay fires at once",
+			run:  func(c *Fake) <-chan time.Time { return c.After(0) },
+			want: []time.Duration{0},
+		},
+		{
+			name:    "Timer stopped does not fire",
+			run:     func(c *Fake) <-chan time.Time { t := c.NewTimer(time.Minute); t.Stop(); return t.C() },
+			advance: []time.Duration{time.Hour},
+		},
+		{
+			name: "Timer reset fires at the new time",
+			run: func(c *Fake) <-chan time.Time {
+				t := c.NewTimer(time.Minute)
+				t.Reset(2 * time.Minute)
+				return t.C()
+			},
+			advance: []time.Duration{90 * time.Second, time.Minute},
+			want:    []time.Duration{2 * time.Minute},
+		},
+		{
+			name: "Timer reset drops a time not received",
+			run: func(c *Fake) <-chan time.Time {
+				t := c.NewTimer(time.Second)
+				c.Advance(time.Second)
+				t.Reset(time.Minute)
+				return t.C()
+			},
+			advance: []time.Duration{time.Minute},
+			want:    []time.Duration{time.Minute + time.Second},
+		},
+		{
+			name: "Ticker drops ticks for a slow reader",
+			run: func(c *Fake) <-chan time.Time {
+				return c.NewTicker(time.Minute).C()
+			},
+			advance: []time.Duration{5 * time.Minute},
+			want:    []time.Duration{time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFake(start)
+			ch := tt.run(c)
+			var got []time.Duration
+			for _, d := range tt.advance {
+				c.Advance(d)
+				got = append(got, received(ch)...)
+			}
+			got = append(got, received(ch)...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("received %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("received %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeFiresInOrder(t *testing.T) {
+	c := NewFake(start)
+	ticker := c.NewTicker(2 * time.Minute)
+	defer ticker.Stop()
+	timer := c.After(3 * time.Minute)
+
+	var order []string
+	for step := 0; step < 5; step++ {
+		c.Advance(time.Minute)
+		select {
+		case at := <-ticker.C():
+			order = append(order, "tick@"+at.Sub(start).String())
+		case at := <-timer:
+			order = append(order, "timer@"+at.Sub(start).String())
+		default:
+		}
+		if now := c.Now(); now != start.Add(time.Duration(step+1)*time.Minute) {
+			t.Fatalf("Now() = %v after %d advances", now, step+1)
+		}
+	}
+	want := []string{"tick@2m0s", "timer@3m0s", "tick@4m0s"}
+	if len(order) != len(want) {
+		t.Fatalf("fired %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("fired %v, want %v", order, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package webhookclock abstracts the passage of time for the receiver, so
+// signature tolerance, TTLs, retry backoff and periodic jobs can run on a
+// fake clock in tests. See Fake.
+package webhookclock
+
+import "time"
+
+// Clock tells the time and schedules wake-ups.
+type Clock interface {
+	Now() time.Time
+
+	// After sends the time on the returned channel once d has passed.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker sends the time every d until stopped. Like time.Ticker,
+	// it drops ticks for slow readers.
+	NewTicker(d time.Duration) Ticker
+
+	// NewTimer sends the time once d has passed, like After, but can be
+	// stopped and reset, so a loop can wait on one timer.
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker is the part of time.Ticker a Clock provides.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer is the part of time.Timer a Clock provides. After Stop or Reset
+// returns, no time from before the call is received, as with time.Timer
+// since Go 1.23.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration)
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time   { return t.t.C }
+func (t realTimer) Stop() bool            { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) { t.t.Reset(d) }
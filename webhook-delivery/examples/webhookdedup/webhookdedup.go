@@ -0,0 +1,154 @@
+// Package webhookdedup remembers which webhook deliveries were already
+// processed, so retried deliveries of the same event are acknowledged
+// without running the handler again.
+//
+// Deliveries are keyed by an ID that stays the same across retries of
+// one event. Codehooks sends the subscription's ID as X-Webhook-Id with
+// every event, so key on "{X-Webhook-Id}/{X-Event-Id}", not on
+// X-Webhook-Id alone. Check the ID only after the signature is verified.
+// Reserve it before the event is queued or processed, so a retry that
+// arrives meanwhile is not processed as well, and remove it when
+// processing fails, so the sender's next retry is processed again:
+//
+//	if ok, _ := store.Reserve(ctx, id, 24*time.Hour); !ok {
+//		w.WriteHeader(http.StatusOK) // duplicate, already accepted
+//		return
+//	}
+//	if err := process(event); err != nil {
+//		store.Remove(ctx, id)
+//		w.WriteHeader(http.StatusInternalServerError)
+//		return
+//	}
+package webhookdedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Store records accepted delivery IDs. Implementations backed by Redis
+// (EXISTS, SET with NX and EX, and DEL) or BoltDB fit the same interface
+// and let several receiver instances share one record.
+type Store interface {
+	// Contains reports whether id was added and has not expired.
+	Contains(ctx context.Context, id string) (bool, error)
+
+	// Add records id for ttl.
+	Add(ctx context.Context, id string, ttl time.Duration) error
+
+	// Reserve records id for ttl unless it is already recorded, and
+	// reports whether it did. Both must happen atomically, so of two
+	// concurrent copies of one delivery only one is reserved.
+	Reserve(ctx context.Context, id string, ttl time.Duration) (bool, error)
+
+	// Remove forgets id.
+	Remove(ctx context.Context, id string) error
+}
+
+// Memory is an in-process Store that keeps a fixed number of IDs, evicting
+// the least recently added first. Its zero value is not usable; call
+// NewMemory.
+type Memory struct {
+	// Clock decides when entries expire; the wall clock is used when it
+	// is nil. Any webhookclock.Clock fits.
+	Clock interface{ Now() time.Time }
+
+	capacity int
+
+	mu    sync.Mutex
+	order *list.List // front is most recent
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemory returns a Memory store holding up to capacity IDs.
+func NewMemory(capacity int) *Memory {
+	return &Memory{
+		capacity: capacity,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Contains implements Store.
+func (m *Memory) Contains(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[id]
+	if !ok {
+		return false, nil
+	}
+	if m.now().After(el.Value.(*memoryEntry).expires) {
+		m.order.Remove(el)
+		delete(m.items, id)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *Memory) now() time.Time {
+	if m.Clock != nil {
+		return m.Clock.Now()
+	}
+	return time.Now()
+}
+
+// Add implements Store.
+func (m *Memory) Add(_ context.Context, id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(id, ttl)
+	return nil
+}
+
+// Reserve implements Store.
+func (m *Memory) Reserve(_ context.Context, id string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[id]; ok && !m.now().After(el.Value.(*memoryEntry).expires) {
+		return false, nil
+	}
+	m.add(id, ttl)
+	return true, nil
+}
+
+// Remove implements Store.
+func (m *Memory) Remove(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[id]; ok {
+		m.order.Remove(el)
+		delete(m.items, id)
+	}
+	return nil
+}
+
+// add records id for ttl. m.mu must be held.
+func (m *Memory) add(id string, ttl time.Duration) {
+	expires := m.now().Add(ttl)
+	if el, ok := m.items[id]; ok {
+		el.Value.(*memoryEntry).expires = expires
+		m.order.MoveToFront(el)
+		return
+	}
+	m.items[id] = m.order.PushFront(&memoryEntry{id: id, expires: expires})
+	for m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryEntry).id)
+	}
+}
+
+// Len returns the number of IDs held, including expired ones not yet
+// evicted.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
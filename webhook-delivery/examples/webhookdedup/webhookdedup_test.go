@@ -0,0 +1,136 @@
+package webhookdedup
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookclock"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	type op struct {
+		advance time.Duration // before the step
+		add     string
+		ttl     time.Duration
+	}
+	tests := []struct {
+		name     string
+		capacity int
+		ops      []op
+		want     map[string]bool // Contains after the ops
+	}{
+		{
+			name:     "added",
+			capacity: 10,
+			ops:      []op{{add: "wh_1/evt_1", ttl: time.Hour}},
+			want:     map[string]bool{"wh_1/evt_1": true, "wh_1/evt_2": false, "wh_1": false},
+		},
+		{
+			name:     "expired",
+			capacity: 10,
+			ops:      []op{{add: "a", ttl: time.Hour}, {advance: time.Hour + time.Second}},
+			want:     map[string]bool{"a": false},
+		},
+		{
+			name:     "not yet expired",
+			capacity: 10,
+			ops:      []op{{add: "a", ttl: time.Hour}, {advance: time.Hour}},
+			want:     map[string]bool{"a": true},
+		},
+		{
+			name:     "added again extends the TTL",
+			capacity: 10,
+			ops:      []op{{add: "a", ttl: time.Hour}, {advance: 50 * time.Minute, add: "a", ttl: time.Hour}, {advance: 50 * time.Minute}},
+			want:     map[string]bool{"a": true},
+		},
+		{
+			name:     "oldest evicted over capacity",
+			capacity: 2,
+			ops:      []op{{add: "a", ttl: time.Hour}, {add: "b", ttl: time.Hour}, {add: "c", ttl: time.Hour}},
+			want:     map[string]bool{"a": false, "b": true, "c": true},
+		},
+		{
+			name:     "added again is most recent",
+			capacity: 2,
+			ops:      []op{{add: "a", ttl: time.Hour}, {add: "b", ttl: time.Hour}, {add: "a", ttl: time.Hour}, {add: "c", ttl: time.Hour}},
+			want:     map[string]bool{"a": true, "b": false, "c": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := webhookclock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			m := NewMemory(tt.capacity)
+			m.Clock = clock
+			for _, op := range tt.ops {
+				clock.Advance(op.advance)
+				if op.add != "" {
+					if err := m.Add(ctx, op.add, op.ttl); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			for id, want := range tt.want {
+				if got, err := m.Contains(ctx, id); err != nil || got != want {
+					t.Errorf("Contains(%q) = %v, %v, want %v", id, got, err, want)
+				}
+			}
+			if m.Len() > tt.capacity {
+				t.Errorf("Len() = %d, over capacity %d", m.Len(), tt.capacity)
+			}
+		})
+	}
+}
+
+func TestMemoryReserve(t *testing.T) {
+	ctx := context.Background()
+	clock := webhookclock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	m := NewMemory(10)
+	m.Clock = clock
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		remove  bool
+		want    bool // reserved
+	}{
+		{"first copy", 0, false, true},
+		{"retry while the first is queued", time.Minute, false, false},
+		{"retry after processing failed", time.Minute, true, true},
+		{"retry after it succeeded", time.Minute, false, false},
+		{"retry after the TTL", time.Hour, false, true},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		if step.remove {
+			if err := m.Remove(ctx, "wh_1/evt_1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := m.Reserve(ctx, "wh_1/evt_1", time.Hour); err != nil || got != step.want {
+			t.Errorf("%s: Reserve() = %v, %v, want %v", step.name, got, err, step.want)
+		}
+	}
+}
+
+func TestMemoryReserveConcurrent(t *testing.T) {
+	m := NewMemory(10)
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := m.Reserve(context.Background(), "wh_1/evt_1", time.Hour); ok {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := reserved.Load(); n != 1 {
+		t.Errorf("%d concurrent copies reserved, want 1", n)
+	}
+}
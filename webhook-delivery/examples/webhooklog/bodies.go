@@ -0,0 +1,107 @@
+package webhooklog
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BodyStore keeps payload bodies by the hex SHA-256 of their content, so a
+// payload redelivered many times is stored once. Implementations backed by
+// object storage, such as S3 with the hash as key, fit the same interface.
+type BodyStore interface {
+	// Put stores body under hash. Storing a hash that already exists is
+	// not an error and leaves the stored body as it is.
+	Put(ctx context.Context, hash string, body []byte) error
+
+	// Get returns the body stored under hash.
+	Get(ctx context.Context, hash string) ([]byte, error)
+}
+
+// ErrBodyNotFound is returned by Get for an unknown hash.
+var ErrBodyNotFound = errors.New("webhooklog: body not found")
+
+// BodyHash returns the content address of body.
+func BodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// dbBodies stores bodies in the log's own database, the default.
+type dbBodies struct {
+	db *sql.DB
+}
+
+func (s dbBodies) Put(ctx context.Context, hash string, body []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT OR IGNORE INTO bodies (hash, body) VALUES (?, ?)`, hash, body)
+	return err
+}
+
+func (s dbBodies) Get(ctx context.Context, hash string) ([]byte, error) {
+	var body []byte
+	err := s.db.QueryRowContext(ctx, `SELECT body FROM bodies WHERE hash = ?`, hash).Scan(&body)
+	if err == sql.ErrNoRows {
+		return nil, ErrBodyNotFound
+	}
+	return body, err
+}
+
+// DirBodies stores each body as a file named by its hash, under a
+// subdirectory per first two hex digits, in the directory it names.
+type DirBodies string
+
+func (d DirBodies) path(hash string) (string, error) {
+	if len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("webhooklog: invalid body hash %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("webhooklog: invalid body hash %q", hash)
+	}
+	return filepath.Join(string(d), hash[:2], hash), nil
+}
+
+// Put implements BodyStore. Bodies are written to a temporary file and
+// renamed into place, so a crash never leaves a partial body behind.
+func (d DirBodies) Put(_ context.Context, hash string, body []byte) error {
+	path, err := d.path(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".body-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get implements BodyStore.
+func (d DirBodies) Get(_ context.Context, hash string) ([]byte, error) {
+	path, err := d.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBodyNotFound
+	}
+	return body, err
+}
@@ -0,0 +1,284 @@
+package webhooklog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Consumer groups read the log as a stream: every verified delivery
+// except those with StatusIgnored, in ID order. Each group has its own
+// cursor, so several consumers can each read the whole stream at their own
+// pace. A consumer claims records for a lease, and acks them once done;
+// records whose lease runs out without an ack are claimed again. The
+// cursor moves past records once they and every record before them are
+// acked.
+//
+// A delivery the sender retried is in the stream once per attempt that
+// was verified, so consumers should deduplicate on WebhookID.
+
+const consumerSchema = `
+CREATE TABLE IF NOT EXISTS consumer_groups (
+	name   TEXT PRIMARY KEY,
+	cursor INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS consumer_claims (
+	group_name    TEXT NOT NULL,
+	delivery_id   INTEGER NOT NULL,
+	claimed_until INTEGER NOT NULL,
+	attempts      INTEGER NOT NULL,
+	acked         INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (group_name, delivery_id)
+);
+`
+
+// streamWhere selects the records in the stream.
+const streamWhere = `d.verified = 1 AND d.status <> 'ignored'`
+
+// ErrInvalidGroup is returned for a group name that is empty, longer than
+// 64 characters, or has characters other than letters, digits, ".", "_"
+// and "-".
+var ErrInvalidGroup = errors.New("webhooklog: invalid consumer group name")
+
+var groupName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Claim is a record claimed by a consumer group.
+type Claim struct {
+	Record
+
+	// Attempt counts the claims of the record by the group, 1 the first
+	// time. A record claimed again after its lease ran out, or after a
+	// Nack, has a higher attempt.
+	Attempt    int       `json:"attempt"`
+	LeaseUntil time.Time `json:"lease_until"`
+}
+
+// Group describes a consumer group.
+type Group struct {
+	Name string `json:"name"`
+
+	// Cursor is the ID of the last record of the stream that is acked
+	// along with every record before it.
+	Cursor int64 `json:"cursor"`
+
+	// Pending counts records claimed and not acked yet. Lag counts the
+	// records after Cursor that are not acked, Pending included.
+	Pending int `json:"pending"`
+	Lag     int `json:"lag"`
+}
+
+// Claim leases up to max records of the stream to group, oldest first:
+// records the group never claimed, and records whose lease ran out. The
+// group is created at the start of the stream on its first claim.
+func (l *Log) Claim(ctx context.Context, group string, max int, lease time.Duration) ([]Claim, error) {
+	if !groupName.MatchString(group) {
+		return nil, ErrInvalidGroup
+	}
+	if max <= 0 {
+		max = 100
+	}
+	now := time.Now()
+	until := now.Add(lease)
+
+	// Claims read and write in one transaction; the mutex keeps claims of
+	// this process from upgrading their locks against each other.
+	l.claimMu.Lock()
+	defer l.claimMu.Unlock()
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO consumer_groups (name) VALUES (?) ON CONFLICT DO NOTHING`, group); err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT d.id, COALESCE(c.attempts, 0) FROM deliveries d
+		JOIN consumer_groups g ON g.name = ?
+		LEFT JOIN consumer_claims c ON c.group_name = g.name AND c.delivery_id = d.id
+		WHERE d.id > g.cursor AND `+streamWhere+`
+			AND (c.delivery_id IS NULL OR (c.acked = 0 AND c.claimed_until <= ?))
+		ORDER BY d.id LIMIT ?`, group, now.UnixMilli(), max)
+	if err != nil {
+		return nil, err
+	}
+	attempts := map[int64]int{}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		attempts[id] = n + 1
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil, tx.Commit()
+	}
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO consumer_claims (group_name, delivery_id, claimed_until, attempts) VALUES (?, ?, ?, 1)
+			ON CONFLICT (group_name, delivery_id) DO UPDATE SET
+				claimed_until = excluded.claimed_until, attempts = consumer_claims.attempts + 1`,
+			group, id, until.UnixMilli()); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	records, err := l.query(ctx, "id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", int64Args(ids), len(ids))
+	if err != nil {
+		return nil, err
+	}
+	claims := make([]Claim, len(records))
+	for i, rec := range records {
+		claims[i] = Claim{Record: rec, Attempt: attempts[rec.ID], LeaseUntil: until}
+	}
+	return claims, nil
+}
+
+// Ack marks records of group as done and returns the group's cursor. IDs
+// the group has not claimed are skipped.
+func (l *Log) Ack(ctx context.Context, group string, ids ...int64) (int64, error) {
+	if !groupName.MatchString(group) {
+		return 0, ErrInvalidGroup
+	}
+	l.claimMu.Lock()
+	defer l.claimMu.Unlock()
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `UPDATE consumer_claims SET acked = 1 WHERE group_name = ? AND delivery_id = ?`,
+			group, id); err != nil {
+			return 0, err
+		}
+	}
+	// The cursor moves up to the first record that is not acked yet, or
+	// to the last acked one when every record after the cursor is.
+	var cursor int64
+	var firstOpen, lastAcked sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT cursor FROM consumer_groups WHERE name = ?`, group).Scan(&cursor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		SELECT MIN(d.id) FROM deliveries d
+		WHERE d.id > ? AND `+streamWhere+` AND NOT EXISTS (
+			SELECT 1 FROM consumer_claims c WHERE c.group_name = ? AND c.delivery_id = d.id AND c.acked = 1)`,
+		cursor, group).Scan(&firstOpen)
+	if err != nil {
+		return 0, err
+	}
+	if firstOpen.Valid {
+		cursor = firstOpen.Int64 - 1
+	} else {
+		err = tx.QueryRowContext(ctx, `SELECT MAX(delivery_id) FROM consumer_claims WHERE group_name = ? AND acked = 1`,
+			group).Scan(&lastAcked)
+		if err != nil {
+			return 0, err
+		}
+		if lastAcked.Valid && lastAcked.Int64 > cursor {
+			cursor = lastAcked.Int64
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE consumer_groups SET cursor = ? WHERE name = ?`, cursor, group); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM consumer_claims WHERE group_name = ? AND delivery_id <= ?`,
+		group, cursor); err != nil {
+		return 0, err
+	}
+	return cursor, tx.Commit()
+}
+
+// Nack ends the leases of records of group early, so the next Claim
+// returns them again.
+func (l *Log) Nack(ctx context.Context, group string, ids ...int64) error {
+	if !groupName.MatchString(group) {
+		return ErrInvalidGroup
+	}
+	for _, id := range ids {
+		if _, err := l.db.ExecContext(ctx, `
+			UPDATE consumer_claims SET claimed_until = 0 WHERE group_name = ? AND delivery_id = ? AND acked = 0`,
+			group, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Groups lists the consumer groups by name.
+func (l *Log) Groups(ctx context.Context) ([]Group, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT g.name, g.cursor,
+			(SELECT count(*) FROM consumer_claims c WHERE c.group_name = g.name AND c.acked = 0),
+			(SELECT count(*) FROM deliveries d WHERE d.id > g.cursor AND `+streamWhere+` AND NOT EXISTS (
+				SELECT 1 FROM consumer_claims c WHERE c.group_name = g.name AND c.delivery_id = d.id AND c.acked = 1))
+		FROM consumer_groups g ORDER BY g.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := []Group{}
+	for rows.Next() {
+		var g Group
+		if err := rows.Scan(&g.Name, &g.Cursor, &g.Pending, &g.Lag); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// DeleteGroup removes group and its claims. Claiming with the same name
+// afterwards starts again at the beginning of the stream.
+func (l *Log) DeleteGroup(ctx context.Context, group string) error {
+	if !groupName.MatchString(group) {
+		return ErrInvalidGroup
+	}
+	l.claimMu.Lock()
+	defer l.claimMu.Unlock()
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM consumer_claims WHERE group_name = ?`, group); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM consumer_groups WHERE name = ?`, group); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("webhooklog: delete group: %w", err)
+	}
+	return nil
+}
+
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
@@ -0,0 +1,146 @@
+package webhooklog
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// TrafficCell sums up the deliveries of one hour, of one event type or of
+// all of them.
+type TrafficCell struct {
+	Hour time.Time `json:"hour"`
+
+	// EventType is empty in the totals of an hour, and for verified
+	// deliveries whose payload did not parse.
+	EventType string `json:"event_type,omitempty"`
+
+	Deliveries int `json:"deliveries"`
+	Failed     int `json:"failed"`   // StatusFailed
+	Rejected   int `json:"rejected"` // StatusRejected, counted in the totals only
+
+	// FailureRate is Failed and Rejected over Deliveries.
+	FailureRate float64 `json:"failure_rate"`
+
+	// LatencyP95 is the 95th percentile of the time from receipt to the
+	// final status, in milliseconds, over the processed and failed
+	// deliveries; 0 when there are none.
+	LatencyP95 float64 `json:"latency_p95_ms"`
+}
+
+// TrafficReport is the traffic of a range of whole hours, in UTC.
+type TrafficReport struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Hours has the totals of every hour of the range, even empty ones.
+	Hours []TrafficCell `json:"hours"`
+
+	// Cells has each event type in each hour it had deliveries, by hour
+	// then type.
+	Cells []TrafficCell `json:"cells"`
+}
+
+// Traffic reports the deliveries received from the hour of from up to the
+// hour of to, not included.
+func (l *Log) Traffic(ctx context.Context, from, to time.Time) (*TrafficReport, error) {
+	from, to = from.UTC().Truncate(time.Hour), to.UTC().Truncate(time.Hour)
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT received_at, event_type, verified, status, updated_at FROM deliveries
+		WHERE received_at >= ? AND received_at < ?`, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		hour      int64
+		eventType string
+	}
+	type tally struct {
+		cell      TrafficCell
+		latencies []float64
+	}
+	hours := map[int64]*tally{}
+	cells := map[key]*tally{}
+	add := func(t *tally, status string, latency float64) {
+		t.cell.Deliveries++
+		switch status {
+		case StatusFailed:
+			t.cell.Failed++
+		case StatusRejected:
+			t.cell.Rejected++
+		}
+		if latency >= 0 {
+			t.latencies = append(t.latencies, latency)
+		}
+	}
+	for rows.Next() {
+		var receivedAt, updatedAt int64
+		var eventType, status string
+		var verified bool
+		if err := rows.Scan(&receivedAt, &eventType, &verified, &status, &updatedAt); err != nil {
+			return nil, err
+		}
+		hour := receivedAt - receivedAt%time.Hour.Milliseconds()
+		latency := -1.0
+		if status == StatusProcessed || status == StatusFailed {
+			latency = float64(updatedAt - receivedAt)
+		}
+		if hours[hour] == nil {
+			hours[hour] = &tally{}
+		}
+		add(hours[hour], status, latency)
+		if !verified {
+			continue
+		}
+		k := key{hour, eventType}
+		if cells[k] == nil {
+			cells[k] = &tally{cell: TrafficCell{EventType: eventType}}
+		}
+		add(cells[k], status, latency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	finish := func(t *tally, hour int64) TrafficCell {
+		c := t.cell
+		c.Hour = time.UnixMilli(hour).UTC()
+		if c.Deliveries > 0 {
+			c.FailureRate = float64(c.Failed+c.Rejected) / float64(c.Deliveries)
+		}
+		c.LatencyP95 = percentile(t.latencies, 0.95)
+		return c
+	}
+	report := &TrafficReport{From: from, To: to, Hours: []TrafficCell{}, Cells: []TrafficCell{}}
+	for h := from; h.Before(to); h = h.Add(time.Hour) {
+		t := hours[h.UnixMilli()]
+		if t == nil {
+			t = &tally{}
+		}
+		report.Hours = append(report.Hours, finish(t, h.UnixMilli()))
+	}
+	for k, t := range cells {
+		report.Cells = append(report.Cells, finish(t, k.hour))
+	}
+	sort.Slice(report.Cells, func(i, j int) bool {
+		a, b := report.Cells[i], report.Cells[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		return a.EventType < b.EventType
+	})
+	return report, nil
+}
+
+// percentile returns the nearest-rank p-th percentile of values, 0 for
+// none. It sorts values.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	return values[int(math.Ceil(p*float64(len(values))))-1]
+}
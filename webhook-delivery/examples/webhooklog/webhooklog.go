@@ -0,0 +1,317 @@
+// Package webhooklog keeps a persistent log of received webhook deliveries
+// in SQLite: headers, raw payload, verification result and processing
+// status. It lets a receiver audit past deliveries and reprocess events
+// that failed or were interrupted by a restart. Consumer groups read it as
+// a stream, each at its own pace; see Log.Claim. Log.Traffic sums up the
+// deliveries per hour and event type.
+//
+// Payload bodies are stored apart from the delivery records, addressed by
+// their SHA-256, so retries of the same payload share one copy; see
+// BodyStore.
+//
+// The driver is github.com/mattn/go-sqlite3, which needs cgo.
+package webhooklog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Processing statuses.
+const (
+	StatusRejected  = "rejected"  // signature verification failed
+	StatusReceived  = "received"  // verified, not processed yet
+	StatusProcessed = "processed" // handled successfully
+	StatusFailed    = "failed"    // the handler returned an error
+	StatusIgnored   = "ignored"   // verified, but its type is not accepted
+	StatusSpilled   = "spilled"   // accepted while the work queue was full, waiting here
+)
+
+// Record is one logged delivery.
+type Record struct {
+	ID          int64       `json:"id"`
+	WebhookID   string      `json:"webhook_id"`
+	EventID     string      `json:"event_id"`
+	EventType   string      `json:"event_type"`
+	Endpoint    string      `json:"endpoint,omitempty"`    // the adapter that accepted it, such as "codehooks" or "stripe"
+	DeliveryID  string      `json:"delivery_id,omitempty"` // the ID retries share, as the adapter described it
+	ReceivedAt  time.Time   `json:"received_at"`
+	Headers     http.Header `json:"headers"`
+	Payload     string      `json:"payload"` // raw body as received
+	BodyHash    string      `json:"body_hash"`
+	Verified    bool        `json:"verified"`
+	VerifyError string      `json:"verify_error,omitempty"`
+	Status      string      `json:"status"`
+	Error       string      `json:"error,omitempty"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Filter selects records for Query. Zero fields match everything.
+type Filter struct {
+	EventType string
+	Statuses  []string
+	Since     time.Time
+	AfterID   int64 // for paging: only records with a larger ID
+	Limit     int   // default 100
+}
+
+// Log is a delivery log backed by a SQLite database file.
+type Log struct {
+	// Bodies stores payload bodies. They are kept in the database file
+	// when it is nil; bodies stored there earlier stay readable after
+	// Bodies is set.
+	Bodies BodyStore
+
+	db      *sql.DB
+	claimMu sync.Mutex // serializes consumer group claims; see Claim
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS deliveries (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id   TEXT NOT NULL DEFAULT '',
+	event_id     TEXT NOT NULL DEFAULT '',
+	event_type   TEXT NOT NULL DEFAULT '',
+	received_at  INTEGER NOT NULL,
+	headers      TEXT NOT NULL,
+	payload      BLOB NOT NULL,
+	verified     INTEGER NOT NULL,
+	verify_error TEXT NOT NULL DEFAULT '',
+	status       TEXT NOT NULL,
+	error        TEXT NOT NULL DEFAULT '',
+	updated_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deliveries_status ON deliveries (status, id);
+CREATE INDEX IF NOT EXISTS deliveries_type ON deliveries (event_type, id);
+CREATE INDEX IF NOT EXISTS deliveries_received ON deliveries (received_at);
+CREATE TABLE IF NOT EXISTS bodies (
+	hash TEXT PRIMARY KEY,
+	body BLOB NOT NULL
+);
+`
+
+// Open opens or creates the log at path.
+func Open(path string) (*Log, error) {
+	// WAL lets queries run while deliveries are being written; the busy
+	// timeout covers writers from the handler and workers at once.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema + consumerSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("webhooklog: create schema: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("webhooklog: migrate schema: %w", err)
+	}
+	return &Log{db: db}, nil
+}
+
+// OpenReadOnly opens the log at path for queries only, such as a replica
+// kept up to date by Litestream or LiteFS. Append and SetStatus fail on it.
+// The file must have been opened read-write by this version at least once.
+func OpenReadOnly(path string) (*Log, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]interface{}, len(addedColumns))
+	for i, c := range addedColumns {
+		names[i] = c.name
+	}
+	var n int
+	err = db.QueryRow(`SELECT count(*) FROM pragma_table_info('deliveries') WHERE name IN (?`+
+		strings.Repeat(", ?", len(names)-1)+`)`, names...).Scan(&n)
+	if err == nil && n < len(addedColumns) {
+		err = errors.New("schema is out of date, open the log read-write once to migrate it")
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("webhooklog: %w", err)
+	}
+	return &Log{db: db}, nil
+}
+
+// addedColumns are the deliveries columns added after the first version,
+// oldest first. Records logged before body_hash keep the payload inline;
+// those logged before endpoint and delivery_id have them empty.
+var addedColumns = []struct{ name, definition string }{
+	{"body_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoint", "TEXT NOT NULL DEFAULT ''"},
+	{"delivery_id", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrate adds the columns of addedColumns that the log does not have yet.
+func migrate(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('deliveries')`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	have := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		have[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, c := range addedColumns {
+		if have[c.name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE deliveries ADD COLUMN ` + c.name + ` ` + c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) bodies() BodyStore {
+	if l.Bodies != nil {
+		return l.Bodies
+	}
+	return dbBodies{l.db}
+}
+
+// Close closes the database.
+func (l *Log) Close() error {
+	return l.db.Close()
+}
+
+// Append stores rec and returns its ID. ReceivedAt and UpdatedAt default
+// to now. The payload goes to the body store, unless a body with the same
+// hash is there already.
+func (l *Log) Append(ctx context.Context, rec Record) (int64, error) {
+	if rec.ReceivedAt.IsZero() {
+		rec.ReceivedAt = time.Now()
+	}
+	if rec.UpdatedAt.IsZero() {
+		rec.UpdatedAt = rec.ReceivedAt
+	}
+	rec.BodyHash = BodyHash([]byte(rec.Payload))
+	if err := l.bodies().Put(ctx, rec.BodyHash, []byte(rec.Payload)); err != nil {
+		return 0, fmt.Errorf("webhooklog: store body: %w", err)
+	}
+	headers, _ := json.Marshal(rec.Headers)
+	res, err := l.db.ExecContext(ctx, `
+		INSERT INTO deliveries (webhook_id, event_id, event_type, endpoint, delivery_id, received_at, headers,
+			payload, body_hash, verified, verify_error, status, error, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?, ?, ?, ?)`,
+		rec.WebhookID, rec.EventID, rec.EventType, rec.Endpoint, rec.DeliveryID, rec.ReceivedAt.UnixMilli(),
+		string(headers), rec.BodyHash,
+		rec.Verified, rec.VerifyError, rec.Status, rec.Error, rec.UpdatedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// SetStatus updates the processing status of record id. errMsg is stored
+// for StatusFailed and cleared otherwise.
+func (l *Log) SetStatus(ctx context.Context, id int64, status string, errMsg string) error {
+	_, err := l.db.ExecContext(ctx, `UPDATE deliveries SET status = ?, error = ?, updated_at = ? WHERE id = ?`,
+		status, errMsg, time.Now().UnixMilli(), id)
+	return err
+}
+
+// Query returns matching records, oldest first, with their payloads.
+func (l *Log) Query(ctx context.Context, f Filter) ([]Record, error) {
+	var where []string
+	var args []interface{}
+	if f.EventType != "" {
+		where = append(where, "event_type = ?")
+		args = append(args, f.EventType)
+	}
+	if len(f.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, s := range f.Statuses {
+			args = append(args, s)
+		}
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "received_at >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if f.AfterID > 0 {
+		where = append(where, "id > ?")
+		args = append(args, f.AfterID)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	return l.query(ctx, strings.Join(where, " AND "), args, limit)
+}
+
+// query returns up to limit records matching where, oldest first, with
+// their payloads.
+func (l *Log) query(ctx context.Context, where string, args []interface{}, limit int) ([]Record, error) {
+	q := `SELECT id, webhook_id, event_id, event_type, endpoint, delivery_id, received_at, headers, payload,
+		body_hash, verified, verify_error, status, error, updated_at FROM deliveries`
+	if where != "" {
+		q += " WHERE " + where
+	}
+	q += " ORDER BY id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := l.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		var receivedAt, updatedAt int64
+		var headers string
+		var payload []byte
+		if err := rows.Scan(&rec.ID, &rec.WebhookID, &rec.EventID, &rec.EventType, &rec.Endpoint, &rec.DeliveryID,
+			&receivedAt, &headers, &payload, &rec.BodyHash, &rec.Verified, &rec.VerifyError, &rec.Status, &rec.Error, &updatedAt); err != nil {
+			return nil, err
+		}
+		rec.Payload = string(payload)
+		rec.ReceivedAt = time.UnixMilli(receivedAt)
+		rec.UpdatedAt = time.UnixMilli(updatedAt)
+		json.Unmarshal([]byte(headers), &rec.Headers)
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Bodies are read once the rows are closed, so the database store does
+	// not need a second connection.
+	for i := range records {
+		if records[i].BodyHash == "" {
+			continue
+		}
+		body, err := l.bodies().Get(ctx, records[i].BodyHash)
+		if errors.Is(err, ErrBodyNotFound) && l.Bodies != nil {
+			// Stored in the database before Bodies was set.
+			body, err = dbBodies{l.db}.Get(ctx, records[i].BodyHash)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("webhooklog: body of record %d: %w", records[i].ID, err)
+		}
+		records[i].Payload = string(body)
+	}
+	return records, nil
+}
@@ -0,0 +1,210 @@
+package webhooklog
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var hour = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func openTest(t *testing.T) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func appendAll(t *testing.T, l *Log, records []Record) {
+	t.Helper()
+	for _, rec := range records {
+		if _, err := l.Append(context.Background(), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	l := openTest(t)
+	appendAll(t, l, []Record{
+		{EventID: "evt_1", EventType: "order.created", Status: StatusProcessed, Payload: `{"id":"evt_1"}`, ReceivedAt: hour},
+		{EventID: "evt_2", EventType: "order.created", Status: StatusFailed, Payload: `{"id":"evt_2"}`, ReceivedAt: hour.Add(time.Minute)},
+		{EventID: "evt_3", EventType: "user.created", Status: StatusReceived, Payload: `{"id":"evt_3"}`, ReceivedAt: hour.Add(2 * time.Minute)},
+		{Status: StatusRejected, Payload: `forged`, ReceivedAt: hour.Add(3 * time.Minute)},
+	})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string // event IDs, or payloads for records without one
+	}{
+		{"everything", Filter{}, []string{"evt_1", "evt_2", "evt_3", "forged"}},
+		{"event type", Filter{EventType: "order.created"}, []string{"evt_1", "evt_2"}},
+		{"statuses", Filter{Statuses: []string{StatusFailed, StatusReceived}}, []string{"evt_2", "evt_3"}},
+		{"since", Filter{Since: hour.Add(2 * time.Minute)}, []string{"evt_3", "forged"}},
+		{"after ID", Filter{AfterID: 2}, []string{"evt_3", "forged"}},
+		{"limit", Filter{Limit: 1}, []string{"evt_1"}},
+		{"combined", Filter{EventType: "order.created", Statuses: []string{StatusProcessed}}, []string{"evt_1"}},
+		{"none", Filter{EventType: "refund.created"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := l.Query(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rec := range records {
+				if rec.EventID != "" {
+					got = append(got, rec.EventID)
+				} else {
+					got = append(got, rec.Payload)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Query() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTraffic(t *testing.T) {
+	l := openTest(t)
+	at := func(offset, latency time.Duration) (time.Time, time.Time) {
+		return hour.Add(offset), hour.Add(offset + latency)
+	}
+	var records []Record
+	add := func(eventType, status string, verified bool, offset, latency time.Duration) {
+		received, updated := at(offset, latency)
+		records = append(records, Record{EventType: eventType, Status: status, Verified: verified,
+			ReceivedAt: received, UpdatedAt: updated})
+	}
+	add("order.created", StatusProcessed, true, 0, 10*time.Millisecond)
+	add("order.created", StatusProcessed, true, time.Minute, 20*time.Millisecond)
+	add("order.created", StatusFailed, true, 2*time.Minute, 40*time.Millisecond)
+	add("", StatusRejected, false, 3*time.Minute, 0)
+	add("user.created", StatusProcessed, true, time.Hour+time.Minute, 5*time.Millisecond)
+	appendAll(t, l, records)
+
+	report, err := l.Traffic(context.Background(), hour, hour.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hours := []struct {
+		deliveries, failed, rejected int
+		failureRate, p95             float64
+	}{
+		{4, 1, 1, 0.5, 40},
+		{1, 0, 0, 0, 5},
+		{0, 0, 0, 0, 0},
+	}
+	if len(report.Hours) != len(hours) {
+		t.Fatalf("%d hours, want %d", len(report.Hours), len(hours))
+	}
+	for i, want := range hours {
+		got := report.Hours[i]
+		if !got.Hour.Equal(hour.Add(time.Duration(i)*time.Hour)) || got.Deliveries != want.deliveries ||
+			got.Failed != want.failed || got.Rejected != want.rejected ||
+			got.FailureRate != want.failureRate || got.LatencyP95 != want.p95 {
+			t.Errorf("hour %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	cells := []struct {
+		offset     time.Duration
+		eventType  string
+		deliveries int
+	}{
+		{0, "order.created", 3},
+		{time.Hour, "user.created", 1},
+	}
+	if len(report.Cells) != len(cells) {
+		t.Fatalf("cells = %+v, want %d", report.Cells, len(cells))
+	}
+	for i, want := range cells {
+		got := report.Cells[i]
+		if !got.Hour.Equal(hour.Add(want.offset)) || got.EventType != want.eventType || got.Deliveries != want.deliveries {
+			t.Errorf("cell %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestBodiesShared(t *testing.T) {
+	l := openTest(t)
+	payload := `{"id":"evt_1","type":"order.created"}`
+	appendAll(t, l, []Record{{EventID: "evt_1", Payload: payload}, {EventID: "evt_1", Payload: payload}})
+
+	records, err := l.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Payload != payload || records[1].Payload != payload ||
+		records[0].BodyHash != BodyHash([]byte(payload)) || records[0].BodyHash != records[1].BodyHash {
+		t.Errorf("records = %+v, want two sharing the body %q", records, payload)
+	}
+	var bodies int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM bodies`).Scan(&bodies); err != nil {
+		t.Fatal(err)
+	}
+	if bodies != 1 {
+		t.Errorf("%d bodies stored, want 1", bodies)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	// The deliveries table as the first version created it.
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, webhook_id TEXT NOT NULL DEFAULT '', event_id TEXT NOT NULL DEFAULT '',
+		event_type TEXT NOT NULL DEFAULT '', received_at INTEGER NOT NULL, headers TEXT NOT NULL, payload BLOB NOT NULL,
+		verified INTEGER NOT NULL, verify_error TEXT NOT NULL DEFAULT '', status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '', updated_at INTEGER NOT NULL);
+		INSERT INTO deliveries (event_id, received_at, headers, payload, verified, status, updated_at)
+		VALUES ('evt_old', 0, '{}', '{"id":"evt_old"}', 1, 'failed', 0);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Error("OpenReadOnly() opened a log that was never migrated")
+	}
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendAll(t, l, []Record{{EventID: "evt_new", Endpoint: "github", DeliveryID: "d1", Payload: `{}`}})
+	records, err := l.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records = %+v, want 2", records)
+	}
+	if got := records[0]; got.Payload != `{"id":"evt_old"}` || got.Endpoint != "" || got.DeliveryID != "" {
+		t.Errorf("old record = %+v, want its inline payload and no endpoint", got)
+	}
+	if got := records[1]; got.Endpoint != "github" || got.DeliveryID != "d1" {
+		t.Errorf("new record endpoint, delivery ID = %q, %q, want github, d1", got.Endpoint, got.DeliveryID)
+	}
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() after migrating: %v", err)
+	}
+	ro.Close()
+}
@@ -0,0 +1,102 @@
+// Package webhookreplay keeps the deliveries a receiver accepted in
+// SQLite, so replay protection survives restarts. A Cache fits
+// webhookverify.ReplayCache:
+//
+//	cache, err := webhookreplay.Open("replays.db")
+//	...
+//	verifier.Replays = cache
+//
+// Several processes may share the file, such as the old and new process
+// during a reload.
+//
+// The driver is github.com/mattn/go-sqlite3, which needs cgo.
+package webhookreplay
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Cache is a replay cache backed by a SQLite database file.
+type Cache struct {
+	// Clock decides when entries expire; the wall clock is used when it
+	// is nil. Any webhookclock.Clock fits.
+	Clock interface{ Now() time.Time }
+
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS replays (
+	key     TEXT PRIMARY KEY,
+	expires INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS replays_expires ON replays (expires);
+`
+
+// Open opens or creates the cache at path.
+func Open(path string) (*Cache, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("webhookreplay: create schema: %w", err)
+	}
+	return &Cache{db: db}, nil
+}
+
+// Close closes the database.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Seen implements webhookverify.ReplayCache. A key whose entry expired is
+// recorded again, as if it were new. Expired entries are deleted about once
+// a minute.
+func (c *Cache) Seen(ctx context.Context, key string, expires time.Time) (bool, error) {
+	now := time.Now()
+	if c.Clock != nil {
+		now = c.Clock.Now()
+	}
+	if err := c.sweep(ctx, now); err != nil {
+		return false, err
+	}
+	// The insert only takes effect for a new or expired key, so a single
+	// statement both checks and records it.
+	res, err := c.db.ExecContext(ctx, `
+		INSERT INTO replays (key, expires) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET expires = excluded.expires WHERE replays.expires <= ?`,
+		key, expires.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+func (c *Cache) sweep(ctx context.Context, now time.Time) error {
+	c.mu.Lock()
+	due := now.Sub(c.lastSweep) > time.Minute
+	if due {
+		c.lastSweep = now
+	}
+	c.mu.Unlock()
+	if !due {
+		return nil
+	}
+	_, err := c.db.ExecContext(ctx, `DELETE FROM replays WHERE expires <= ?`, now.UnixMilli())
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package webhookscript
+
+import "time"
+
+var started = time.Now()
+
+// cpuTime stands in for the CPU time where getrusage is not available:
+// the time since the process started, which is never less.
+func cpuTime() time.Duration {
+	return time.Since(started)
+}
@@ -0,0 +1,290 @@
+// Package webhookscript runs event handlers written in JavaScript, such as
+// handlers supplied by users of a shared receiver, in a child process with
+// CPU-time and memory budgets:
+//
+//	sb := &webhookscript.Sandbox{Command: []string{exe, "run-script"}, Limits: limits}
+//	err := sb.Handle(ctx, "scripts/order.created.js", eventJSON)
+//
+// The child runs the script's handle(event) function and is stopped when
+// it goes over budget; if it does not stop, or crashes, it is killed. A
+// script that loops forever or allocates without bound takes down its own
+// process, never the receiver. Scripts have no access to the network or
+// the filesystem; console.log goes to the receiver's log.
+//
+// The child side is ChildMain, which the receiver runs for the command
+// the Sandbox starts.
+//
+// The CPU budget is measured with getrusage, which only unix systems
+// have. Elsewhere, such as on Windows, the child measures wall-clock time
+// since it started instead, which is never less than its CPU time: a
+// script is stopped sooner there, counting time spent waiting and the
+// child's own startup.
+package webhookscript
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Errors for a script stopped for going over a budget. All of them match
+// ErrLimit with errors.Is.
+var (
+	ErrLimit       = errors.New("webhookscript: over budget")
+	ErrCPULimit    = fmt.Errorf("%w: CPU time", ErrLimit)
+	ErrMemoryLimit = fmt.Errorf("%w: memory", ErrLimit)
+	ErrKilled      = fmt.Errorf("%w: killed", ErrLimit)
+)
+
+// Limits are the budgets of one handler run.
+type Limits struct {
+	CPU    time.Duration // CPU time of the child process, default 1s; wall-clock time off unix
+	Memory int64         // heap bytes, default 64MB
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.CPU <= 0 {
+		l.CPU = time.Second
+	}
+	if l.Memory <= 0 {
+		l.Memory = 64 << 20
+	}
+	return l
+}
+
+// Compile checks that script parses. It runs none of it, so it is safe
+// to call in the receiver; whether handle is defined shows on the first
+// run.
+func Compile(name string, script []byte) error {
+	_, err := goja.Compile(name, string(script), false)
+	return err
+}
+
+func load(vm *goja.Runtime, name string, script []byte) (goja.Callable, error) {
+	program, err := goja.Compile(name, string(script), false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunProgram(program); err != nil {
+		return nil, err
+	}
+	handle, ok := goja.AssertFunction(vm.Get("handle"))
+	if !ok {
+		return nil, fmt.Errorf("%s does not define a handle(event) function", name)
+	}
+	return handle, nil
+}
+
+// Run runs handle(event) of script in this process, stopping it when the
+// process goes over limits. It is meant for the child process: the
+// budgets are measured for the whole process. console.log writes lines to
+// console.
+func Run(name string, script []byte, event []byte, limits Limits, console io.Writer) error {
+	limits = limits.withDefaults()
+	var data interface{}
+	if err := json.Unmarshal(event, &data); err != nil {
+		return fmt.Errorf("invalid event: %v", err)
+	}
+
+	// The soft limit makes the collector work harder near the budget, so
+	// garbage is not mistaken for a breach.
+	debug.SetMemoryLimit(limits.Memory)
+	vm := goja.New()
+	vm.SetMaxCallStackSize(10000)
+	logger := vm.NewObject()
+	logger.Set("log", func(call goja.FunctionCall) goja.Value {
+		args := make([]string, len(call.Arguments))
+		for i, a := range call.Arguments {
+			args[i] = a.String()
+		}
+		fmt.Fprintln(console, strings.Join(args, " "))
+		return goja.Undefined()
+	})
+	vm.Set("console", logger)
+
+	stop := watch(vm, limits)
+	defer stop()
+	handle, err := load(vm, name, script)
+	if err != nil {
+		return scriptError(err)
+	}
+	result, err := handle(goja.Undefined(), vm.ToValue(data))
+	if err != nil {
+		return scriptError(err)
+	}
+	if p, ok := result.Export().(*goja.Promise); ok {
+		switch p.State() {
+		case goja.PromiseStateRejected:
+			return fmt.Errorf("handle rejected: %v", p.Result())
+		case goja.PromiseStatePending:
+			return errors.New("handle returned a promise that never settled")
+		}
+	}
+	return nil
+}
+
+// watch interrupts vm once the process goes over limits, until stop is
+// called.
+func watch(vm *goja.Runtime, limits Limits) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	start := cpuTime()
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	go func() {
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+			if cpuTime()-start > limits.CPU {
+				vm.Interrupt(ErrCPULimit)
+				return
+			}
+			metrics.Read(sample)
+			if int64(sample[0].Value.Uint64()) > limits.Memory {
+				vm.Interrupt(ErrMemoryLimit)
+				return
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// scriptError returns the error a script run ended with, as a budget error
+// when the watchdog stopped it.
+func scriptError(err error) error {
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		if limit, ok := interrupted.Value().(error); ok {
+			return limit
+		}
+	}
+	var exception *goja.Exception
+	if errors.As(err, &exception) {
+		return errors.New(exception.Value().String())
+	}
+	return err
+}
+
+// result is what the child reports on stdout.
+type result struct {
+	Error string `json:"error,omitempty"`
+	Limit string `json:"limit,omitempty"` // "cpu" or "memory"
+}
+
+// ChildMain is the child process: args are the flags a Sandbox passes
+// and the script path, and the event is read from stdin. It reports the
+// outcome on stdout, and console output on stderr.
+func ChildMain(args []string) int {
+	fs := flag.NewFlagSet("run-script", flag.ContinueOnError)
+	cpu := fs.Duration("cpu", 0, "CPU time budget")
+	memory := fs.Int64("memory", 0, "heap budget in bytes")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: run-script [-cpu d] [-memory bytes] script.js < event.json")
+		return 2
+	}
+	path := fs.Arg(0)
+	script, err := os.ReadFile(path)
+	if err != nil {
+		json.NewEncoder(os.Stdout).Encode(result{Error: err.Error()})
+		return 1
+	}
+	event, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		json.NewEncoder(os.Stdout).Encode(result{Error: err.Error()})
+		return 1
+	}
+	err = Run(path, script, event, Limits{CPU: *cpu, Memory: *memory}, os.Stderr)
+	var res result
+	switch {
+	case err == nil:
+		json.NewEncoder(os.Stdout).Encode(res)
+		return 0
+	case errors.Is(err, ErrCPULimit):
+		res.Limit = "cpu"
+	case errors.Is(err, ErrMemoryLimit):
+		res.Limit = "memory"
+	}
+	res.Error = err.Error()
+	json.NewEncoder(os.Stdout).Encode(res)
+	return 1
+}
+
+// Sandbox starts a child process per handler run.
+type Sandbox struct {
+	// Command starts the child: a program followed by arguments, such as
+	// the receiver's own executable and the subcommand that calls
+	// ChildMain. The budget flags and the script path are appended.
+	Command []string
+	Limits  Limits
+
+	// Console receives the lines the script logs, with the script path;
+	// they are dropped when it is nil.
+	Console func(script, line string)
+}
+
+// Handle runs handle(event) of the script at path in a child process.
+// Over-budget runs return an error matching ErrLimit. A child that is
+// still running at twice its CPU budget plus a second, such as one stuck
+// outside the script, is killed and reported as ErrKilled.
+func (s *Sandbox) Handle(ctx context.Context, path string, event []byte) error {
+	limits := s.Limits.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, 2*limits.CPU+time.Second)
+	defer cancel()
+
+	args := append(append([]string{}, s.Command[1:]...),
+		"-cpu", limits.CPU.String(), "-memory", strconv.FormatInt(limits.Memory, 10), path)
+	cmd := exec.CommandContext(ctx, s.Command[0], args...)
+	cmd.Stdin = bytes.NewReader(event)
+	cmd.Env = []string{} // the receiver's environment holds its secrets
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+	if s.Console != nil {
+		for _, line := range strings.Split(strings.TrimRight(stderr.String(), "\n"), "\n") {
+			if line != "" {
+				s.Console(path, line)
+			}
+		}
+	}
+
+	if cmd.ProcessState == nil {
+		return fmt.Errorf("webhookscript: starting the sandbox: %w", runErr)
+	}
+	var res result
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%w after %s", ErrKilled, 2*limits.CPU+time.Second)
+		}
+		// No report: the child crashed, for example out of memory
+		// between two samples of the watchdog.
+		return fmt.Errorf("%w: child exited without a result: %v", ErrKilled, runErr)
+	}
+	switch res.Limit {
+	case "cpu":
+		return fmt.Errorf("%w (%s)", ErrCPULimit, limits.CPU)
+	case "memory":
+		return fmt.Errorf("%w (%d bytes)", ErrMemoryLimit, limits.Memory)
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
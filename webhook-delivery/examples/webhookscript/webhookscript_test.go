@@ -0,0 +1,166 @@
+package webhookscript
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs the test binary as the sandbox child when a Sandbox starts
+// it with "run-script".
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "run-script" {
+		os.Exit(ChildMain(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+const event = `{"id":"evt_1","type":"order.created","data":{"amount":42}}`
+
+func TestCompile(t *testing.T) {
+	if err := Compile("ok.js", []byte("function handle(event) {}")); err != nil {
+		t.Errorf("Compile() error = %v", err)
+	}
+	if err := Compile("broken.js", []byte("function handle(event) {")); err == nil {
+		t.Error("Compile() accepted a script that does not parse")
+	}
+	// Compile runs nothing, so a script without handle, or one that throws
+	// at the top level, still compiles.
+	if err := Compile("throws.js", []byte(`throw new Error("at load")`)); err != nil {
+		t.Errorf("Compile() error = %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	defer debug.SetMemoryLimit(math.MaxInt64)
+	tests := []struct {
+		name        string
+		script      string
+		event       string
+		limits      Limits
+		wantErr     string // a substring of the error
+		wantLimit   error
+		wantConsole string
+	}{
+		{name: "handled", script: `function handle(event) { if (event.data.amount !== 42) throw new Error("bad event") }`},
+		{name: "console", script: `function handle(event) { console.log("got", event.type, event.data.amount) }`,
+			wantConsole: "got order.created 42\n"},
+		{name: "async", script: `async function handle(event) { await null }`},
+		{name: "throws", script: `function handle(event) { throw new Error("no such order") }`, wantErr: "no such order"},
+		{name: "rejects", script: `async function handle(event) { throw new Error("no such order") }`,
+			wantErr: "handle rejected"},
+		{name: "never settles", script: `function handle(event) { return new Promise(() => {}) }`,
+			wantErr: "never settled"},
+		{name: "no handle", script: `function other(event) {}`, wantErr: "does not define a handle(event) function"},
+		{name: "syntax error", script: `function handle(event) {`, wantErr: "script.js"},
+		{name: "invalid event", script: `function handle(event) {}`, event: `{"id":`, wantErr: "invalid event"},
+		{name: "endless loop", script: `function handle(event) { for (;;) {} }`,
+			limits: Limits{CPU: 50 * time.Millisecond}, wantLimit: ErrCPULimit},
+		{name: "unbounded memory", script: `function handle(event) { const a = []; for (;;) a.push("x".repeat(1024) + a.length) }`,
+			limits: Limits{CPU: 10 * time.Second, Memory: 16 << 20}, wantLimit: ErrMemoryLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.event
+			if e == "" {
+				e = event
+			}
+			var console bytes.Buffer
+			err := Run("script.js", []byte(tt.script), []byte(e), tt.limits, &console)
+			switch {
+			case tt.wantLimit != nil:
+				if !errors.Is(err, tt.wantLimit) || !errors.Is(err, ErrLimit) {
+					t.Errorf("Run() error = %v, want %v", err, tt.wantLimit)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Run() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if errors.Is(err, ErrLimit) {
+					t.Errorf("Run() error = %v, want no budget error", err)
+				}
+			case err != nil:
+				t.Errorf("Run() error = %v", err)
+			}
+			if got := console.String(); got != tt.wantConsole {
+				t.Errorf("console = %q, want %q", got, tt.wantConsole)
+			}
+		})
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, script string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	self := []string{os.Args[0], "run-script"}
+	tests := []struct {
+		name        string
+		command     []string
+		path        string
+		wantErr     string
+		wantLimit   error
+		wantConsole []string
+	}{
+		{name: "handled", command: self,
+			path:        write("ok.js", `function handle(event) { console.log("amount", event.data.amount) }`),
+			wantConsole: []string{"amount 42"}},
+		{name: "throws", command: self,
+			path:    write("throws.js", `function handle(event) { throw new Error("no such order") }`),
+			wantErr: "no such order"},
+		{name: "no process global", command: self,
+			path: write("env.js", `function handle(event) { if (typeof process !== "undefined") throw new Error("process is defined") }`)},
+		{name: "endless loop", command: self, path: write("loop.js", `function handle(event) { for (;;) {} }`),
+			wantLimit: ErrCPULimit},
+		{name: "missing script", command: self, path: filepath.Join(dir, "missing.js"), wantErr: "missing.js"},
+		{name: "child without a result", command: []string{"false"}, path: write("any.js", ""), wantLimit: ErrKilled},
+		{name: "child not found", command: []string{filepath.Join(dir, "no-such-program")}, path: write("none.js", ""),
+			wantErr: "starting the sandbox"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.command[0] == "false" {
+				if _, err := exec.LookPath("false"); err != nil {
+					t.Skip("no false command")
+				}
+			}
+			var console []string
+			sb := &Sandbox{Command: tt.command, Limits: Limits{CPU: 200 * time.Millisecond},
+				Console: func(script, line string) {
+					if script != tt.path {
+						t.Errorf("console line of %q, want %q", script, tt.path)
+					}
+					console = append(console, line)
+				}}
+			err := sb.Handle(context.Background(), tt.path, []byte(event))
+			switch {
+			case tt.wantLimit != nil:
+				if !errors.Is(err, tt.wantLimit) {
+					t.Errorf("Handle() error = %v, want %v", err, tt.wantLimit)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Handle() error = %v, want one containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("Handle() error = %v", err)
+			}
+			if strings.Join(console, "\n") != strings.Join(tt.wantConsole, "\n") {
+				t.Errorf("console = %q, want %q", console, tt.wantConsole)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+// Package webhooksend delivers webhooks signed the way the Codehooks
+// webhook delivery template signs them. Receivers and tools use it to send
+// test deliveries through the same signing and verification path as real
+// ones.
+//
+//	s := webhooksend.New(os.Getenv("WEBHOOK_SECRET"))
+//	res, err := s.SendTestEvent(ctx, "https://example.com/webhook")
+//	if err == nil && res.OK() {
+//		// the endpoint verified and accepted the delivery
+//	}
+//
+// Deliver retries failed deliveries with backoff, as Codehooks does, and
+// reports every attempt:
+//
+//	d, err := s.Deliver(ctx, url, event)
+//	for i, a := range d.Attempts {
+//		...
+//	}
+package webhooksend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookverify"
+)
+
+// TestEventType is the type of events sent by SendTestEvent. Receivers can
+// recognize it and skip their business logic.
+const TestEventType = "webhook.test"
+
+// TestHeader is set to "true" on test deliveries.
+const TestHeader = "X-Webhook-Test"
+
+// Event is the delivered payload.
+type Event struct {
+	ID      string                 `json:"id"`
+	Type    string                 `json:"type"`
+	Data    map[string]interface{} `json:"data"`
+	Created int64                  `json:"created"`
+}
+
+// Sender signs and delivers events.
+type Sender struct {
+	// Secret signs deliveries.
+	Secret string
+
+	// WebhookID is sent as X-Webhook-Id, the subscription the deliveries
+	// belong to, like the _id of a Codehooks webhook. New picks a random
+	// one; when it is empty, each delivery gets its own.
+	WebhookID string
+
+	// Schemes are the signatures sent, webhookverify.SchemeV1 when empty.
+	Schemes []webhookverify.SignatureScheme
+
+	// Header is sent with each delivery, such as an Authorization header.
+	Header http.Header
+
+	// Client sends the requests; a client with a 10 second timeout is
+	// used when it is nil.
+	Client *http.Client
+
+	// Timeout bounds each attempt of Deliver, 10s when zero.
+	Timeout time.Duration
+
+	// MaxAttempts is how often Deliver tries, 5 when zero. A failed
+	// attempt is retried after Backoff, doubled for every further attempt
+	// up to MaxBackoff, with jitter; 1s and 1m when zero. A Retry-After
+	// header longer than that is honored, up to MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+
+	// BeforeAttempt, when set, runs before each attempt of Deliver, such
+	// as to wait out a circuit breaker. An error ends the delivery.
+	BeforeAttempt func(ctx context.Context) error
+
+	// OnAttempt, when set, is called after each attempt of Deliver, the
+	// first one being 1. Its Wait is already set.
+	OnAttempt func(n int, a *Attempt)
+}
+
+// New returns a Sender for secret.
+func New(secret string) *Sender {
+	return &Sender{Secret: secret, WebhookID: NewID("wh_")}
+}
+
+// Request is one delivery as it goes out. Headers left empty are not
+// sent, which lets tools exercise a receiver's missing-header paths.
+type Request struct {
+	Body      []byte
+	Signature string // X-Webhook-Signature
+	Timestamp string // X-Webhook-Timestamp
+	WebhookID string // X-Webhook-Id
+	EventID   string // X-Event-Id
+	Test      bool   // sets TestHeader
+}
+
+// NewRequest returns a delivery of body, the event eventID, signed at the
+// given time, with the headers Codehooks sends.
+func (s *Sender) NewRequest(body []byte, eventID string, at time.Time) *Request {
+	schemes := s.Schemes
+	if len(schemes) == 0 {
+		schemes = []webhookverify.SignatureScheme{webhookverify.SchemeV1}
+	}
+	webhookID := s.WebhookID
+	if webhookID == "" {
+		webhookID = NewID("wh_")
+	}
+	ts := at.Unix()
+	return &Request{
+		Body:      body,
+		Signature: webhookverify.SignWith(s.Secret, ts, body, schemes...),
+		Timestamp: strconv.FormatInt(ts, 10),
+		WebhookID: webhookID,
+		EventID:   eventID,
+	}
+}
+
+// Response is the endpoint's answer to one delivery.
+type Response struct {
+	WebhookID string
+	EventID   string
+	Status    int
+	Header    http.Header
+	Body      []byte // first 64KB
+	Latency   time.Duration
+}
+
+// OK reports whether the endpoint accepted the delivery with a 2xx.
+func (r *Response) OK() bool {
+	return r.Status >= 200 && r.Status < 300
+}
+
+// SendTestEvent delivers a test event to url. The event is marked by its
+// type, TestEventType, by "test": true in its data and by the TestHeader
+// header.
+func (s *Sender) SendTestEvent(ctx context.Context, url string) (*Response, error) {
+	event := Event{
+		ID:   NewID("evt_test_"),
+		Type: TestEventType,
+		Data: map[string]interface{}{
+			"test":    true,
+			"message": "Test delivery to check the endpoint's configuration. Safe to ignore.",
+		},
+		Created: time.Now().Unix(),
+	}
+	return s.send(ctx, url, event, true)
+}
+
+// Send delivers event to url.
+func (s *Sender) Send(ctx context.Context, url string, event Event) (*Response, error) {
+	return s.send(ctx, url, event, false)
+}
+
+func (s *Sender) send(ctx context.Context, url string, event Event, test bool) (*Response, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	req := s.NewRequest(body, event.ID, time.Now())
+	req.Test = test
+	return s.Post(ctx, url, req)
+}
+
+// Post sends req to url once, as it is.
+func (s *Sender) Post(ctx context.Context, url string, req *Request) (*Response, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return s.post(ctx, client, url, req)
+}
+
+// Attempt is one try of a Deliver call.
+type Attempt struct {
+	Start time.Time
+
+	// Response is the endpoint's answer, nil when none arrived, such as
+	// on a connection error or timeout; Err says why.
+	Response *Response
+	Err      error
+
+	// Wait is the pause before the next attempt, 0 for the last one.
+	Wait time.Duration
+}
+
+// OK reports whether the endpoint accepted the attempt with a 2xx.
+func (a *Attempt) OK() bool {
+	return a.Response != nil && a.Response.OK()
+}
+
+// Retryable reports whether trying again could succeed: after no
+// response, a 5xx, 408 or 429. Other answers would repeat, since the
+// same body is sent again.
+func (a *Attempt) Retryable() bool {
+	if a.Response == nil {
+		return true
+	}
+	status := a.Response.Status
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// Delivery is the outcome of a Deliver call.
+type Delivery struct {
+	WebhookID string
+	EventID   string
+	Attempts  []Attempt
+}
+
+// OK reports whether the last attempt was accepted.
+func (d *Delivery) OK() bool {
+	return len(d.Attempts) > 0 && d.Attempts[len(d.Attempts)-1].OK()
+}
+
+// Deliver sends event to url until the endpoint accepts it, answers with
+// a status retrying would not fix, or MaxAttempts attempts were made. The
+// X-Webhook-Id and X-Event-Id stay the same across attempts, so the
+// endpoint can deduplicate them, and each attempt is signed with a fresh
+// timestamp.
+//
+// The error is nil once the event was delivered. Otherwise it describes
+// the last attempt, and the Delivery still lists every attempt made. If
+// ctx ends, Deliver returns at once with ctx's error.
+func (s *Sender) Deliver(ctx context.Context, url string, event Event) (*Delivery, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return s.DeliverBody(ctx, url, event.ID, body)
+}
+
+// DeliverBody is Deliver for an event that is already encoded, sent as
+// body with eventID as its X-Event-Id.
+func (s *Sender) DeliverBody(ctx context.Context, url, eventID string, body []byte) (*Delivery, error) {
+	timeout, maxAttempts := s.Timeout, s.MaxAttempts
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	delay, maxDelay := s.Backoff, s.MaxBackoff
+	if delay <= 0 {
+		delay = time.Second
+	}
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+	// Attempts are bounded by Timeout, not by a client timeout.
+	client := s.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	webhookID := s.WebhookID
+	if webhookID == "" {
+		webhookID = NewID("wh_")
+	}
+	d := &Delivery{WebhookID: webhookID, EventID: eventID}
+	for n := 1; ; n++ {
+		if s.BeforeAttempt != nil {
+			if err := s.BeforeAttempt(ctx); err != nil {
+				return d, err
+			}
+		}
+		a := Attempt{Start: time.Now()}
+		req := s.NewRequest(body, eventID, a.Start)
+		req.WebhookID = webhookID
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		a.Response, a.Err = s.post(attemptCtx, client, url, req)
+		cancel()
+
+		var err error
+		switch {
+		case a.OK():
+		case ctx.Err() != nil:
+			err = ctx.Err()
+		case !a.Retryable() || n >= maxAttempts:
+			err = attemptError(n, &a)
+		default:
+			// Up to 20% jitter, so retries of many events spread out.
+			a.Wait = delay + time.Duration(mrand.Int63n(int64(delay)/5+1))
+			if after := a.Response.retryAfter(); after > a.Wait {
+				a.Wait = min(after, maxDelay)
+			}
+		}
+		d.Attempts = append(d.Attempts, a)
+		if s.OnAttempt != nil {
+			s.OnAttempt(n, &d.Attempts[len(d.Attempts)-1])
+		}
+		if a.Wait == 0 {
+			return d, err
+		}
+		t := time.NewTimer(a.Wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return d, ctx.Err()
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+func attemptError(n int, a *Attempt) error {
+	var why string
+	if a.Response == nil {
+		why = a.Err.Error()
+	} else {
+		why = fmt.Sprintf("endpoint answered %d", a.Response.Status)
+	}
+	if a.Retryable() {
+		return fmt.Errorf("webhooksend: gave up after %d attempts: %s", n, why)
+	}
+	return fmt.Errorf("webhooksend: %s, not retried", why)
+}
+
+// retryAfter is the delay the Retry-After header asks for, in seconds or
+// as an HTTP date; 0 when there is none.
+func (r *Response) retryAfter() time.Duration {
+	if r == nil || r.Header == nil {
+		return 0
+	}
+	v := r.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// post sends r with the headers Codehooks sends, after Header.
+func (s *Sender) post(ctx context.Context, client *http.Client, url string, r *Request) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Codehooks-Webhook/2.0")
+	for name, value := range map[string]string{
+		webhookverify.DefaultSignatureHeader: r.Signature,
+		webhookverify.DefaultTimestampHeader: r.Timestamp,
+		"X-Webhook-Id":                       r.WebhookID,
+		"X-Event-Id":                         r.EventID,
+	} {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	if r.Test {
+		req.Header.Set(TestHeader, "true")
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("webhooksend: read response: %w", err)
+	}
+	return &Response{
+		WebhookID: r.WebhookID,
+		EventID:   r.EventID,
+		Status:    resp.StatusCode,
+		Header:    resp.Header,
+		Body:      respBody,
+		Latency:   time.Since(start),
+	}, nil
+}
+
+// NewID returns prefix followed by 16 random hex digits, such as an event
+// ID for NewID("evt_").
+func NewID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,158 @@
+package webhooksend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RestDB/codehooks-io-templates/webhook-delivery/examples/webhookverify"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+// endpoint answers with statuses in turn, the last one from then on, and
+// records the requests it verified.
+type endpoint struct {
+	t        *testing.T
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := webhookverify.New(secret).VerifyRequest(r, body); err != nil {
+		e.t.Errorf("attempt not verified: %v", err)
+	}
+	e.mu.Lock()
+	n := len(e.requests)
+	e.requests = append(e.requests, r)
+	e.mu.Unlock()
+	status := e.statuses[min(n, len(e.statuses)-1)]
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "0")
+	}
+	w.WriteHeader(status)
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int
+		wantOK       bool
+	}{
+		{"accepted", []int{200}, 5, 1, true},
+		{"retried after 5xx", []int{503, 500, 204}, 5, 3, true},
+		{"retried after 408 and 429", []int{408, 429, 200}, 5, 3, true},
+		{"not retried after 4xx", []int{400, 200}, 5, 1, false},
+		{"not retried after 3xx", []int{301, 200}, 5, 1, false},
+		{"gives up after MaxAttempts", []int{502}, 3, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &endpoint{t: t, statuses: tt.statuses}
+			srv := httptest.NewServer(e)
+			defer srv.Close()
+			s := New(secret)
+			s.MaxAttempts = tt.maxAttempts
+			s.Backoff, s.MaxBackoff = time.Millisecond, time.Millisecond
+			var seen []int
+			s.OnAttempt = func(n int, a *Attempt) { seen = append(seen, n) }
+
+			d, err := s.Deliver(context.Background(), srv.URL, Event{ID: "evt_1", Type: "order.created"})
+			if (err == nil) != tt.wantOK || d.OK() != tt.wantOK {
+				t.Fatalf("Deliver() error = %v, OK() = %v, want OK %v", err, d.OK(), tt.wantOK)
+			}
+			if len(d.Attempts) != tt.wantAttempts || len(e.requests) != tt.wantAttempts || len(seen) != tt.wantAttempts {
+				t.Fatalf("%d attempts, %d requests, %d OnAttempt calls, want %d",
+					len(d.Attempts), len(e.requests), len(seen), tt.wantAttempts)
+			}
+			for i, a := range d.Attempts {
+				if last := i == len(d.Attempts)-1; last != (a.Wait == 0) {
+					t.Errorf("attempt %d waits %v", i+1, a.Wait)
+				}
+			}
+			for _, r := range e.requests {
+				if got := r.Header.Get("X-Webhook-Id"); got != s.WebhookID || got != d.WebhookID {
+					t.Errorf("X-Webhook-Id = %q, want the sender's %q", got, s.WebhookID)
+				}
+				if got := r.Header.Get("X-Event-Id"); got != "evt_1" {
+					t.Errorf("X-Event-Id = %q, want evt_1", got)
+				}
+			}
+		})
+	}
+}
+
+func TestBeforeAttemptEndsDelivery(t *testing.T) {
+	e := &endpoint{t: t, statuses: []int{503}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	s := New(secret)
+	s.Backoff, s.MaxBackoff = time.Millisecond, time.Millisecond
+	calls := 0
+	s.BeforeAttempt = func(ctx context.Context) error {
+		if calls++; calls > 2 {
+			return context.Canceled
+		}
+		return nil
+	}
+	d, err := s.Deliver(context.Background(), srv.URL, Event{ID: "evt_1"})
+	if err != context.Canceled || len(d.Attempts) != 2 {
+		t.Errorf("Deliver() = %d attempts, error %v, want 2 attempts and context.Canceled", len(d.Attempts), err)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(r *Request)
+		want map[string]string // "" for absent
+	}{
+		{
+			name: "as Codehooks sends them",
+			edit: func(r *Request) {},
+			want: map[string]string{"X-Webhook-Id": "wh_sub", "X-Event-Id": "evt_1", "User-Agent": "Codehooks-Webhook/2.0", TestHeader: ""},
+		},
+		{
+			name: "test delivery",
+			edit: func(r *Request) { r.Test = true },
+			want: map[string]string{TestHeader: "true"},
+		},
+		{
+			name: "empty headers are not sent",
+			edit: func(r *Request) { r.Signature, r.Timestamp, r.EventID = "", "", "" },
+			want: map[string]string{"X-Webhook-Signature": "", "X-Webhook-Timestamp": "", "X-Event-Id": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.Header }))
+			defer srv.Close()
+			s := New(secret)
+			s.WebhookID = "wh_sub"
+			s.Header = http.Header{"Authorization": {"Bearer t"}}
+			req := s.NewRequest([]byte(`{"id":"evt_1"}`), "evt_1", time.Now())
+			tt.edit(req)
+			if _, err := s.Post(context.Background(), srv.URL, req); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got.Get(name) != want {
+					t.Errorf("%s = %q, want %q", name, got.Get(name), want)
+				}
+			}
+			if got.Get("Authorization") != "Bearer t" {
+				t.Errorf("Header not sent: Authorization = %q", got.Get("Authorization"))
+			}
+		})
+	}
+}
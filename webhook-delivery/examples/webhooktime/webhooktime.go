@@ -0,0 +1,169 @@
+// Package webhooktime turns the timestamps providers put in webhook
+// payloads into time.Time, so handlers don't each parse dates their own
+// way:
+//
+//	t, err := webhooktime.Parse(event.Data["created"])
+//
+// Parse takes Unix times in seconds, milliseconds, microseconds or
+// nanoseconds, as numbers or strings, Slack's fractional "1355517523.000005",
+// RFC 3339, RFC 1123 as Twilio sends it, and the zone-less forms some
+// providers use, which are read as UTC. Times are returned in UTC.
+package webhooktime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnrecognized is returned for a value that is not a time in any of
+// the known forms.
+var ErrUnrecognized = errors.New("webhooktime: unrecognized time")
+
+// Layouts are tried in order for a string that is not a number.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999 MST",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.UnixDate,
+	"2006-01-02",
+}
+
+// Parse returns the time v stands for. v may be a time.Time, a number as
+// decoded by encoding/json, or a string. The unit of a Unix time is
+// inferred from its size: anything up to 1e11 is seconds, which covers
+// dates until the year 5138, then milliseconds, microseconds and
+// nanoseconds.
+func Parse(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case float64:
+		return fromFloat(v)
+	case json.Number:
+		return parseString(string(v))
+	case int:
+		return Unix(int64(v)), nil
+	case int64:
+		return Unix(v), nil
+	case string:
+		return parseString(v)
+	}
+	return time.Time{}, fmt.Errorf("%w: %T", ErrUnrecognized, v)
+}
+
+// Unix returns the time for a Unix time in seconds, milliseconds,
+// microseconds or nanoseconds, inferred from its size as in Parse.
+func Unix(n int64) time.Time {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < 1e11:
+		return time.Unix(n, 0).UTC()
+	case abs < 1e14:
+		return time.UnixMilli(n).UTC()
+	case abs < 1e17:
+		return time.UnixMicro(n).UTC()
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func parseString(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("%w: empty", ErrUnrecognized)
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Unix(n), nil
+	}
+	// Fractional seconds, as in Slack's event_ts, are kept to the
+	// microsecond without going through a float.
+	if sec, frac, ok := strings.Cut(s, "."); ok && isDigits(sec) && isDigits(frac) {
+		n, err := strconv.ParseInt(sec, 10, 64)
+		if err == nil && n < 1e11 {
+			frac = (frac + "000000000")[:9]
+			nsec, _ := strconv.ParseInt(frac, 10, 64)
+			return time.Unix(n, nsec).UTC(), nil
+		}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrUnrecognized, s)
+}
+
+func fromFloat(f float64) (time.Time, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f) >= 1<<63 {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrUnrecognized, f)
+	}
+	if f == math.Trunc(f) {
+		return Unix(int64(f)), nil
+	}
+	// A fraction means seconds; a float64 holds them to about a
+	// microsecond, so round to that.
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC(), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Field returns the first of paths in data that holds a time Parse
+// understands. A path is a dot-separated list of object keys and array
+// indexes, such as "event.event_ts" or "events.0.timestamp".
+func Field(data map[string]interface{}, paths ...string) (time.Time, bool) {
+	for _, path := range paths {
+		v := lookup(data, path)
+		if v == nil {
+			continue
+		}
+		if t, err := Parse(v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func lookup(data map[string]interface{}, path string) interface{} {
+	var v interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			v = c[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil
+			}
+			v = c[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
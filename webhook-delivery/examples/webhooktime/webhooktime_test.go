@@ -0,0 +1,84 @@
+package webhooktime
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	want := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC) // Unix 1700000000
+	tests := []struct {
+		name string
+		in   interface{}
+		want time.Time
+	}{
+		{"seconds", float64(1700000000), want},
+		{"milliseconds", float64(1700000000123), want.Add(123 * time.Millisecond)},
+		{"microseconds", int64(1700000000123456), want.Add(123456 * time.Microsecond)},
+		{"nanoseconds", int64(1700000000123456789), want.Add(123456789)},
+		{"int", 1700000000, want},
+		{"seconds string", "1700000000", want},
+		{"milliseconds string", "1700000000123", want.Add(123 * time.Millisecond)},
+		{"json.Number", json.Number("1700000000"), want},
+		{"fractional float", 1700000000.5, want.Add(500 * time.Millisecond)},
+		{"Slack event_ts", "1700000000.000005", want.Add(5 * time.Microsecond)},
+		{"RFC 3339", "2023-11-14T22:13:20Z", want},
+		{"RFC 3339 with offset", "2023-11-15T00:13:20+02:00", want},
+		{"RFC 3339 with fraction", "2023-11-14T22:13:20.25Z", want.Add(250 * time.Millisecond)},
+		{"no zone is UTC", "2023-11-14T22:13:20", want},
+		{"space separated", "2023-11-14 22:13:20", want},
+		{"RFC 1123 as Twilio sends it", "Tue, 14 Nov 2023 22:13:20 +0000", want},
+		{"RFC 1123 GMT", "Tue, 14 Nov 2023 22:13:20 GMT", want},
+		{"date only", "2023-11-14", time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)},
+		{"time.Time in another zone", want.In(time.FixedZone("IST", 19800)), want},
+		{"negative seconds", float64(-86400), time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%v) error = %v", tt.in, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("Parse(%v) = %v, want %v in UTC", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnrecognized(t *testing.T) {
+	for _, in := range []interface{}{"", "  ", "yesterday", "2023-13-45", true, nil, map[string]interface{}{}} {
+		if got, err := Parse(in); !errors.Is(err, ErrUnrecognized) {
+			t.Errorf("Parse(%#v) = %v, %v, want ErrUnrecognized", in, got, err)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	var data map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"created": "not a time",
+		"event": {"event_ts": "1700000000.000005"},
+		"events": [{"timestamp": 1700000000}]
+	}`), &data)
+	tests := []struct {
+		paths  []string
+		want   time.Time
+		wantOK bool
+	}{
+		{[]string{"event.event_ts"}, time.Unix(1700000000, 5000).UTC(), true},
+		{[]string{"events.0.timestamp"}, time.Unix(1700000000, 0).UTC(), true},
+		{[]string{"created", "missing", "events.0.timestamp"}, time.Unix(1700000000, 0).UTC(), true},
+		{[]string{"events.1.timestamp"}, time.Time{}, false},
+		{[]string{"created"}, time.Time{}, false},
+		{nil, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := Field(data, tt.paths...)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("Field(%v) = %v, %v, want %v, %v", tt.paths, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package webhookverify
+
+import "net/http"
+
+// BaseStringer is implemented by adapters that can show the message their
+// signatures cover, the base string the sender signs, for debugging
+// deliveries that do not verify. Comparing it with what the sender signed
+// usually shows the problem: a re-encoded body, a different URL, or a
+// timestamp taken from the wrong header.
+type BaseStringer interface {
+	BaseString(r *http.Request, body []byte) (string, error)
+}
+
+// BaseString implements BaseStringer: "{timestamp}.{body}".
+func (v *Verifier) BaseString(r *http.Request, body []byte) (string, error) {
+	_, timestamp := v.Headers(r)
+	if timestamp == "" {
+		return "", ErrMissingHeader
+	}
+	return timestamp + "." + string(body), nil
+}
+
+// BaseString implements BaseStringer: "{t}.{body}".
+func (p *Stripe) BaseString(r *http.Request, body []byte) (string, error) {
+	timestamp, _ := parseStripeHeader(r.Header.Get("Stripe-Signature"))
+	if timestamp == "" {
+		return "", ErrMissingHeader
+	}
+	return timestamp + "." + string(body), nil
+}
+
+// BaseString implements BaseStringer: the body.
+func (p *GitHub) BaseString(r *http.Request, body []byte) (string, error) {
+	return string(body), nil
+}
+
+// BaseString implements BaseStringer: "v0:{timestamp}:{body}".
+func (p *Slack) BaseString(r *http.Request, body []byte) (string, error) {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	if timestamp == "" {
+		return "", ErrMissingHeader
+	}
+	return "v0:" + timestamp + ":" + string(body), nil
+}
+
+// BaseString implements BaseStringer: the body.
+func (p *Shopify) BaseString(r *http.Request, body []byte) (string, error) {
+	return string(body), nil
+}
+
+// BaseString implements BaseStringer: the URL followed by the sorted form
+// parameters, or the URL alone for a JSON body signed via bodySHA256.
+func (p *Twilio) BaseString(r *http.Request, body []byte) (string, error) {
+	message, err := p.message(r, body)
+	return string(message), err
+}
+
+// BaseString implements BaseStringer: "{timestamp}{body}", which is
+// hashed with SHA-256 and signed with ECDSA.
+func (p *SendGrid) BaseString(r *http.Request, body []byte) (string, error) {
+	timestamp := r.Header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	if timestamp == "" {
+		return "", ErrMissingHeader
+	}
+	return timestamp + string(body), nil
+}
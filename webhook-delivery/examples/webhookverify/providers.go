@@ -0,0 +1,470 @@
+package webhookverify
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProviderAdapter verifies deliveries from one webhook source. Each source
+// has its own signature header, signature format and timestamp handling;
+// an adapter hides them so one receiver can accept webhooks from several
+// sources through the same code path.
+//
+// *Verifier is the adapter for Codehooks deliveries. Stripe, GitHub, Slack,
+// Shopify, Twilio and SendGrid adapt the signatures those services send.
+type ProviderAdapter interface {
+	// Name identifies the source, such as "stripe".
+	Name() string
+
+	// Signed reports whether r carries the source's signature headers, so
+	// unsigned requests can be turned away before the body is read.
+	Signed(r *http.Request) bool
+
+	// CheckRequest verifies body against the signature headers of r.
+	// Result.Timestamp is zero for sources that do not sign a timestamp.
+	CheckRequest(r *http.Request, body []byte) (Result, error)
+
+	// Describe returns the delivery ID, which stays the same across
+	// retries, and the event type of an authentic delivery. Either is
+	// empty when the source does not send one.
+	Describe(r *http.Request, body []byte) (deliveryID, eventType string)
+}
+
+// Name implements ProviderAdapter.
+func (v *Verifier) Name() string { return "codehooks" }
+
+// Signed implements ProviderAdapter.
+func (v *Verifier) Signed(r *http.Request) bool {
+	signature, timestamp := v.Headers(r)
+	return signature != "" && timestamp != ""
+}
+
+// Describe implements ProviderAdapter. X-Webhook-Id is the subscription,
+// the same for every event it delivers, so the delivery ID is
+// "{X-Webhook-Id}/{X-Event-Id}", with the payload's "id" when there is no
+// X-Event-Id. Without either, the delivery ID is empty, since the
+// subscription alone does not tell events apart. The event type is the
+// "type" field of the payload.
+func (v *Verifier) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	json.Unmarshal(body, &event)
+	webhookID := r.Header.Get("X-Webhook-Id")
+	eventID := r.Header.Get("X-Event-Id")
+	if eventID == "" {
+		eventID = event.ID
+	}
+	if webhookID == "" || eventID == "" {
+		return "", event.Type
+	}
+	return webhookID + "/" + eventID, event.Type
+}
+
+// Stripe verifies the Stripe-Signature header:
+//
+//	Stripe-Signature: t=1700000000,v1=<hex(hmac_sha256(secret, "{t}.{body}"))>
+//
+// Secret is the endpoint's signing secret, including its "whsec_" prefix.
+type Stripe struct {
+	Secret  string
+	Secrets []string // accepted while rotating, after Secret
+
+	// Tolerance defaults to DefaultTolerance; Clock to the wall clock.
+	Tolerance time.Duration
+	Clock     Clock
+
+	// Replays, if set, rejects deliveries accepted before.
+	Replays ReplayCache
+}
+
+func (p *Stripe) Name() string { return "stripe" }
+
+func (p *Stripe) Signed(r *http.Request) bool {
+	return r.Header.Get("Stripe-Signature") != ""
+}
+
+func (p *Stripe) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	header := r.Header.Get("Stripe-Signature")
+	if header == "" {
+		return Result{}, ErrMissingHeader
+	}
+	timestamp, sigs := parseStripeHeader(header)
+	if timestamp == "" {
+		return Result{}, fmt.Errorf("%w: no timestamp", ErrMalformedHeader)
+	}
+	ts, err := checkTimestamp(timestamp, p.Tolerance, p.Clock)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(sigs) == 0 {
+		return Result{}, ErrUnknownScheme
+	}
+	message := append([]byte(timestamp+"."), body...)
+	i, ok := matchSecret(p.Secret, p.Secrets, sigs, func(secret string) string {
+		return hex.EncodeToString(hmacSHA256(secret, message))
+	})
+	if !ok {
+		return Result{}, ErrSignatureMismatch
+	}
+	if err := checkReplay(r.Context(), p.Replays, p.Name(), ts, p.Tolerance, bodySum(body)); err != nil {
+		return Result{}, err
+	}
+	return Result{SecretIndex: i, Rotated: i > 0, Scheme: "v1", Timestamp: ts}, nil
+}
+
+// parseStripeHeader returns the timestamp and v1 signatures of a
+// Stripe-Signature header.
+func parseStripeHeader(header string) (timestamp string, sigs []string) {
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sigs = append(sigs, value)
+		}
+	}
+	return timestamp, sigs
+}
+
+// Describe implements ProviderAdapter with the event's "id" and "type".
+func (p *Stripe) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	json.Unmarshal(body, &event)
+	return event.ID, event.Type
+}
+
+// GitHub verifies the X-Hub-Signature-256 header:
+//
+//	X-Hub-Signature-256: sha256=<hex(hmac_sha256(secret, body))>
+//
+// GitHub signs no timestamp, so a captured delivery can be replayed; pair
+// it with deduplication on the delivery ID.
+type GitHub struct {
+	Secret  string
+	Secrets []string // accepted while rotating, after Secret
+}
+
+func (p *GitHub) Name() string { return "github" }
+
+func (p *GitHub) Signed(r *http.Request) bool {
+	return r.Header.Get("X-Hub-Signature-256") != ""
+}
+
+func (p *GitHub) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	header := r.Header.Get("X-Hub-Signature-256")
+	if header == "" {
+		return Result{}, ErrMissingHeader
+	}
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return Result{}, ErrUnknownScheme
+	}
+	i, ok := matchSecret(p.Secret, p.Secrets, []string{sig}, func(secret string) string {
+		return hex.EncodeToString(hmacSHA256(secret, body))
+	})
+	if !ok {
+		return Result{}, ErrSignatureMismatch
+	}
+	return Result{SecretIndex: i, Rotated: i > 0, Scheme: "sha256"}, nil
+}
+
+// Describe implements ProviderAdapter with X-GitHub-Delivery, and
+// X-GitHub-Event followed by the payload's action if it has one, such as
+// "pull_request.opened".
+func (p *GitHub) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	var payload struct {
+		Action string `json:"action"`
+	}
+	json.Unmarshal(body, &payload)
+	eventType = r.Header.Get("X-GitHub-Event")
+	if eventType != "" && payload.Action != "" {
+		eventType += "." + payload.Action
+	}
+	return r.Header.Get("X-GitHub-Delivery"), eventType
+}
+
+// Slack verifies the X-Slack-Signature and X-Slack-Request-Timestamp
+// headers:
+//
+//	X-Slack-Signature: v0=<hex(hmac_sha256(secret, "v0:{timestamp}:{body}"))>
+//
+// Secret is the app's signing secret.
+type Slack struct {
+	Secret  string
+	Secrets []string // accepted while rotating, after Secret
+
+	// Tolerance defaults to DefaultTolerance; Clock to the wall clock.
+	Tolerance time.Duration
+	Clock     Clock
+
+	// Replays, if set, rejects deliveries accepted before.
+	Replays ReplayCache
+}
+
+func (p *Slack) Name() string { return "slack" }
+
+func (p *Slack) Signed(r *http.Request) bool {
+	return r.Header.Get("X-Slack-Signature") != "" && r.Header.Get("X-Slack-Request-Timestamp") != ""
+}
+
+func (p *Slack) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	header, timestamp := r.Header.Get("X-Slack-Signature"), r.Header.Get("X-Slack-Request-Timestamp")
+	if header == "" || timestamp == "" {
+		return Result{}, ErrMissingHeader
+	}
+	ts, err := checkTimestamp(timestamp, p.Tolerance, p.Clock)
+	if err != nil {
+		return Result{}, err
+	}
+	sig, ok := strings.CutPrefix(header, "v0=")
+	if !ok {
+		return Result{}, ErrUnknownScheme
+	}
+	message := append([]byte("v0:"+timestamp+":"), body...)
+	i, ok := matchSecret(p.Secret, p.Secrets, []string{sig}, func(secret string) string {
+		return hex.EncodeToString(hmacSHA256(secret, message))
+	})
+	if !ok {
+		return Result{}, ErrSignatureMismatch
+	}
+	if err := checkReplay(r.Context(), p.Replays, p.Name(), ts, p.Tolerance, bodySum(body)); err != nil {
+		return Result{}, err
+	}
+	return Result{SecretIndex: i, Rotated: i > 0, Scheme: "v0", Timestamp: ts}, nil
+}
+
+// Describe implements ProviderAdapter for the Events API: the delivery ID
+// is "event_id", and the event type is the inner event's type for
+// event_callback payloads and the payload's type otherwise.
+func (p *Slack) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	var payload struct {
+		Type    string `json:"type"`
+		EventID string `json:"event_id"`
+		Event   struct {
+			Type string `json:"type"`
+		} `json:"event"`
+	}
+	json.Unmarshal(body, &payload)
+	if payload.Type == "event_callback" && payload.Event.Type != "" {
+		return payload.EventID, payload.Event.Type
+	}
+	return payload.EventID, payload.Type
+}
+
+// Shopify verifies the X-Shopify-Hmac-Sha256 header:
+//
+//	X-Shopify-Hmac-Sha256: <base64(hmac_sha256(secret, body))>
+//
+// Secret is the app's client secret. Shopify signs no timestamp.
+type Shopify struct {
+	Secret  string
+	Secrets []string // accepted while rotating, after Secret
+}
+
+func (p *Shopify) Name() string { return "shopify" }
+
+func (p *Shopify) Signed(r *http.Request) bool {
+	return r.Header.Get("X-Shopify-Hmac-Sha256") != ""
+}
+
+func (p *Shopify) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	sig := r.Header.Get("X-Shopify-Hmac-Sha256")
+	if sig == "" {
+		return Result{}, ErrMissingHeader
+	}
+	i, ok := matchSecret(p.Secret, p.Secrets, []string{sig}, func(secret string) string {
+		return base64.StdEncoding.EncodeToString(hmacSHA256(secret, body))
+	})
+	if !ok {
+		return Result{}, ErrSignatureMismatch
+	}
+	return Result{SecretIndex: i, Rotated: i > 0, Scheme: "hmac-sha256"}, nil
+}
+
+// Describe implements ProviderAdapter with X-Shopify-Event-Id, or
+// X-Shopify-Webhook-Id from shops that do not send it yet, and the
+// X-Shopify-Topic, such as "orders/create".
+func (p *Shopify) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	deliveryID = r.Header.Get("X-Shopify-Event-Id")
+	if deliveryID == "" {
+		deliveryID = r.Header.Get("X-Shopify-Webhook-Id")
+	}
+	return deliveryID, r.Header.Get("X-Shopify-Topic")
+}
+
+func hmacSHA256(secret string, message []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// checkTimestamp parses a Unix timestamp in seconds and checks it is within
+// tolerance of the clock's time.
+func checkTimestamp(timestamp string, tolerance time.Duration, clock Clock) (time.Time, error) {
+	signedAt, err := parseTimestamp(timestamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	now := time.Now()
+	if clock != nil {
+		now = clock.Now()
+	}
+	if skew := now.Sub(signedAt); skew > tolerance || skew < -tolerance {
+		return time.Time{}, ErrStaleTimestamp
+	}
+	return signedAt, nil
+}
+
+func parseTimestamp(timestamp string) (time.Time, error) {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, errInvalidTimestamp
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// matchSecret returns the index of the first secret, Secret then Secrets,
+// whose expected signature is among sigs.
+func matchSecret(secret string, secrets []string, sigs []string, sign func(secret string) string) (int, bool) {
+	for i, s := range append([]string{secret}, secrets...) {
+		expected := []byte(sign(s))
+		for _, sig := range sigs {
+			if subtle.ConstantTimeCompare(expected, []byte(sig)) == 1 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Twilio verifies the X-Twilio-Signature header:
+//
+//	X-Twilio-Signature: <base64(hmac_sha1(auth_token, url + params))>
+//
+// where url is the full URL Twilio requested, query included, and params
+// are the form parameters sorted by name, each name followed by its value.
+// JSON requests are signed over the URL alone, which then carries a
+// bodySHA256 parameter with the hex SHA-256 of the body. Secret is the
+// account's auth token. Twilio signs no timestamp.
+type Twilio struct {
+	Secret  string
+	Secrets []string // accepted while rotating, after Secret
+
+	// BaseURL is the scheme and host Twilio is configured with, such as
+	// "https://example.ngrok.app", for receivers behind a proxy or tunnel
+	// that rewrites them. When empty, it is taken from the request.
+	BaseURL string
+}
+
+func (p *Twilio) Name() string { return "twilio" }
+
+func (p *Twilio) Signed(r *http.Request) bool {
+	return r.Header.Get("X-Twilio-Signature") != ""
+}
+
+func (p *Twilio) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	sig := r.Header.Get("X-Twilio-Signature")
+	if sig == "" {
+		return Result{}, ErrMissingHeader
+	}
+	if hash := r.URL.Query().Get("bodySHA256"); hash != "" {
+		sum := sha256.Sum256(body)
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(hash)) != 1 {
+			return Result{}, ErrSignatureMismatch
+		}
+	}
+	message, err := p.message(r, body)
+	if err != nil {
+		return Result{}, ErrSignatureMismatch
+	}
+	i, ok := matchSecret(p.Secret, p.Secrets, []string{sig}, func(secret string) string {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write(message)
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	})
+	if !ok {
+		return Result{}, ErrSignatureMismatch
+	}
+	return Result{SecretIndex: i, Rotated: i > 0, Scheme: "hmac-sha1"}, nil
+}
+
+// message is what Twilio signs for r.
+func (p *Twilio) message(r *http.Request, body []byte) ([]byte, error) {
+	message := []byte(p.requestURL(r))
+	if r.URL.Query().Get("bodySHA256") != "" {
+		return message, nil
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: body is not a form", ErrMalformedHeader)
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range params[name] {
+			message = append(message, name+value...)
+		}
+	}
+	return message, nil
+}
+
+func (p *Twilio) requestURL(r *http.Request) string {
+	if p.BaseURL != "" {
+		return strings.TrimSuffix(p.BaseURL, "/") + r.URL.RequestURI()
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// Describe implements ProviderAdapter. The delivery ID is the
+// I-Twilio-Idempotency-Token header, or the message or call SID. The event
+// type is "message.<status>" or "call.<status>", such as
+// "message.delivered" or "message.received".
+func (p *Twilio) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	params, _ := url.ParseQuery(string(body))
+	deliveryID = r.Header.Get("I-Twilio-Idempotency-Token")
+	switch {
+	case params.Get("CallSid") != "":
+		if deliveryID == "" {
+			deliveryID = params.Get("CallSid") + ":" + params.Get("CallStatus")
+		}
+		return deliveryID, "call." + params.Get("CallStatus")
+	case params.Get("MessageSid") != "":
+		status := params.Get("MessageStatus")
+		if status == "" {
+			status = params.Get("SmsStatus")
+		}
+		if deliveryID == "" {
+			deliveryID = params.Get("MessageSid") + ":" + status
+		}
+		return deliveryID, "message." + status
+	}
+	return deliveryID, ""
+}
@@ -0,0 +1,353 @@
+package webhookverify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Signatures from the providers' documentation: GitHub's guide to
+// validating webhook deliveries, Slack's guide to verifying requests,
+// Twilio's security guide, with the JSON case from twilio-python's tests,
+// and SendGrid's from sendgrid-go's Event Webhook tests. Stripe and Shopify
+// publish none together with its secret; theirs were computed outside this
+// package, with Python's hmac module.
+const (
+	githubSecret    = "It's a Secret to Everybody"
+	githubBody      = "Hello, World!"
+	githubSignature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+
+	slackSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	slackTimestamp = "1531420618"
+	slackBody      = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V" +
+		"&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=" +
+		"&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+		"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	slackSignature = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+
+	twilioToken         = "12345"
+	twilioURL           = "https://mycompany.com/myapp.php?foo=1&bar=2"
+	twilioForm          = "CallSid=CA1234567890ABCDE&Caller=%2B12349013030&Digits=1234&From=%2B12349013030&To=%2B18005551212"
+	twilioFormSignature = "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+	twilioJSON          = `{"property": "value", "boolean": true}`
+	twilioJSONHash      = "0a1ff7634d9ab3b95db5c9a2dfe9416e41502b283a80c7cf19632632f96e6620"
+	twilioJSONSignature = "a9nBmqA0ju/hNViExpshrM61xv4="
+
+	sendGridKey       = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEEDr2LjtURuePQzplybdC+u4CwrqDqBaWjcMMsTbhdbcwHBcepxo7yAQGhHPTnlvFYPAZFceEu/1FwCM/QmGUhA=="
+	sendGridTimestamp = "1588788367"
+	sendGridBody      = `{"category":"example_payload","event":"test_event","message_id":"message_id"}`
+	sendGridSignature = "MEUCIQCtIHJeH93Y+qpYeWrySphQgpNGNr/U+UyUlBkU6n7RAwIgJTz2C+8a8xonZGi6BpSzoQsbVRamr2nlxFDWYNH2j/0="
+
+	stripeSecret    = "whsec_test_secret"
+	stripeBody      = `{"id":"evt_1NG8Du2eZvKYlo2CUI79vXWy","object":"event","type":"invoice.paid","data":{"object":{}}}`
+	stripeSignature = "t=1700000000,v1=699a07a7742f4fd6f28671fc30affac153f0da06a08183b145f2838aeef1e083"
+
+	shopifySecret    = "shpss_test_secret"
+	shopifyBody      = `{"id":820982911946154508,"email":"jon@example.com"}`
+	shopifySignature = "LnwdZPLBbdjkBwPD/lDL1/rYEGby/GHPtL1aAH6Dxjc="
+)
+
+func TestProviders(t *testing.T) {
+	slackTime := time.Unix(1531420618, 0)
+	tests := []struct {
+		name    string
+		adapter ProviderAdapter
+		target  string
+		header  http.Header
+		body    string
+		wantErr error
+		want    Result
+	}{
+		{
+			name:    "stripe",
+			adapter: &Stripe{Secret: stripeSecret, Clock: fixedClock(now)},
+			header:  http.Header{"Stripe-Signature": {stripeSignature}},
+			body:    stripeBody,
+			want:    Result{Scheme: "v1", Timestamp: now},
+		},
+		{
+			name:    "stripe rotated secret",
+			adapter: &Stripe{Secret: "whsec_new_secret", Secrets: []string{stripeSecret}, Clock: fixedClock(now)},
+			header:  http.Header{"Stripe-Signature": {stripeSignature}},
+			body:    stripeBody,
+			want:    Result{SecretIndex: 1, Rotated: true, Scheme: "v1", Timestamp: now},
+		},
+		{
+			name:    "stripe stale",
+			adapter: &Stripe{Secret: stripeSecret, Clock: fixedClock(now.Add(10 * time.Minute))},
+			header:  http.Header{"Stripe-Signature": {stripeSignature}},
+			body:    stripeBody,
+			wantErr: ErrStaleTimestamp,
+		},
+		{
+			name:    "stripe without v1",
+			adapter: &Stripe{Secret: stripeSecret, Clock: fixedClock(now)},
+			header:  http.Header{"Stripe-Signature": {"t=1700000000,v0=6ffbb59b"}},
+			body:    stripeBody,
+			wantErr: ErrUnknownScheme,
+		},
+		{
+			name:    "stripe tampered",
+			adapter: &Stripe{Secret: stripeSecret, Clock: fixedClock(now)},
+			header:  http.Header{"Stripe-Signature": {stripeSignature}},
+			body:    strings.Replace(stripeBody, "invoice.paid", "invoice.voided", 1),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "github",
+			adapter: &GitHub{Secret: githubSecret},
+			header:  http.Header{"X-Hub-Signature-256": {githubSignature}},
+			body:    githubBody,
+			want:    Result{Scheme: "sha256"},
+		},
+		{
+			name:    "github sha1",
+			adapter: &GitHub{Secret: githubSecret},
+			header:  http.Header{"X-Hub-Signature-256": {"sha1=01dc10d0c83e72ed246219cdd91669667fe2ca59"}},
+			body:    githubBody,
+			wantErr: ErrUnknownScheme,
+		},
+		{
+			name:    "github wrong secret",
+			adapter: &GitHub{Secret: "It's no secret"},
+			header:  http.Header{"X-Hub-Signature-256": {githubSignature}},
+			body:    githubBody,
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "github unsigned",
+			adapter: &GitHub{Secret: githubSecret},
+			body:    githubBody,
+			wantErr: ErrMissingHeader,
+		},
+		{
+			name:    "slack",
+			adapter: &Slack{Secret: slackSecret, Clock: fixedClock(slackTime)},
+			header:  http.Header{"X-Slack-Signature": {slackSignature}, "X-Slack-Request-Timestamp": {slackTimestamp}},
+			body:    slackBody,
+			want:    Result{Scheme: "v0", Timestamp: slackTime},
+		},
+		{
+			name:    "slack stale",
+			adapter: &Slack{Secret: slackSecret, Clock: fixedClock(now)},
+			header:  http.Header{"X-Slack-Signature": {slackSignature}, "X-Slack-Request-Timestamp": {slackTimestamp}},
+			body:    slackBody,
+			wantErr: ErrStaleTimestamp,
+		},
+		{
+			name:    "slack without timestamp",
+			adapter: &Slack{Secret: slackSecret, Clock: fixedClock(slackTime)},
+			header:  http.Header{"X-Slack-Signature": {slackSignature}},
+			body:    slackBody,
+			wantErr: ErrMissingHeader,
+		},
+		{
+			name:    "slack tampered",
+			adapter: &Slack{Secret: slackSecret, Clock: fixedClock(slackTime)},
+			header:  http.Header{"X-Slack-Signature": {slackSignature}, "X-Slack-Request-Timestamp": {slackTimestamp}},
+			body:    strings.Replace(slackBody, "roadrunner", "coyote", 1),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "shopify",
+			adapter: &Shopify{Secret: shopifySecret},
+			header:  http.Header{"X-Shopify-Hmac-Sha256": {shopifySignature}},
+			body:    shopifyBody,
+			want:    Result{Scheme: "hmac-sha256"},
+		},
+		{
+			name:    "shopify tampered",
+			adapter: &Shopify{Secret: shopifySecret},
+			header:  http.Header{"X-Shopify-Hmac-Sha256": {shopifySignature}},
+			body:    strings.Replace(shopifyBody, "jon", "joe", 1),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "twilio form",
+			adapter: &Twilio{Secret: twilioToken},
+			target:  twilioURL,
+			header:  http.Header{"X-Twilio-Signature": {twilioFormSignature}},
+			body:    twilioForm,
+			want:    Result{Scheme: "hmac-sha1"},
+		},
+		{
+			name:    "twilio form behind a proxy",
+			adapter: &Twilio{Secret: twilioToken, BaseURL: "https://mycompany.com/"},
+			target:  "http://localhost:8080/myapp.php?foo=1&bar=2",
+			header:  http.Header{"X-Twilio-Signature": {twilioFormSignature}},
+			body:    twilioForm,
+			want:    Result{Scheme: "hmac-sha1"},
+		},
+		{
+			name:    "twilio form tampered",
+			adapter: &Twilio{Secret: twilioToken},
+			target:  twilioURL,
+			header:  http.Header{"X-Twilio-Signature": {twilioFormSignature}},
+			body:    strings.Replace(twilioForm, "Digits=1234", "Digits=9999", 1),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "twilio JSON",
+			adapter: &Twilio{Secret: twilioToken},
+			target:  twilioURL + "&bodySHA256=" + twilioJSONHash,
+			header:  http.Header{"X-Twilio-Signature": {twilioJSONSignature}},
+			body:    twilioJSON,
+			want:    Result{Scheme: "hmac-sha1"},
+		},
+		{
+			name:    "twilio JSON tampered",
+			adapter: &Twilio{Secret: twilioToken},
+			target:  twilioURL + "&bodySHA256=" + twilioJSONHash,
+			header:  http.Header{"X-Twilio-Signature": {twilioJSONSignature}},
+			body:    `{"property": "other", "boolean": true}`,
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "sendgrid",
+			adapter: &SendGrid{PublicKey: sendGridKey},
+			header: http.Header{"X-Twilio-Email-Event-Webhook-Signature": {sendGridSignature},
+				"X-Twilio-Email-Event-Webhook-Timestamp": {sendGridTimestamp}},
+			body: sendGridBody,
+			want: Result{Scheme: "ecdsa-p256", Timestamp: time.Unix(1588788367, 0)},
+		},
+		{
+			name:    "sendgrid tampered",
+			adapter: &SendGrid{PublicKey: sendGridKey},
+			header: http.Header{"X-Twilio-Email-Event-Webhook-Signature": {sendGridSignature},
+				"X-Twilio-Email-Event-Webhook-Timestamp": {sendGridTimestamp}},
+			body:    strings.Replace(sendGridBody, "test_event", "delivered", 1),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "sendgrid other timestamp",
+			adapter: &SendGrid{PublicKey: sendGridKey},
+			header: http.Header{"X-Twilio-Email-Event-Webhook-Signature": {sendGridSignature},
+				"X-Twilio-Email-Event-Webhook-Timestamp": {"1588788368"}},
+			body:    sendGridBody,
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			name:    "sendgrid outside tolerance",
+			adapter: &SendGrid{PublicKey: sendGridKey, Tolerance: time.Hour, Clock: fixedClock(now)},
+			header: http.Header{"X-Twilio-Email-Event-Webhook-Signature": {sendGridSignature},
+				"X-Twilio-Email-Event-Webhook-Timestamp": {sendGridTimestamp}},
+			body:    sendGridBody,
+			wantErr: ErrStaleTimestamp,
+		},
+		{
+			name:    "sendgrid signature not base64",
+			adapter: &SendGrid{PublicKey: sendGridKey},
+			header: http.Header{"X-Twilio-Email-Event-Webhook-Signature": {"not base64!"},
+				"X-Twilio-Email-Event-Webhook-Timestamp": {sendGridTimestamp}},
+			body:    sendGridBody,
+			wantErr: ErrMalformedHeader,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/webhook/" + tt.adapter.Name()
+			}
+			r := httptest.NewRequest("POST", target, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			if got, want := tt.adapter.Signed(r), tt.wantErr != ErrMissingHeader; got != want {
+				t.Errorf("Signed() = %v, want %v", got, want)
+			}
+
+			got, err := tt.adapter.CheckRequest(r, []byte(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got != tt.want {
+				t.Errorf("CheckRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	sum := func(body string) string {
+		s := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(s[:])
+	}
+	const delivered = `[{"event":"delivered"},{"event":"delivered"}]`
+	const mixed = `[{"event":"delivered"},{"event":"open"}]`
+	tests := []struct {
+		name     string
+		adapter  ProviderAdapter
+		target   string
+		header   http.Header
+		body     string
+		wantID   string
+		wantType string
+	}{
+		{"codehooks event header", New(secret), "",
+			http.Header{"X-Webhook-Id": {"wh_sub"}, "X-Event-Id": {"evt_2"}},
+			`{"id":"evt_1","type":"order.created"}`, "wh_sub/evt_2", "order.created"},
+		{"codehooks payload id", New(secret), "",
+			http.Header{"X-Webhook-Id": {"wh_sub"}},
+			`{"id":"evt_1","type":"order.created"}`, "wh_sub/evt_1", "order.created"},
+		{"codehooks without event ID", New(secret), "",
+			http.Header{"X-Webhook-Id": {"wh_sub"}},
+			`{"type":"order.created"}`, "", "order.created"},
+		{"codehooks without webhook ID", New(secret), "", nil,
+			`{"id":"evt_1","type":"order.created"}`, "", "order.created"},
+		{"stripe", &Stripe{}, "", nil, stripeBody, "evt_1NG8Du2eZvKYlo2CUI79vXWy", "invoice.paid"},
+		{"github with action", &GitHub{}, "",
+			http.Header{"X-Github-Delivery": {"72d3162e-cc78-11e3-81ab-4c9367dc0958"}, "X-Github-Event": {"pull_request"}},
+			`{"action":"opened"}`, "72d3162e-cc78-11e3-81ab-4c9367dc0958", "pull_request.opened"},
+		{"github without action", &GitHub{}, "",
+			http.Header{"X-Github-Delivery": {"72d3162e-cc78-11e3-81ab-4c9367dc0958"}, "X-Github-Event": {"push"}},
+			`{"ref":"refs/heads/main"}`, "72d3162e-cc78-11e3-81ab-4c9367dc0958", "push"},
+		{"slack event_callback", &Slack{}, "", nil,
+			`{"type":"event_callback","event_id":"Ev08MFMKH6","event":{"type":"app_mention"}}`, "Ev08MFMKH6", "app_mention"},
+		{"slack event_callback without inner type", &Slack{}, "", nil,
+			`{"type":"event_callback","event_id":"Ev08MFMKH6","event":{}}`, "Ev08MFMKH6", "event_callback"},
+		{"slack other payload", &Slack{}, "", nil,
+			`{"type":"app_rate_limited","minute_rate_limited":1518467820}`, "", "app_rate_limited"},
+		{"shopify", &Shopify{}, "",
+			http.Header{"X-Shopify-Event-Id": {"98880550-7158-44d4-b7cd-2c97c8a091b5"},
+				"X-Shopify-Webhook-Id": {"b54557e4-bdd9-4b37-8a5f-bf7d70bcd043"}, "X-Shopify-Topic": {"orders/create"}},
+			shopifyBody, "98880550-7158-44d4-b7cd-2c97c8a091b5", "orders/create"},
+		{"shopify without event ID", &Shopify{}, "",
+			http.Header{"X-Shopify-Webhook-Id": {"b54557e4-bdd9-4b37-8a5f-bf7d70bcd043"}, "X-Shopify-Topic": {"orders/create"}},
+			shopifyBody, "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043", "orders/create"},
+		{"twilio message status", &Twilio{}, "", nil,
+			"MessageSid=SM1&MessageStatus=delivered", "SM1:delivered", "message.delivered"},
+		{"twilio incoming message", &Twilio{}, "", nil,
+			"MessageSid=SM1&SmsStatus=received", "SM1:received", "message.received"},
+		{"twilio call with idempotency token", &Twilio{}, "",
+			http.Header{"I-Twilio-Idempotency-Token": {"idem_1"}},
+			twilioForm + "&CallStatus=completed", "idem_1", "call.completed"},
+		{"twilio JSON", &Twilio{}, twilioURL + "&bodySHA256=" + twilioJSONHash,
+			http.Header{"I-Twilio-Idempotency-Token": {"idem_1"}}, twilioJSON, "idem_1", ""},
+		{"twilio JSON without idempotency token", &Twilio{}, twilioURL + "&bodySHA256=" + twilioJSONHash, nil,
+			twilioJSON, "", ""},
+		{"sendgrid one event type", &SendGrid{}, "", nil, delivered, sum(delivered), "delivered"},
+		{"sendgrid batch", &SendGrid{}, "", nil, mixed, sum(mixed), "batch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/webhook/" + tt.adapter.Name()
+			}
+			r := httptest.NewRequest("POST", target, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			id, eventType := tt.adapter.Describe(r, []byte(tt.body))
+			if id != tt.wantID || eventType != tt.wantType {
+				t.Errorf("Describe() = %q, %q, want %q, %q", id, eventType, tt.wantID, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package webhookverify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrReplayed means a delivery with the same timestamp and body was
+// accepted before, so this one is a replay. It is only returned when a
+// ReplayCache is set.
+var ErrReplayed = errors.New("webhookverify: delivery already accepted")
+
+// ReplayCache remembers the deliveries that were accepted, by timestamp
+// and body hash, so a captured delivery replayed within the tolerance
+// window is rejected whichever of its signatures it presents. Entries are
+// only needed until the timestamp leaves the window. Implementations
+// backed by Redis (SET with NX and PXAT) or a database on disk survive
+// restarts and can be shared by several receiver instances.
+type ReplayCache interface {
+	// Seen records key until expires and reports whether it was already
+	// recorded and has not expired. Both must happen atomically, so two
+	// concurrent copies of one delivery are not both accepted.
+	Seen(ctx context.Context, key string, expires time.Time) (bool, error)
+}
+
+// checkReplay records a delivery signed at ts by source, whose body has
+// the SHA-256 digest sum. It is kept until ts+tolerance. The signature is
+// not part of the key: a header carrying v1= and v2= signatures, or one
+// per rotated secret, would otherwise be accepted once per signature.
+func checkReplay(ctx context.Context, cache ReplayCache, source string, ts time.Time, tolerance time.Duration, sum []byte) error {
+	if cache == nil {
+		return nil
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	key := source + ":" + strconv.FormatInt(ts.Unix(), 10) + ":" + hex.EncodeToString(sum)
+	seen, err := cache.Seen(ctx, key, ts.Add(tolerance))
+	if err != nil {
+		return fmt.Errorf("webhookverify: replay cache: %w", err)
+	}
+	if seen {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// bodySum is the body digest checkReplay keys on.
+func bodySum(body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return sum[:]
+}
+
+// MemoryReplayCache is an in-process ReplayCache. Its entries are lost on
+// restart. The zero value is ready to use.
+type MemoryReplayCache struct {
+	// Clock decides when entries expire; the wall clock is used when it
+	// is nil.
+	Clock Clock
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// Seen implements ReplayCache.
+func (m *MemoryReplayCache) Seen(_ context.Context, key string, expires time.Time) (bool, error) {
+	now := time.Now()
+	if m.Clock != nil {
+		now = m.Clock.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seen == nil {
+		m.seen = map[string]time.Time{}
+	}
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, exp := range m.seen {
+			if !now.Before(exp) {
+				delete(m.seen, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	if exp, ok := m.seen[key]; ok && now.Before(exp) {
+		return true, nil
+	}
+	m.seen[key] = expires
+	return false, nil
+}
@@ -0,0 +1,215 @@
+package webhookverify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSendGridKeyURL is the SendGrid API endpoint that returns the Event
+// Webhook verification key.
+const DefaultSendGridKeyURL = "https://api.sendgrid.com/v3/user/webhooks/event/settings/signed"
+
+// SendGrid verifies the signed Event Webhook:
+//
+//	X-Twilio-Email-Event-Webhook-Signature: <base64(ecdsa_p256_sha256(timestamp + body))>
+//	X-Twilio-Email-Event-Webhook-Timestamp: 1700000000
+//
+// The key is SendGrid's public key, not a shared secret. Set PublicKey to
+// the verification key shown in the SendGrid settings, or set APIKey to
+// fetch it from the API. A fetched key is cached for KeyTTL and fetched
+// again early when a signature does not match, so a key rotated in
+// SendGrid is picked up without a restart.
+type SendGrid struct {
+	// PublicKey is the base64 verification key.
+	PublicKey string
+
+	// APIKey is a SendGrid API key allowed to read the Event Webhook
+	// settings, used when PublicKey is empty.
+	APIKey string
+
+	// KeyURL defaults to DefaultSendGridKeyURL, Client to
+	// http.DefaultClient and KeyTTL to an hour.
+	KeyURL string
+	Client *http.Client
+	KeyTTL time.Duration
+
+	// Tolerance, when set, rejects deliveries whose timestamp is further
+	// from Clock's time. It is off by default because SendGrid retries
+	// failed deliveries for up to 24 hours.
+	Tolerance time.Duration
+	Clock     Clock
+
+	mu          sync.Mutex
+	key         *ecdsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+// sendGridRefetchInterval limits how often a cached key is fetched again,
+// so forged deliveries or an API outage cannot hammer the SendGrid API.
+const sendGridRefetchInterval = time.Minute
+
+func (p *SendGrid) Name() string { return "sendgrid" }
+
+func (p *SendGrid) Signed(r *http.Request) bool {
+	return r.Header.Get("X-Twilio-Email-Event-Webhook-Signature") != "" &&
+		r.Header.Get("X-Twilio-Email-Event-Webhook-Timestamp") != ""
+}
+
+func (p *SendGrid) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	sig := r.Header.Get("X-Twilio-Email-Event-Webhook-Signature")
+	timestamp := r.Header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	if sig == "" || timestamp == "" {
+		return Result{}, ErrMissingHeader
+	}
+	ts, err := parseTimestamp(timestamp)
+	if err != nil {
+		return Result{}, err
+	}
+	if p.Tolerance > 0 {
+		if _, err := checkTimestamp(timestamp, p.Tolerance, p.Clock); err != nil {
+			return Result{}, err
+		}
+	}
+	der, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: signature is not base64", ErrMalformedHeader)
+	}
+	digest := sha256.Sum256(append([]byte(timestamp), body...))
+
+	key, err := p.verificationKey(r.Context(), false)
+	if err != nil {
+		return Result{}, err
+	}
+	if !ecdsa.VerifyASN1(key, digest[:], der) {
+		// The key may have been rotated since it was fetched.
+		if key, err = p.verificationKey(r.Context(), true); err != nil || !ecdsa.VerifyASN1(key, digest[:], der) {
+			return Result{}, ErrSignatureMismatch
+		}
+	}
+	return Result{Scheme: "ecdsa-p256", Timestamp: ts}, nil
+}
+
+// verificationKey returns the parsed key, fetching it when there is none,
+// or when it is older than KeyTTL or refresh is set and the last attempt is
+// more than a minute old.
+func (p *SendGrid) verificationKey(ctx context.Context, refresh bool) (*ecdsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.PublicKey != "" {
+		if p.key == nil {
+			key, err := parseSendGridKey(p.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			p.key = key
+		}
+		return p.key, nil
+	}
+
+	ttl := p.KeyTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if p.key != nil {
+		stale := refresh || time.Since(p.fetchedAt) >= ttl
+		if !stale || time.Since(p.attemptedAt) < sendGridRefetchInterval {
+			return p.key, nil
+		}
+	}
+	p.attemptedAt = time.Now()
+	key, err := p.fetchKey(ctx)
+	if err != nil {
+		if p.key != nil {
+			// Keep verifying with the cached key while the API is down.
+			return p.key, nil
+		}
+		return nil, err
+	}
+	p.key, p.fetchedAt = key, time.Now()
+	return key, nil
+}
+
+func (p *SendGrid) fetchKey(ctx context.Context) (*ecdsa.PublicKey, error) {
+	if p.APIKey == "" {
+		return nil, errors.New("webhookverify: SendGrid needs PublicKey or APIKey")
+	}
+	keyURL, client := p.KeyURL, p.Client
+	if keyURL == "" {
+		keyURL = DefaultSendGridKeyURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", keyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webhookverify: fetch SendGrid key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webhookverify: fetch SendGrid key: %s", resp.Status)
+	}
+	var settings struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		return nil, fmt.Errorf("webhookverify: fetch SendGrid key: %w", err)
+	}
+	if settings.PublicKey == "" {
+		return nil, errors.New("webhookverify: signed Event Webhook is not enabled in SendGrid")
+	}
+	return parseSendGridKey(settings.PublicKey)
+}
+
+func parseSendGridKey(encoded string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("webhookverify: invalid SendGrid key: %w", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("webhookverify: invalid SendGrid key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("webhookverify: invalid SendGrid key: not an ECDSA key")
+	}
+	return key, nil
+}
+
+// Describe implements ProviderAdapter. SendGrid batches events into one
+// JSON array and sends no delivery ID, so the ID is the SHA-256 of the
+// body, which a retry repeats. The event type is the events' "event" if
+// they all share one, such as "delivered", and "batch" otherwise.
+func (p *SendGrid) Describe(r *http.Request, body []byte) (deliveryID, eventType string) {
+	sum := sha256.Sum256(body)
+	var events []struct {
+		Event string `json:"event"`
+	}
+	json.Unmarshal(body, &events)
+	for i, e := range events {
+		if i > 0 && e.Event != eventType {
+			eventType = "batch"
+			break
+		}
+		eventType = e.Event
+	}
+	return hex.EncodeToString(sum[:]), eventType
+}
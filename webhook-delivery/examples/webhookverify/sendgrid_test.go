@@ -0,0 +1,68 @@
+package webhookverify
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendGridKeyFetch(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		response  string
+		wantErr   bool
+		wantFetch int
+	}{
+		{"fetched once", http.StatusOK, `{"enabled":true,"public_key":"` + sendGridKey + `"}`, false, 1},
+		{"signing not enabled", http.StatusOK, `{"enabled":false,"public_key":""}`, true, 2},
+		{"API key refused", http.StatusUnauthorized, `{"errors":[]}`, true, 2},
+		{"not a key", http.StatusOK, `{"public_key":"c2VjcmV0"}`, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetches++
+				if got := r.Header.Get("Authorization"); got != "Bearer SG.test" {
+					t.Errorf("Authorization = %q, want the API key", got)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+			p := &SendGrid{APIKey: "SG.test", KeyURL: srv.URL}
+
+			// Twice: a fetched key is cached, a failed fetch is not.
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest("POST", "/webhook/sendgrid", strings.NewReader(sendGridBody))
+				r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", sendGridSignature)
+				r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", sendGridTimestamp)
+				_, err := p.CheckRequest(r, []byte(sendGridBody))
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("CheckRequest() error = %v, want error %v", err, tt.wantErr)
+				}
+				if errors.Is(err, ErrSignatureMismatch) {
+					t.Fatalf("CheckRequest() error = %v, want a key error", err)
+				}
+			}
+			if fetches != tt.wantFetch {
+				t.Errorf("key fetched %d times, want %d", fetches, tt.wantFetch)
+			}
+		})
+	}
+}
+
+func TestSendGridWithoutKey(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/sendgrid", strings.NewReader(sendGridBody))
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", sendGridSignature)
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", sendGridTimestamp)
+	if _, err := (&SendGrid{}).CheckRequest(r, []byte(sendGridBody)); err == nil {
+		t.Error("CheckRequest() verified without PublicKey or APIKey")
+	}
+	if _, err := (&SendGrid{PublicKey: "c2VjcmV0"}).CheckRequest(r, []byte(sendGridBody)); err == nil {
+		t.Error("CheckRequest() verified with an invalid PublicKey")
+	}
+}
@@ -0,0 +1,122 @@
+package webhookverify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// StreamingScheme is a SignatureScheme that can sign a message written to
+// it in pieces. A Stream uses it to verify a body as it is read. All
+// built-in schemes are streaming schemes.
+type StreamingScheme interface {
+	SignatureScheme
+
+	// NewSigner returns a Signer for secret. Its Signature after the whole
+	// message was written equals Sign(secret, message).
+	NewSigner(secret string) Signer
+}
+
+// Signer accumulates a message and signs it.
+type Signer interface {
+	io.Writer
+	Signature() string
+}
+
+// Stream verifies a body while it is read, in the same pass. It does not
+// keep the body, so a delivery copied elsewhere as it arrives, such as to
+// a file, is never held in memory. Write the body to it, for example
+// through io.TeeReader, then call Check before using what was copied:
+//
+//	stream, err := v.NewStream(r)
+//	if err != nil {
+//		// rejected on the headers alone, without reading the body
+//	}
+//	_, err = io.Copy(file, io.TeeReader(r.Body, stream))
+//	...
+//	result, err := stream.Check(r.Context())
+//
+// Schemes that are not streaming schemes still work; the body is then
+// buffered for them.
+type Stream struct {
+	v       *Verifier
+	headers signedHeaders
+	signers [][]Signer    // per candidate, per secret; nil when buffered
+	writers []Signer      // every distinct signer
+	buf     *bytes.Buffer // the message, when a scheme cannot stream
+	sum     hash.Hash     // the body digest, for Replays
+}
+
+// NewStream checks the signature headers of r and the timestamp window,
+// and returns a Stream for the body. Headers that fail these checks are
+// rejected with the error Check would have returned.
+func (v *Verifier) NewStream(r *http.Request) (*Stream, error) {
+	signature, timestamp := v.Headers(r)
+	h, err := v.parseHeaders(signature, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{v: v, headers: h, sum: sha256.New()}
+	shared := map[string][]Signer{}
+	secrets := v.secrets()
+	prefix := []byte(strconv.FormatInt(h.timestamp, 10) + ".")
+	for _, c := range h.candidates {
+		streaming, ok := c.scheme.(StreamingScheme)
+		if !ok {
+			if s.buf == nil {
+				s.buf = bytes.NewBuffer(append([]byte(nil), prefix...))
+			}
+			s.signers = append(s.signers, nil)
+			continue
+		}
+		// Several signatures of one version share their signers.
+		signers, ok := shared[c.version]
+		if !ok {
+			signers = make([]Signer, len(secrets))
+			for i, secret := range secrets {
+				signers[i] = streaming.NewSigner(secret)
+				signers[i].Write(prefix)
+			}
+			shared[c.version] = signers
+			s.writers = append(s.writers, signers...)
+		}
+		s.signers = append(s.signers, signers)
+	}
+	return s, nil
+}
+
+// Write adds p to the body.
+func (s *Stream) Write(p []byte) (int, error) {
+	for _, signer := range s.writers {
+		signer.Write(p)
+	}
+	if s.buf != nil {
+		s.buf.Write(p)
+	}
+	s.sum.Write(p)
+	return len(p), nil
+}
+
+// Check verifies the body written so far, like CheckRequest would for the
+// whole body. Call it once, after the last Write.
+func (s *Stream) Check(ctx context.Context) (Result, error) {
+	for n, c := range s.headers.candidates {
+		for i, secret := range s.v.secrets() {
+			var expected string
+			if s.signers[n] != nil {
+				expected = s.signers[n][i].Signature()
+			} else {
+				expected = c.scheme.Sign(secret, s.buf.Bytes())
+			}
+			if subtle.ConstantTimeCompare([]byte(expected), []byte(c.sig)) == 1 {
+				return s.v.accept(ctx, s.headers, c, i, s.sum.Sum(nil))
+			}
+		}
+	}
+	return Result{}, ErrSignatureMismatch
+}
@@ -0,0 +1,382 @@
+// Package webhookverify verifies webhooks signed by the Codehooks webhook
+// delivery template.
+//
+// Each delivery carries two headers:
+//
+//	X-Webhook-Timestamp: 1700000000
+//	X-Webhook-Signature: v1=<hex(hmac_sha256(secret, "{timestamp}.{body}"))>
+//
+// A Verifier recomputes the signature over the raw request body and rejects
+// deliveries whose timestamp is outside the tolerance window, which limits
+// replays of captured requests.
+//
+// The signature header may carry several comma-separated signatures, one
+// per scheme version ("v1=...,v2=..."), so senders can move to a new
+// algorithm without breaking receivers that only know the old one. A
+// delivery is authentic when any signature made with an accepted scheme
+// matches; see SignatureScheme.
+//
+// Deliveries from other sources, such as Stripe or GitHub, are verified by
+// the ProviderAdapter for that source. A Verifier is the adapter for
+// Codehooks deliveries.
+//
+//	v := webhookverify.New(os.Getenv("WEBHOOK_SECRET"))
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		body, _ := io.ReadAll(r.Body)
+//		if err := v.VerifyRequest(r, body); err != nil {
+//			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+//			return
+//		}
+//		// body is authentic
+//	}
+package webhookverify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used when the corresponding Verifier field is left empty.
+const (
+	DefaultTolerance       = 5 * time.Minute
+	DefaultSignatureHeader = "X-Webhook-Signature"
+	DefaultTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// Errors returned when a delivery is not authentic, by a Verifier and by
+// every ProviderAdapter. Test for them with errors.Is; the error returned
+// may wrap one with more detail.
+var (
+	// ErrMissingHeader means a signature header the source always sends
+	// is absent.
+	ErrMissingHeader = errors.New("webhookverify: missing signature headers")
+
+	// ErrMalformedHeader means a signature header cannot be parsed, such
+	// as a timestamp that is not Unix seconds.
+	ErrMalformedHeader = errors.New("webhookverify: malformed signature header")
+
+	// ErrStaleTimestamp means the signed timestamp is outside the
+	// tolerance window: the delivery is old, replayed, or the sender's
+	// clock is off.
+	ErrStaleTimestamp = errors.New("webhookverify: timestamp outside tolerance window")
+
+	// ErrSignatureMismatch means no signature matches the body under any
+	// accepted secret.
+	ErrSignatureMismatch = errors.New("webhookverify: signature mismatch")
+
+	// ErrUnknownScheme means the header carries signatures, but none in
+	// an accepted scheme.
+	ErrUnknownScheme = errors.New("webhookverify: no signature with an accepted scheme")
+)
+
+var errInvalidTimestamp = fmt.Errorf("%w: timestamp is not Unix seconds", ErrMalformedHeader)
+
+// SignatureScheme is one signature algorithm, identified by the version
+// prefix its signatures carry in the header.
+type SignatureScheme interface {
+	// Version is the prefix before "=", such as "v1".
+	Version() string
+
+	// Sign returns the encoded signature of message, which is
+	// "{timestamp}.{body}".
+	Sign(secret string, message []byte) string
+}
+
+// HMACScheme signs with HMAC over Hash and hex-encodes the result.
+type HMACScheme struct {
+	Name string
+	Hash func() hash.Hash
+}
+
+func (s HMACScheme) Version() string { return s.Name }
+
+func (s HMACScheme) Sign(secret string, message []byte) string {
+	mac := hmac.New(s.Hash, []byte(secret))
+	mac.Write(message)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSigner implements StreamingScheme.
+func (s HMACScheme) NewSigner(secret string) Signer {
+	return hmacSigner{hmac.New(s.Hash, []byte(secret))}
+}
+
+type hmacSigner struct{ hash.Hash }
+
+func (s hmacSigner) Signature() string { return hex.EncodeToString(s.Sum(nil)) }
+
+// Built-in schemes. SchemeSHA1 exists for legacy senders only and is not
+// accepted unless a Verifier lists it explicitly.
+var (
+	SchemeV1   SignatureScheme = HMACScheme{Name: "v1", Hash: sha256.New}
+	SchemeV2   SignatureScheme = HMACScheme{Name: "v2", Hash: sha512.New}
+	SchemeSHA1 SignatureScheme = HMACScheme{Name: "sha1", Hash: sha1.New}
+)
+
+// DefaultSchemes are accepted when Verifier.Schemes is empty.
+var DefaultSchemes = []SignatureScheme{SchemeV1, SchemeV2}
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = map[string]SignatureScheme{
+		SchemeV1.Version():   SchemeV1,
+		SchemeV2.Version():   SchemeV2,
+		SchemeSHA1.Version(): SchemeSHA1,
+	}
+)
+
+// Register makes a scheme available to Lookup under its version, replacing
+// any scheme already registered there.
+func Register(s SignatureScheme) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	schemes[s.Version()] = s
+}
+
+// Lookup returns the registered scheme for version.
+func Lookup(version string) (SignatureScheme, bool) {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	s, ok := schemes[version]
+	return s, ok
+}
+
+// Verifier checks webhook signatures. The zero value of every field except
+// Secret falls back to the matching default, so a Verifier can be built
+// with New or as a struct literal.
+type Verifier struct {
+	// Secret is the webhook secret exactly as Codehooks returned it, 64
+	// hex characters. It is the HMAC key as is; there is no prefix to add
+	// or strip.
+	Secret string
+
+	// Secrets are further secrets accepted while a secret is rotated,
+	// tried in order after Secret. List the newest first.
+	Secrets []string
+
+	// Tolerance is how far the timestamp may be from the current time, in
+	// either direction.
+	Tolerance time.Duration
+
+	// SignatureHeader and TimestampHeader name the headers VerifyRequest
+	// reads.
+	SignatureHeader string
+	TimestampHeader string
+
+	// Schemes are the signature versions accepted, DefaultSchemes when
+	// empty. Signatures with other versions are ignored.
+	Schemes []SignatureScheme
+
+	// Clock supplies the current time for the tolerance check; the wall
+	// clock is used when it is nil. Any webhookclock.Clock fits.
+	Clock Clock
+
+	// Replays, if set, rejects a delivery that was accepted before, so a
+	// captured delivery cannot be replayed within the tolerance window.
+	// Senders must sign each retry anew, as Codehooks does.
+	Replays ReplayCache
+}
+
+// Clock is the part of webhookclock.Clock a Verifier needs. It is declared
+// here so the package can be copied on its own.
+type Clock interface {
+	Now() time.Time
+}
+
+// New returns a Verifier for secret with the default tolerance and header
+// names filled in.
+func New(secret string) *Verifier {
+	return &Verifier{
+		Secret:          secret,
+		Tolerance:       DefaultTolerance,
+		SignatureHeader: DefaultSignatureHeader,
+		TimestampHeader: DefaultTimestampHeader,
+	}
+}
+
+// Result describes an authentic delivery.
+type Result struct {
+	// SecretIndex is the position of the matching secret: 0 for Secret,
+	// 1 for Secrets[0], and so on.
+	SecretIndex int
+
+	// Rotated reports that a secret other than Secret matched, so the
+	// sender has not switched to the current secret yet.
+	Rotated bool
+
+	// Scheme is the version of the signature that matched, such as "v1".
+	Scheme string
+
+	// Timestamp is the signed delivery time.
+	Timestamp time.Time
+}
+
+// Headers returns the signature and timestamp headers of r, using the
+// configured header names.
+func (v *Verifier) Headers(r *http.Request) (signature, timestamp string) {
+	sigHeader, tsHeader := v.SignatureHeader, v.TimestampHeader
+	if sigHeader == "" {
+		sigHeader = DefaultSignatureHeader
+	}
+	if tsHeader == "" {
+		tsHeader = DefaultTimestampHeader
+	}
+	return r.Header.Get(sigHeader), r.Header.Get(tsHeader)
+}
+
+// VerifyRequest verifies body against the signature headers of r. The body
+// must be the exact bytes received; the request body itself is not read.
+func (v *Verifier) VerifyRequest(r *http.Request, body []byte) error {
+	_, err := v.CheckRequest(r, body)
+	return err
+}
+
+// CheckRequest is VerifyRequest that also reports which secret and scheme
+// matched.
+func (v *Verifier) CheckRequest(r *http.Request, body []byte) (Result, error) {
+	signature, timestamp := v.Headers(r)
+	return v.check(r.Context(), body, signature, timestamp)
+}
+
+// Verify checks that signature, a comma-separated list of
+// "version=signature" pairs, has a match for payload signed at timestamp
+// under one of the accepted schemes and secrets, and that timestamp is
+// within the tolerance window. It returns nil for an authentic delivery.
+func (v *Verifier) Verify(payload []byte, signature, timestamp string) error {
+	_, err := v.Check(payload, signature, timestamp)
+	return err
+}
+
+// Check is Verify that also reports which secret and scheme matched.
+func (v *Verifier) Check(payload []byte, signature, timestamp string) (Result, error) {
+	return v.check(context.Background(), payload, signature, timestamp)
+}
+
+func (v *Verifier) check(ctx context.Context, payload []byte, signature, timestamp string) (Result, error) {
+	h, err := v.parseHeaders(signature, timestamp)
+	if err != nil {
+		return Result{}, err
+	}
+	message := signedMessage(h.timestamp, payload)
+	for _, c := range h.candidates {
+		for i, secret := range v.secrets() {
+			if subtle.ConstantTimeCompare([]byte(c.scheme.Sign(secret, message)), []byte(c.sig)) == 1 {
+				return v.accept(ctx, h, c, i, bodySum(payload))
+			}
+		}
+	}
+	return Result{}, ErrSignatureMismatch
+}
+
+// signedHeaders is what the signature headers of a delivery say, checked
+// as far as possible without the body.
+type signedHeaders struct {
+	timestamp  int64
+	tolerance  time.Duration
+	candidates []candidate
+}
+
+// candidate is one signature of the header under an accepted scheme.
+type candidate struct {
+	version string
+	sig     string
+	scheme  SignatureScheme
+}
+
+func (v *Verifier) parseHeaders(signature, timestamp string) (signedHeaders, error) {
+	if signature == "" || timestamp == "" {
+		return signedHeaders{}, ErrMissingHeader
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return signedHeaders{}, errInvalidTimestamp
+	}
+
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	now := time.Now()
+	if v.Clock != nil {
+		now = v.Clock.Now()
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > tolerance || skew < -tolerance {
+		return signedHeaders{}, ErrStaleTimestamp
+	}
+
+	accepted := v.Schemes
+	if len(accepted) == 0 {
+		accepted = DefaultSchemes
+	}
+	h := signedHeaders{timestamp: ts, tolerance: tolerance}
+	for _, part := range strings.Split(signature, ",") {
+		version, sig, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		for _, scheme := range accepted {
+			if scheme.Version() == version {
+				h.candidates = append(h.candidates, candidate{version: version, sig: sig, scheme: scheme})
+			}
+		}
+	}
+	if len(h.candidates) == 0 {
+		return signedHeaders{}, ErrUnknownScheme
+	}
+	return h, nil
+}
+
+// secrets lists Secret followed by Secrets.
+func (v *Verifier) secrets() []string {
+	return append([]string{v.Secret}, v.Secrets...)
+}
+
+// accept finishes a delivery whose candidate signature c matched the
+// secret at index i. sum is the body digest.
+func (v *Verifier) accept(ctx context.Context, h signedHeaders, c candidate, i int, sum []byte) (Result, error) {
+	signedAt := time.Unix(h.timestamp, 0)
+	if err := checkReplay(ctx, v.Replays, v.Name(), signedAt, h.tolerance, sum); err != nil {
+		return Result{}, err
+	}
+	return Result{SecretIndex: i, Rotated: i > 0, Scheme: c.version, Timestamp: signedAt}, nil
+}
+
+func signedMessage(timestamp int64, payload []byte) []byte {
+	ts := strconv.FormatInt(timestamp, 10)
+	message := make([]byte, 0, len(ts)+1+len(payload))
+	message = append(message, ts...)
+	message = append(message, '.')
+	return append(message, payload...)
+}
+
+// Sign returns the X-Webhook-Signature value for payload signed with secret
+// at timestamp (Unix seconds), using SchemeV1.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	return SignWith(secret, timestamp, payload, SchemeV1)
+}
+
+// SignWith returns the X-Webhook-Signature value for payload with one
+// signature per scheme, in the order given.
+func SignWith(secret string, timestamp int64, payload []byte, schemes ...SignatureScheme) string {
+	message := signedMessage(timestamp, payload)
+	parts := make([]string, len(schemes))
+	for i, scheme := range schemes {
+		parts[i] = scheme.Version() + "=" + scheme.Sign(secret, message)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,235 @@
+package webhookverify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+const (
+	secret  = "0123456789abcdef0123456789abcdef"
+	rotated = "fedcba9876543210fedcba9876543210"
+)
+
+var (
+	now  = time.Unix(1700000000, 0)
+	body = []byte(`{"id":"evt_1","type":"order.created","data":{}}`)
+)
+
+func TestVerify(t *testing.T) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	tests := []struct {
+		name      string
+		secrets   []string
+		schemes   []SignatureScheme
+		signature string
+		timestamp string
+		payload   []byte
+		wantErr   error
+		want      Result
+	}{
+		{
+			name:      "valid v1",
+			signature: Sign(secret, now.Unix(), body),
+			want:      Result{Scheme: "v1"},
+		},
+		{
+			name:      "valid v2",
+			signature: SignWith(secret, now.Unix(), body, SchemeV2),
+			want:      Result{Scheme: "v2"},
+		},
+		{
+			name:      "unknown scheme listed first",
+			signature: "v9=abc," + Sign(secret, now.Unix(), body),
+			want:      Result{Scheme: "v1"},
+		},
+		{
+			name:      "rotated secret",
+			secrets:   []string{rotated},
+			signature: Sign(rotated, now.Unix(), body),
+			want:      Result{SecretIndex: 1, Rotated: true, Scheme: "v1"},
+		},
+		{
+			name:      "tampered payload",
+			signature: Sign(secret, now.Unix(), body),
+			payload:   []byte(`{"id":"evt_1","type":"order.created","data":{"amount":1}}`),
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			name:      "wrong secret",
+			signature: Sign(rotated, now.Unix(), body),
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			name:      "scheme not accepted",
+			schemes:   []SignatureScheme{SchemeV2},
+			signature: Sign(secret, now.Unix(), body),
+			wantErr:   ErrUnknownScheme,
+		},
+		{
+			name:      "signature without version",
+			signature: Sign(secret, now.Unix(), body)[len("v1="):],
+			wantErr:   ErrUnknownScheme,
+		},
+		{
+			name:      "missing signature",
+			signature: "",
+			wantErr:   ErrMissingHeader,
+		},
+		{
+			name:      "missing timestamp",
+			signature: Sign(secret, now.Unix(), body),
+			timestamp: "-",
+			wantErr:   ErrMissingHeader,
+		},
+		{
+			name:      "timestamp not Unix seconds",
+			signature: Sign(secret, now.Unix(), body),
+			timestamp: now.Format(time.RFC3339),
+			wantErr:   ErrMalformedHeader,
+		},
+		{
+			name:      "stale timestamp",
+			signature: Sign(secret, now.Add(-6*time.Minute).Unix(), body),
+			timestamp: strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10),
+			wantErr:   ErrStaleTimestamp,
+		},
+		{
+			name:      "future timestamp",
+			signature: Sign(secret, now.Add(6*time.Minute).Unix(), body),
+			timestamp: strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10),
+			wantErr:   ErrStaleTimestamp,
+		},
+		{
+			name:      "within tolerance",
+			signature: Sign(secret, now.Add(-4*time.Minute).Unix(), body),
+			timestamp: strconv.FormatInt(now.Add(-4*time.Minute).Unix(), 10),
+			want:      Result{Scheme: "v1", Timestamp: now.Add(-4 * time.Minute)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New(secret)
+			v.Secrets = tt.secrets
+			v.Schemes = tt.schemes
+			v.Clock = fixedClock(now)
+			timestamp, payload := tt.timestamp, tt.payload
+			switch timestamp {
+			case "":
+				timestamp = ts
+			case "-":
+				timestamp = ""
+			}
+			if payload == nil {
+				payload = body
+			}
+
+			got, err := v.Check(payload, tt.signature, timestamp)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			want := tt.want
+			if want.Timestamp.IsZero() {
+				want.Timestamp = now
+			}
+			if got != want {
+				t.Errorf("Check() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestStreamMatchesCheckRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		written   []byte
+		wantErr   error
+	}{
+		{"valid", SignWith(secret, now.Unix(), body, SchemeV1, SchemeV2), body, nil},
+		{"tampered", Sign(secret, now.Unix(), body), append([]byte(" "), body...), ErrSignatureMismatch},
+		{"truncated", Sign(secret, now.Unix(), body), body[:10], ErrSignatureMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New(secret)
+			v.Clock = fixedClock(now)
+			r := httptest.NewRequest("POST", "/webhook", bytes.NewReader(tt.written))
+			r.Header.Set(DefaultSignatureHeader, tt.signature)
+			r.Header.Set(DefaultTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+
+			stream, err := v.NewStream(r)
+			if err != nil {
+				t.Fatalf("NewStream() error = %v", err)
+			}
+			read, err := io.ReadAll(io.TeeReader(r.Body, stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, streamErr := stream.Check(context.Background())
+			_, bufferedErr := v.CheckRequest(r, read)
+			if !errors.Is(streamErr, tt.wantErr) || !errors.Is(bufferedErr, tt.wantErr) {
+				t.Errorf("Stream.Check() = %v, CheckRequest() = %v, want %v", streamErr, bufferedErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplays(t *testing.T) {
+	clock := fixedClock(now)
+	v := New(secret)
+	v.Secrets = []string{rotated}
+	v.Clock = clock
+	v.Replays = &MemoryReplayCache{Clock: clock}
+
+	other := []byte(`{"id":"evt_2","type":"order.created","data":{}}`)
+	later := now.Add(time.Second)
+	steps := []struct {
+		name      string
+		at        time.Time
+		payload   []byte
+		signature string
+		stream    bool
+		wantErr   error
+	}{
+		{"first delivery", now, body, SignWith(secret, now.Unix(), body, SchemeV1, SchemeV2), false, nil},
+		{"replayed", now, body, SignWith(secret, now.Unix(), body, SchemeV1, SchemeV2), false, ErrReplayed},
+		{"replayed with the v2 signature only", now, body, SignWith(secret, now.Unix(), body, SchemeV2), false, ErrReplayed},
+		{"replayed with the rotated secret's signature", now, body, Sign(rotated, now.Unix(), body), false, ErrReplayed},
+		{"replayed through a stream", now, body, SignWith(secret, now.Unix(), body, SchemeV2), true, ErrReplayed},
+		{"another event in the same second", now, other, Sign(secret, now.Unix(), other), false, nil},
+		{"retry signed anew", later, body, Sign(secret, later.Unix(), body), true, nil},
+		{"retry replayed", later, body, Sign(secret, later.Unix(), body), false, ErrReplayed},
+	}
+	for _, step := range steps {
+		ts := strconv.FormatInt(step.at.Unix(), 10)
+		var err error
+		if step.stream {
+			r := httptest.NewRequest("POST", "/webhook", bytes.NewReader(step.payload))
+			r.Header.Set("X-Webhook-Signature", step.signature)
+			r.Header.Set("X-Webhook-Timestamp", ts)
+			var stream *Stream
+			if stream, err = v.NewStream(r); err == nil {
+				io.Copy(stream, r.Body)
+				_, err = stream.Check(context.Background())
+			}
+		} else {
+			err = v.Verify(step.payload, step.signature, ts)
+		}
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("%s: error = %v, want %v", step.name, err, step.wantErr)
+		}
+	}
+}
@@ -620,10 +620,25 @@ async function deliverWebhook(webhook, eventData) {
   }, eventPayload);
 
   if (response.statusCode < 200 || response.statusCode >= 300) {
-    throw new Error(`HTTP ${response.statusCode}: ${response.statusMessage}`);
+    throw new Error(`HTTP ${response.statusCode}: ${response.statusMessage}${describeRejection(response.body)}`);
   }
 }
 
+// Helper function: Summarize a receiver's JSON rejection body, such as
+// {"code": "stale_timestamp", "hint": "..."}, for lastDeliveryError
+function describeRejection(body) {
+  try {
+    const rejection = JSON.parse(body);
+    if (rejection && typeof rejection.code === 'string') {
+      const detail = rejection.hint || rejection.message;
+      return ` (${rejection.code}${detail ? `: ${detail}` : ''})`.slice(0, 500);
+    }
+  } catch {
+    // Not a structured rejection
+  }
+  return '';
+}
+
 // Worker function: Process webhook deliveries from queue
 async function webhookDeliveryWorker(req, res) {
   try {
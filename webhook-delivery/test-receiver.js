@@ -17,7 +17,7 @@ const app = express();
 const PORT = process.env.PORT || 3000;
 
 // Your webhook secret (get this from the webhook registration response)
-const WEBHOOK_SECRET = process.env.WEBHOOK_SECRET || 'whsec_your_secret_here';
+const WEBHOOK_SECRET = process.env.WEBHOOK_SECRET || 'your_secret_here';
 
 // Store received webhooks for inspection
 const receivedWebhooks = [];
@@ -159,7 +159,7 @@ app.get('/', (req, res) => {
     },
     stats: {
       received: receivedWebhooks.length,
-      secret_configured: WEBHOOK_SECRET !== 'whsec_your_secret_here'
+      secret_configured: WEBHOOK_SECRET !== 'your_secret_here'
     }
   });
 });
@@ -183,7 +183,7 @@ app.listen(PORT, () => {
   console.log('');
   console.log('⚙️  Configuration:');
   console.log(`   Port: ${PORT}`);
-  console.log(`   Secret configured: ${WEBHOOK_SECRET !== 'whsec_your_secret_here' ? '✅' : '❌ (using default)'}`);
+  console.log(`   Secret configured: ${WEBHOOK_SECRET !== 'your_secret_here' ? '✅' : '❌ (using default)'}`);
   console.log('');
   console.log('━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━');
   console.log('');